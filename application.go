@@ -48,6 +48,7 @@ type ApplicationSpec struct {
 	// Depends		[]
 	Children  []ApplicationChildSpec
 	startType ApplicationStartType
+	started   uint64 // start order
 	app       ApplicationBehaviour
 	process   *Process
 	mutex     sync.Mutex
@@ -88,13 +89,13 @@ func (a *Application) Loop(p *Process, args ...interface{}) string {
 		return "failed"
 	}
 
-	p.currentFunction = "Application:Start"
+	p.setCurrentFunction("Application:Start")
 
 	object.(ApplicationBehaviour).Start(p, args[1:]...)
 	lib.Log("Application spec %#v\n", spec)
 	p.ready <- nil
 
-	p.currentFunction = "Application:loop"
+	p.setCurrentFunction("Application:loop")
 
 	if spec.Lifespan == 0 {
 		spec.Lifespan = time.Second * 31536000 * 100 // let's define default lifespan 100 years :)
@@ -141,7 +142,7 @@ func (a *Application) Loop(p *Process, args ...interface{}) string {
 			}
 			terminated := r.Element(2).(etf.Pid)
 			terminatedName := terminated.Str()
-			reason := r.Element(3).(etf.Atom)
+			reason := etf.Atom(exitReason(r.Element(3)))
 			alienPid := true
 
 			for i := range spec.Children {
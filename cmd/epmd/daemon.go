@@ -0,0 +1,11 @@
+// +build !windows
+
+package main
+
+import "syscall"
+
+// daemonSysProcAttr starts the daemon in a new session so it gets
+// detached from the controlling terminal
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,11 @@
+// +build windows
+
+package main
+
+import "syscall"
+
+// daemonSysProcAttr starts the daemon in a new process group so it doesn't
+// get the console signals (Ctrl+C) of the parent
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
@@ -5,22 +5,38 @@ import (
 	"encoding/binary"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"os/exec"
 	"strconv"
+	"strings"
 
 	"github.com/halturin/ergo/dist"
 )
 
 var (
-	Names  bool
-	Listen int    = 4369
-	Host   string = "127.0.0.1"
-	Port   int    = 4369
+	Names   bool
+	Dump    bool
+	Kill    bool
+	Stop    string
+	Daemon  bool
+	Relaxed bool
+	Listen  int    = 4369
+	Address string = ""
+	Host    string = "127.0.0.1"
+	Port    int    = 4369
 )
 
 func init() {
 	flag.IntVar(&Listen, "listen", 4369, "Let epmd listen to another port than default 4369")
+	flag.StringVar(&Address, "address", os.Getenv("ERL_EPMD_ADDRESS"), "Comma separated list of the addresses to listen on (all by default). Loopback is added implicitly")
+	flag.BoolVar(&Daemon, "daemon", false, "Start epmd detached from the controlling terminal")
+	flag.BoolVar(&Relaxed, "relaxed_command_check", os.Getenv("ERL_EPMD_RELAXED_COMMAND_CHECK") != "", "Allow -kill with the registered nodes and -stop commands")
 	flag.BoolVar(&Names, "names", false, "List names registered with the currently running epmd")
+	flag.BoolVar(&Dump, "dump", false, "Dump the state of the currently running epmd (local connections only)")
+	flag.BoolVar(&Kill, "kill", false, "Kill the currently running epmd (local connections only)")
+	flag.StringVar(&Stop, "stop", "", "Forcibly unregister the given name (requires -relaxed_command_check on the running epmd)")
 	flag.StringVar(&Host, "epmd", "127.0.0.1", "(for commands) Hostname with running epmd server")
 	flag.IntVar(&Port, "port", 4369, "(for commands) Port with running epmd server")
 
@@ -29,20 +45,56 @@ func init() {
 func main() {
 	flag.Parse()
 
-	if Names {
-		getNames()
+	switch {
+	case Names:
+		getNames(dist.EPMD_NAMES_REQ)
+		return
+	case Dump:
+		getNames(dist.EPMD_DUMP_REQ)
+		return
+	case Kill:
+		kill()
+		return
+	case Stop != "":
+		stop(Stop)
+		return
+	case Daemon:
+		daemonize()
 		return
 	}
 
-	if err := dist.Server(context.TODO(), uint16(Listen)); err != nil {
+	options := dist.ServerOptions{
+		Address:             Address,
+		Port:                uint16(Listen),
+		RelaxedCommandCheck: Relaxed,
+	}
+	killed, err := dist.ServerWithOptions(context.TODO(), options)
+	if err != nil {
 		panic(err)
 	}
 
-	// just sleep forever. until somebody kill this process
-	select {}
+	// wait until somebody kill this process or send KILL_REQ
+	<-killed
+}
+
+func daemonize() {
+	// run itself again without the -daemon flag
+	args := []string{}
+	for _, arg := range os.Args[1:] {
+		if strings.TrimLeft(arg, "-") == "daemon" || strings.HasPrefix(strings.TrimLeft(arg, "-"), "daemon=") {
+			continue
+		}
+		args = append(args, arg)
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.SysProcAttr = daemonSysProcAttr()
+	if err := cmd.Start(); err != nil {
+		panic(err)
+	}
+	cmd.Process.Release()
 }
 
-func getNames() {
+func request(command byte, payload string) []byte {
 	dsn := net.JoinHostPort(Host, strconv.Itoa(int(Port)))
 	conn, err := net.Dial("tcp", dsn)
 	if err != nil {
@@ -51,25 +103,44 @@ func getNames() {
 
 	defer conn.Close()
 
-	buf := make([]byte, 2048)
-	buf[1] = 1
-	buf[2] = dist.EPMD_NAMES_REQ
-	conn.Write(buf[0:3])
-	if n, err := conn.Read(buf); n < 4 || err != nil {
+	buf := make([]byte, 3+len(payload))
+	binary.BigEndian.PutUint16(buf[0:2], uint16(1+len(payload)))
+	buf[2] = command
+	copy(buf[3:], payload)
+	if _, err := conn.Write(buf); err != nil {
+		panic(err)
+	}
+	// epmd closes the connection after the reply
+	reply, _ := ioutil.ReadAll(conn)
+	return reply
+}
+
+func getNames(command byte) {
+	reply := request(command, "")
+	if len(reply) < 4 {
 		panic("malformed response from epmd")
-	} else {
-		fmt.Printf("epmd: up and running on port %d with data:\n", binary.BigEndian.Uint32(buf[0:4]))
-		fmt.Printf("%s", string(buf[4:]))
-		buf = buf[n:]
-
-		for {
-			n, err = conn.Read(buf)
-			if err != nil || n == 0 {
-				break
-			}
-			fmt.Printf("%s", string(buf))
-			buf = buf[len(buf):]
-		}
+	}
+	fmt.Printf("epmd: up and running on port %d with data:\n", binary.BigEndian.Uint32(reply[0:4]))
+	fmt.Printf("%s", string(reply[4:]))
+}
+
+func kill() {
+	switch reply := string(request(dist.EPMD_KILL_REQ, "")); reply {
+	case "OK":
+		fmt.Println("Killed")
+	case "NO":
+		fmt.Println("Killing not allowed - living nodes in database.")
+	default:
+		fmt.Println("epmd: kill request not accepted")
+		os.Exit(1)
+	}
+}
 
+func stop(name string) {
+	reply := string(request(dist.EPMD_STOP_REQ, name))
+	if reply == "" {
+		fmt.Println("epmd: stop request not accepted")
+		os.Exit(1)
 	}
+	fmt.Println(reply)
 }
@@ -0,0 +1,251 @@
+package ergo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/halturin/ergo/lib"
+)
+
+// ConnectionHooks defines the callbacks on the lifecycle of the connections.
+// They are called synchronously so they must not block.
+type ConnectionHooks struct {
+	// Connecting is called before the node dials the peer
+	Connecting func(name string)
+	// Connected is called when the connection has been established
+	// (incoming or outgoing)
+	Connected func(name string)
+	// HandshakeFailed is called if the node couldn't connect to the peer
+	// (can't resolve its port, dial or make a handshake)
+	HandshakeFailed func(name string, err error)
+	// Disconnected is called when the connection has been closed. Reason is
+	// one of connection_closed, net_tick_timeout, disconnect or send_queue_overflow
+	Disconnected func(name string, reason string)
+}
+
+// connectionManager dials the peers. It coalesces the concurrent dials to the
+// same node, keeps the "always connected" peers (reconnecting with exponential
+// backoff) and makes the sends to the unreachable node fail fast until
+// the backoff interval is over.
+type connectionManager struct {
+	node  *Node
+	hooks ConnectionHooks
+
+	backoffMin time.Duration
+	backoffMax time.Duration
+
+	mutex      sync.Mutex
+	dialing    map[string]*connectionDial
+	failed     map[string]*connectionBackoff
+	persistent map[string]*connectionPersistent
+}
+
+type connectionDial struct {
+	done chan struct{}
+	err  error
+}
+
+type connectionBackoff struct {
+	delay time.Duration
+	until time.Time
+}
+
+type connectionPersistent struct {
+	cancel context.CancelFunc
+	down   chan struct{}
+	// the connection is kept while it's requested by the user
+	// (Node.KeepConnected) or by the discovery
+	byUser      bool
+	byDiscovery bool
+}
+
+func createConnectionManager(node *Node, opts NodeOptions) *connectionManager {
+	return &connectionManager{
+		node:       node,
+		hooks:      opts.ConnectionHooks,
+		backoffMin: opts.ReconnectBackoffMin,
+		backoffMax: opts.ReconnectBackoffMax,
+		dialing:    make(map[string]*connectionDial),
+		failed:     make(map[string]*connectionBackoff),
+		persistent: make(map[string]*connectionPersistent),
+	}
+}
+
+// connect makes connection to the given node. Concurrent calls for the same
+// node are waiting for the result of the single dial. Returns
+// ErrNodeUnreachable if the last attempt has failed and the backoff
+// interval is not over yet.
+func (cm *connectionManager) connect(name string) error {
+	cm.mutex.Lock()
+	if cm.node.registrar.GetPeer(name) != nil {
+		cm.mutex.Unlock()
+		return nil
+	}
+	if d, ok := cm.dialing[name]; ok {
+		cm.mutex.Unlock()
+		<-d.done
+		return d.err
+	}
+	if b, ok := cm.failed[name]; ok && time.Now().Before(b.until) {
+		cm.mutex.Unlock()
+		return ErrNodeUnreachable
+	}
+	d := &connectionDial{
+		done: make(chan struct{}),
+	}
+	cm.dialing[name] = d
+	cm.mutex.Unlock()
+
+	if cm.hooks.Connecting != nil {
+		cm.hooks.Connecting(name)
+	}
+
+	err := cm.node.dial(name)
+	if err != nil && cm.node.registrar.GetPeer(name) != nil {
+		// the peer has connected to us at the same time
+		err = nil
+	}
+
+	cm.mutex.Lock()
+	delete(cm.dialing, name)
+	if err == nil {
+		delete(cm.failed, name)
+	} else {
+		b, ok := cm.failed[name]
+		if !ok {
+			b = &connectionBackoff{}
+			cm.failed[name] = b
+		}
+		b.delay *= 2
+		if b.delay < cm.backoffMin {
+			b.delay = cm.backoffMin
+		}
+		if b.delay > cm.backoffMax {
+			b.delay = cm.backoffMax
+		}
+		b.until = time.Now().Add(b.delay)
+	}
+	cm.mutex.Unlock()
+
+	if err != nil {
+		lib.Log("[%s] can't connect to %s: %s", cm.node.FullName, name, err)
+		if cm.hooks.HandshakeFailed != nil {
+			cm.hooks.HandshakeFailed(name, err)
+		}
+	}
+	d.err = err
+	close(d.done)
+	return err
+}
+
+// retryIn returns the time left until the end of the backoff interval
+func (cm *connectionManager) retryIn(name string) time.Duration {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	b, ok := cm.failed[name]
+	if !ok {
+		return 0
+	}
+	return time.Until(b.until)
+}
+
+func (cm *connectionManager) keepConnected(name string, discovery bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if p, ok := cm.persistent[name]; ok {
+		p.setOwner(discovery, true)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(cm.node.context)
+	p := &connectionPersistent{
+		cancel: cancel,
+		down:   make(chan struct{}, 1),
+	}
+	p.setOwner(discovery, true)
+	cm.persistent[name] = p
+
+	go func() {
+		for {
+			// drop the notification about the previous connection
+			select {
+			case <-p.down:
+			default:
+			}
+
+			wait := time.Duration(0)
+			if err := cm.connect(name); err != nil {
+				wait = cm.retryIn(name)
+				if wait <= 0 {
+					wait = cm.backoffMin
+				}
+			}
+
+			if wait == 0 {
+				// connected. wait for disconnect
+				select {
+				case <-p.down:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stopKeepConnected cancels keeping the connection once neither the user
+// nor the discovery requests it
+func (cm *connectionManager) stopKeepConnected(name string, discovery bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	p, ok := cm.persistent[name]
+	if !ok {
+		return
+	}
+	p.setOwner(discovery, false)
+	if p.byUser || p.byDiscovery {
+		return
+	}
+	p.cancel()
+	delete(cm.persistent, name)
+}
+
+func (p *connectionPersistent) setOwner(discovery bool, value bool) {
+	if discovery {
+		p.byDiscovery = value
+		return
+	}
+	p.byUser = value
+}
+
+func (cm *connectionManager) connected(name string) {
+	if cm.hooks.Connected != nil {
+		cm.hooks.Connected(name)
+	}
+}
+
+func (cm *connectionManager) disconnected(name string, reason string) {
+	cm.mutex.Lock()
+	if p, ok := cm.persistent[name]; ok {
+		select {
+		case p.down <- struct{}{}:
+		default:
+		}
+	}
+	cm.mutex.Unlock()
+
+	if cm.hooks.Disconnected != nil {
+		cm.hooks.Disconnected(name, reason)
+	}
+}
@@ -0,0 +1,154 @@
+package ergo
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestConnectionManager(t *testing.T) {
+	fmt.Printf("\n=== Test Connection Manager\n")
+	events := make(chan string, 100)
+	opts := NodeOptions{
+		ReconnectBackoffMin: 100 * time.Millisecond,
+		ReconnectBackoffMax: 400 * time.Millisecond,
+		ConnectionHooks: ConnectionHooks{
+			Connecting: func(name string) {
+				events <- "connecting " + name
+			},
+			Connected: func(name string) {
+				events <- "connected " + name
+			},
+			HandshakeFailed: func(name string, err error) {
+				events <- "failed " + name
+			},
+			Disconnected: func(name string, reason string) {
+				events <- "disconnected " + name + " " + reason
+			},
+		},
+	}
+	fmt.Printf("Starting nodes: nodeCM1@localhost (with hooks), nodeCM2@localhost: ")
+	node1, _ := CreateNode("nodeCM1@localhost", "cookies", opts)
+	node2, _ := CreateNode("nodeCM2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    concurrent connects are coalesced: ")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	waitForEvents(t, events, "connecting nodeCM2@localhost", "connected nodeCM2@localhost")
+
+	fmt.Printf("    disconnect: ")
+	if err := node1.Disconnect(node2.FullName); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvents(t, events, "disconnected nodeCM2@localhost disconnect")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    send to unreachable node: ")
+	to := etf.Tuple{etf.Atom("gs"), etf.Atom("nodeCMUnknown@localhost")}
+	if err := node1gs1.Send(to, etf.Atom("hi")); err != ErrNodeUnreachable {
+		t.Fatal("expected", ErrNodeUnreachable, "got", err)
+	}
+	waitForEvents(t, events, "connecting nodeCMUnknown@localhost", "failed nodeCMUnknown@localhost")
+
+	fmt.Printf("    send within backoff interval fails without dialing: ")
+	if err := node1gs1.Send(to, etf.Atom("hi")); err != ErrNodeUnreachable {
+		t.Fatal("expected", ErrNodeUnreachable, "got", err)
+	}
+	if _, err := node1gs1.Call(to, etf.Atom("hi")); err != ErrNodeUnreachable {
+		t.Fatal("expected", ErrNodeUnreachable, "got", err)
+	}
+	timeout := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case e := <-events:
+			if strings.HasSuffix(e, "nodeCMUnknown@localhost") {
+				t.Fatal("unexpected event", e)
+			}
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    keep connected with the node which is not started yet: ")
+	node1.KeepConnected("nodeCM3@localhost")
+	defer node1.StopKeepConnected("nodeCM3@localhost")
+	waitForEvents(t, events, "connecting nodeCM3@localhost", "failed nodeCM3@localhost")
+
+	fmt.Printf("    start nodeCM3@localhost and wait for connection: ")
+	node3, _ := CreateNode("nodeCM3@localhost", "cookies", NodeOptions{})
+	if node3 == nil {
+		t.Fatal("can't start node")
+	}
+	defer node3.Stop()
+	waitForEvent(t, events, "connected nodeCM3@localhost")
+
+	fmt.Printf("    connection must be restored after disconnect: ")
+	if err := node1.Disconnect(node3.FullName); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvents(t, events, "disconnected nodeCM3@localhost disconnect",
+		"connecting nodeCM3@localhost", "connected nodeCM3@localhost")
+}
+
+// waitForEvents expects the given events in the given order. Events of
+// the other nodes are skipped
+func waitForEvents(t *testing.T, events chan string, expected ...string) {
+	for _, e := range expected {
+	next:
+		select {
+		case v := <-events:
+			if strings.Fields(v)[1] != strings.Fields(e)[1] {
+				goto next
+			}
+			if v != e {
+				t.Fatal("expected", e, "got", v)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("event timeout. expected", e)
+		}
+	}
+	fmt.Println("OK")
+}
+
+// waitForEvent skips the events until the expected one
+func waitForEvent(t *testing.T, events chan string, expected string) {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case v := <-events:
+			if v == expected {
+				fmt.Println("OK")
+				return
+			}
+		case <-timeout:
+			t.Fatal("event timeout. expected", expected)
+		}
+	}
+}
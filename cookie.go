@@ -0,0 +1,101 @@
+package ergo
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const cookieFileName = ".erlang.cookie"
+
+// SetCookie sets the default cookie of the node (in fashion of erlang:set_cookie/1).
+// It's used for the new connections with the nodes which have no own cookie.
+func (n *Node) SetCookie(cookie string) {
+	n.cookieMutex.Lock()
+	defer n.cookieMutex.Unlock()
+	n.Cookie = cookie
+}
+
+// GetCookie returns the default cookie of the node
+func (n *Node) GetCookie() string {
+	n.cookieMutex.RLock()
+	defer n.cookieMutex.RUnlock()
+	return n.Cookie
+}
+
+// SetNodeCookie sets the cookie for connections with the given node (in
+// fashion of erlang:set_cookie/2). It's used for the outgoing and incoming
+// connections. Empty cookie removes the override.
+func (n *Node) SetNodeCookie(name string, cookie string) {
+	n.cookieMutex.Lock()
+	defer n.cookieMutex.Unlock()
+	if cookie == "" {
+		delete(n.cookies, name)
+		return
+	}
+	n.cookies[name] = cookie
+}
+
+// GetNodeCookie returns the cookie used for connections with the given node
+func (n *Node) GetNodeCookie(name string) string {
+	n.cookieMutex.RLock()
+	defer n.cookieMutex.RUnlock()
+	if cookie, ok := n.cookies[name]; ok {
+		return cookie
+	}
+	return n.Cookie
+}
+
+// readCookieFile reads the cookie from ~/.erlang.cookie. The file is created
+// with a random cookie if it doesn't exist. As well as Erlang it refuses the
+// file accessible by group or others.
+func readCookieFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Can't find cookie file: %s", err)
+	}
+	file := filepath.Join(home, cookieFileName)
+
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return createCookieFile(file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Can't read cookie file %s: %s", file, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("Cookie file %s is not a regular file", file)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("Cookie file %s must be accessible by owner only", file)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("Can't read cookie file %s: %s", file, err)
+	}
+	cookie := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if cookie == "" {
+		return "", fmt.Errorf("Cookie file %s is empty", file)
+	}
+	return cookie, nil
+}
+
+func createCookieFile(file string) (string, error) {
+	// 20 upper case letters like Erlang does
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = 'A' + b[i]%26
+	}
+	if err := ioutil.WriteFile(file, b, 0400); err != nil {
+		return "", fmt.Errorf("Can't create cookie file %s: %s", file, err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,118 @@
+package ergo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestNodeCookie(t *testing.T) {
+	fmt.Printf("\n=== Test Node Cookie\n")
+	opts := NodeOptions{
+		ReconnectBackoffMin: 10 * time.Millisecond,
+		ReconnectBackoffMax: 10 * time.Millisecond,
+	}
+	fmt.Printf("Starting nodes: nodeCookie1@localhost, nodeCookie2@localhost, nodeCookie3@localhost: ")
+	node1, _ := CreateNode("nodeCookie1@localhost", "cookie1", opts)
+	node2, _ := CreateNode("nodeCookie2@localhost", "cookie2", opts)
+	node3, _ := CreateNode("nodeCookie3@localhost", "cookie3", opts)
+	if node1 == nil || node2 == nil || node3 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	defer node3.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    connect with different cookies must fail: ")
+	if err := node1.connect(etf.Atom(node2.FullName)); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodeCookie1 -> nodeCookie2 with the per-node cookie: ")
+	node1.SetNodeCookie(node2.FullName, "cookie2")
+	if node1.GetNodeCookie(node2.FullName) != "cookie2" || node1.GetCookie() != "cookie1" {
+		t.Fatal("wrong cookies")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodeCookie2 -> nodeCookie1 with the per-node cookie: ")
+	if err := node1.Disconnect(node2.FullName); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := node2.connect(etf.Atom(node1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    change the default cookie of nodeCookie3 and connect to nodeCookie2: ")
+	if err := node3.connect(etf.Atom(node2.FullName)); err == nil {
+		t.Fatal("expected error")
+	}
+	node3.SetCookie("cookie2")
+	time.Sleep(20 * time.Millisecond)
+	if err := node3.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    remove the per-node cookie: ")
+	node1.SetNodeCookie(node2.FullName, "")
+	if node1.GetNodeCookie(node2.FullName) != "cookie1" {
+		t.Fatal("wrong cookie")
+	}
+	fmt.Println("OK")
+}
+
+func TestNodeCookieFile(t *testing.T) {
+	fmt.Printf("\n=== Test Node Cookie File\n")
+	home := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	defer os.Setenv("HOME", oldHome)
+	file := filepath.Join(home, cookieFileName)
+
+	fmt.Printf("    create ~/.erlang.cookie if it doesn't exist: ")
+	cookie, err := readCookieFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookie) != 20 || info.Mode().Perm() != 0400 {
+		t.Fatal("wrong cookie file", cookie, info.Mode())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node uses cookie from the file: ")
+	node, _ := CreateNode("nodeCookieFile@localhost", "", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	defer node.Stop()
+	if node.GetCookie() != cookie {
+		t.Fatal("expected", cookie, "got", node.GetCookie())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    file accessible by others must be refused: ")
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCookieFile(); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+}
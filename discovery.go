@@ -0,0 +1,249 @@
+package ergo
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halturin/ergo/lib"
+)
+
+// DiscoveryBackend returns the names of the nodes to connect with
+type DiscoveryBackend interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// DiscoveryOptions defines the discovery of the nodes (in fashion of libcluster).
+// The node keeps connected (see Node.KeepConnected) with the discovered nodes
+type DiscoveryOptions struct {
+	Backends []DiscoveryBackend
+	// Interval between the discovery rounds. Default value is 5 seconds
+	Interval time.Duration
+	// OnChange is called if the list of the discovered nodes has been changed
+	OnChange func(added []string, removed []string)
+}
+
+type discovery struct {
+	node    *Node
+	options DiscoveryOptions
+
+	mutex sync.Mutex
+	// the last successful result of every backend
+	results [][]string
+	nodes   map[string]bool
+}
+
+const defaultDiscoveryInterval = 5 * time.Second
+
+func startDiscovery(node *Node, options DiscoveryOptions) *discovery {
+	if options.Interval == 0 {
+		options.Interval = defaultDiscoveryInterval
+	}
+	d := &discovery{
+		node:    node,
+		options: options,
+		results: make([][]string, len(options.Backends)),
+		nodes:   make(map[string]bool),
+	}
+	if len(options.Backends) == 0 {
+		return d
+	}
+
+	go func() {
+		ticker := time.NewTicker(options.Interval)
+		defer ticker.Stop()
+		for {
+			d.discover()
+			select {
+			case <-node.context.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return d
+}
+
+func (d *discovery) discover() {
+	ctx, cancel := context.WithTimeout(d.node.context, d.options.Interval)
+	defer cancel()
+
+	for i, backend := range d.options.Backends {
+		nodes, err := backend.Discover(ctx)
+		if err != nil {
+			// keep the previous result of this backend
+			lib.Log("[%s] discovery failed: %s", d.node.FullName, err)
+			continue
+		}
+		d.mutex.Lock()
+		d.results[i] = nodes
+		d.mutex.Unlock()
+	}
+
+	discovered := make(map[string]bool)
+	d.mutex.Lock()
+	for _, nodes := range d.results {
+		for _, name := range nodes {
+			if name != "" && name != d.node.FullName {
+				discovered[name] = true
+			}
+		}
+	}
+
+	added := []string{}
+	removed := []string{}
+	for name := range discovered {
+		if !d.nodes[name] {
+			added = append(added, name)
+		}
+	}
+	for name := range d.nodes {
+		if !discovered[name] {
+			removed = append(removed, name)
+		}
+	}
+	d.nodes = discovered
+	d.mutex.Unlock()
+
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	for _, name := range added {
+		lib.Log("[%s] discovered node %s", d.node.FullName, name)
+		d.node.connections.keepConnected(name, true)
+	}
+	for _, name := range removed {
+		lib.Log("[%s] node %s has gone from discovery", d.node.FullName, name)
+		d.node.connections.stopKeepConnected(name, true)
+	}
+	if d.options.OnChange != nil {
+		d.options.OnChange(added, removed)
+	}
+}
+
+func (d *discovery) list() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	list := []string{}
+	for name := range d.nodes {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// StaticDiscovery returns the given list of the seed nodes
+type StaticDiscovery struct {
+	Nodes []string
+}
+
+// Discover implements DiscoveryBackend interface
+func (s *StaticDiscovery) Discover(ctx context.Context) ([]string, error) {
+	return s.Nodes, nil
+}
+
+// DNSResolver makes DNS lookups for DNSDiscovery. *net.Resolver implements it
+type DNSResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSDiscovery discovers the nodes by DNS. The addresses of the A/AAAA records
+// (or the targets of the SRV records) of the Host become the host parts of the
+// node names: Name@address
+type DNSDiscovery struct {
+	// Name is the name part of the discovered nodes
+	Name string
+	// Host to lookup
+	Host string
+	// SRV makes lookup of the SRV records for the Host
+	SRV bool
+	// Resolver is net.DefaultResolver by default
+	Resolver DNSResolver
+}
+
+// Discover implements DiscoveryBackend interface
+func (d *DNSDiscovery) Discover(ctx context.Context) ([]string, error) {
+	var resolver DNSResolver = net.DefaultResolver
+	if d.Resolver != nil {
+		resolver = d.Resolver
+	}
+
+	nodes := []string{}
+	if d.SRV {
+		_, records, err := resolver.LookupSRV(ctx, "", "", d.Host)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			nodes = append(nodes, d.Name+"@"+strings.TrimSuffix(record.Target, "."))
+		}
+		return nodes, nil
+	}
+
+	addresses, err := resolver.LookupHost(ctx, d.Host)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		nodes = append(nodes, d.Name+"@"+address)
+	}
+	return nodes, nil
+}
+
+// FileDiscovery reads the node names from the file (one per line, '#' starts
+// a comment). The file is read again if it has been modified.
+type FileDiscovery struct {
+	Path string
+
+	mutex    sync.Mutex
+	modified time.Time
+	nodes    []string
+}
+
+// Discover implements DiscoveryBackend interface
+func (f *FileDiscovery) Discover(ctx context.Context) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(f.modified) {
+		return f.nodes, nil
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	nodes := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			nodes = append(nodes, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	f.modified = info.ModTime()
+	f.nodes = nodes
+	return nodes, nil
+}
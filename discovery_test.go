@@ -0,0 +1,199 @@
+package ergo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type testDNSResolver struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (r *testDNSResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addresses, ok := r.hosts[host]; ok {
+		return addresses, nil
+	}
+	return nil, fmt.Errorf("no such host")
+}
+
+func (r *testDNSResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if records, ok := r.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, fmt.Errorf("no such host")
+}
+
+func TestDiscoveryBackends(t *testing.T) {
+	fmt.Printf("\n=== Test Discovery Backends\n")
+	ctx := context.Background()
+
+	fmt.Printf("    static: ")
+	static := &StaticDiscovery{Nodes: []string{"a@localhost", "b@localhost"}}
+	if nodes, _ := static.Discover(ctx); !reflect.DeepEqual(nodes, static.Nodes) {
+		t.Fatal("got", nodes)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    DNS A/SRV: ")
+	resolver := &testDNSResolver{
+		hosts: map[string][]string{
+			"app.svc": {"10.0.0.1", "10.0.0.2"},
+		},
+		srv: map[string][]*net.SRV{
+			"app.srv": {
+				{Target: "app-0.app.svc.", Port: 4370},
+				{Target: "app-1.app.svc.", Port: 4370},
+			},
+		},
+	}
+	dns := &DNSDiscovery{Name: "app", Host: "app.svc", Resolver: resolver}
+	nodes, err := dns.Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nodes, []string{"app@10.0.0.1", "app@10.0.0.2"}) {
+		t.Fatal("got", nodes)
+	}
+	srv := &DNSDiscovery{Name: "app", Host: "app.srv", SRV: true, Resolver: resolver}
+	nodes, err = srv.Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nodes, []string{"app@app-0.app.svc", "app@app-1.app.svc"}) {
+		t.Fatal("got", nodes)
+	}
+	if _, err := (&DNSDiscovery{Name: "app", Host: "unknown", Resolver: resolver}).Discover(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    file: ")
+	path := filepath.Join(t.TempDir(), "nodes")
+	if err := ioutil.WriteFile(path, []byte("# seeds\na@localhost\n\n  b@localhost # second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := &FileDiscovery{Path: path}
+	nodes, err = file.Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nodes, []string{"a@localhost", "b@localhost"}) {
+		t.Fatal("got", nodes)
+	}
+	if err := ioutil.WriteFile(path, []byte("c@localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(time.Second)
+	os.Chtimes(path, modified, modified)
+	nodes, err = file.Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nodes, []string{"c@localhost"}) {
+		t.Fatal("got", nodes)
+	}
+	fmt.Println("OK")
+}
+
+func TestDiscovery(t *testing.T) {
+	fmt.Printf("\n=== Test Discovery\n")
+	path := filepath.Join(t.TempDir(), "nodes")
+	if err := ioutil.WriteFile(path, []byte("nodeDiscovery3@localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type change struct {
+		added   []string
+		removed []string
+	}
+	changes := make(chan interface{}, 10)
+	opts := NodeOptions{
+		Discovery: DiscoveryOptions{
+			Backends: []DiscoveryBackend{
+				&StaticDiscovery{Nodes: []string{"nodeDiscovery1@localhost", "nodeDiscovery2@localhost"}},
+				&FileDiscovery{Path: path},
+			},
+			Interval: 100 * time.Millisecond,
+			OnChange: func(added, removed []string) {
+				changes <- change{added, removed}
+			},
+		},
+	}
+
+	fmt.Printf("Starting nodes: nodeDiscovery1@localhost, nodeDiscovery2@localhost, nodeDiscovery3@localhost: ")
+	node2, _ := CreateNode("nodeDiscovery2@localhost", "cookies", NodeOptions{})
+	node3, _ := CreateNode("nodeDiscovery3@localhost", "cookies", NodeOptions{})
+	if node2 == nil || node3 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node2.Stop()
+	defer node3.Stop()
+	node1, _ := CreateNode("nodeDiscovery1@localhost", "cookies", opts)
+	if node1 == nil {
+		t.Fatal("can't start node")
+	}
+	defer node1.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    nodes are discovered: ")
+	waitForResultWithValue(t, changes, change{
+		added:   []string{"nodeDiscovery2@localhost", "nodeDiscovery3@localhost"},
+		removed: []string{},
+	})
+	expected := []string{"nodeDiscovery2@localhost", "nodeDiscovery3@localhost"}
+	if nodes := node1.DiscoveredNodes(); !reflect.DeepEqual(nodes, expected) {
+		t.Fatal("expected", expected, "got", nodes)
+	}
+
+	fmt.Printf("    discovered nodes are connected: ")
+	for i := 0; ; i++ {
+		nodes := node1.Nodes("")
+		sort.Strings(nodes)
+		if reflect.DeepEqual(nodes, expected) {
+			break
+		}
+		if i == 50 {
+			t.Fatal("expected", expected, "got", node1.Nodes(""))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node is removed from the file: ")
+	// the node is kept connected by the user as well
+	node1.KeepConnected("nodeDiscovery3@localhost")
+	if err := ioutil.WriteFile(path, []byte("# empty\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(time.Second)
+	os.Chtimes(path, modified, modified)
+	waitForResultWithValue(t, changes, change{
+		added:   []string{},
+		removed: []string{"nodeDiscovery3@localhost"},
+	})
+
+	isKept := func() bool {
+		node1.connections.mutex.Lock()
+		defer node1.connections.mutex.Unlock()
+		_, ok := node1.connections.persistent["nodeDiscovery3@localhost"]
+		return ok
+	}
+	fmt.Printf("    node kept connected by the user is not released by the discovery: ")
+	if !isKept() {
+		t.Fatal("node must be kept connected")
+	}
+	node1.StopKeepConnected("nodeDiscovery3@localhost")
+	if isKept() {
+		t.Fatal("node must not be kept connected")
+	}
+	fmt.Println("OK")
+}
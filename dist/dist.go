@@ -3,6 +3,7 @@ package dist
 import (
 	"bufio"
 	"bytes"
+	"compress/zlib"
 	"context"
 	"crypto/md5"
 	"encoding/binary"
@@ -24,6 +25,8 @@ var (
 	dTrace            bool
 	ErrMissingInCache = fmt.Errorf("Missing in cache")
 	ErrMalformed      = fmt.Errorf("Malformed")
+	ErrNetTickTimeout = fmt.Errorf("Net tick timeout")
+	ErrDuplicateName  = fmt.Errorf("Duplicate name")
 )
 
 func init() {
@@ -37,7 +40,7 @@ func dLog(f string, a ...interface{}) {
 	}
 }
 
-type flagId uint32
+type flagId uint64
 type nodeFlag flagId
 
 const (
@@ -47,12 +50,15 @@ const (
 	defaultCleanDeadline = 30 * time.Second // for checkClean
 
 	// http://erlang.org/doc/apps/erts/erl_ext_dist.html#distribution_header
-	protoDist             = 131
-	protoDistCompressed   = 80
-	protoDistMessage      = 68
-	protoDistMessageRetry = 200 // for the internal usage only
-	protoDistFragment1    = 69
-	protoDistFragmentN    = 70
+	protoDist           = 131
+	protoDistCompressed = 80
+	protoDistMessage    = 68
+	protoDistFragment1  = 69
+	protoDistFragmentN  = 70
+
+	// versions of the distribution handshake
+	ProtoHandshake5 = 5
+	ProtoHandshake6 = 6 // since OTP.23
 
 	// distribution flags are defined here https://erlang.org/doc/apps/erts/erl_dist_protocol.html#distribution-flags
 	PUBLISHED           flagId = 0x1
@@ -77,20 +83,30 @@ const (
 	BIG_SEQTRACE_LABELS        = 0x100000
 	EXIT_PAYLOAD               = 0x400000 // since OTP.22 enable replacement for EXIT, EXIT2, MONITOR_P_EXIT
 	FRAGMENTS                  = 0x800000
+	HANDSHAKE_23               = 0x1000000 // since OTP.23 new connection setup handshake (version 6)
+	UNLINK_ID                  = 0x2000000
+	SPAWN                      = 0x100000000 // since OTP.23 remote spawn (SPAWN_REQUEST/SPAWN_REPLY)
+	NAME_ME                    = 0x200000000
+	V4_NC                      = 0x400000000
+	ALIAS                      = 0x800000000 // since OTP.24 process aliases (ALIAS_SEND)
 )
 
 func (nf nodeFlag) toUint32() uint32 {
 	return uint32(nf)
 }
 
+func (nf nodeFlag) toUint64() uint64 {
+	return uint64(nf)
+}
+
 func (nf nodeFlag) isSet(f flagId) bool {
-	return (uint32(nf) & uint32(f)) != 0
+	return (uint64(nf) & uint64(f)) != 0
 }
 
 func toNodeFlag(f ...flagId) nodeFlag {
-	var flags uint32
+	var flags uint64
 	for _, v := range f {
-		flags |= uint32(v)
+		flags |= uint64(v)
 	}
 	return nodeFlag(flags)
 }
@@ -101,6 +117,8 @@ type fragmentedPacket struct {
 	disorderedSlices map[uint64][]byte
 	fragmentID       uint64
 	lastUpdate       time.Time
+	// atom cache decoded with the first fragment
+	cache []etf.Atom
 }
 
 type Link struct {
@@ -112,10 +130,17 @@ type Link struct {
 	challenge uint32
 	flags     nodeFlag
 	version   uint16
+	creation  uint32
 
 	// writer
 	flusher *linkFlusher
 
+	// data received right after the handshake within the same read
+	tail []byte
+
+	// number of reads. used to detect the silent peer
+	received uint32
+
 	// atom cache for incomming messages
 	cacheIn      [2048]*etf.Atom
 	cacheInMutex sync.Mutex
@@ -159,17 +184,29 @@ type linkFlusher struct {
 
 	timer   *time.Timer
 	pending bool
+
+	// number of writes. tick is sent if there were no writes
+	// during the tick interval
+	written uint32
 }
 
 func (lf *linkFlusher) Write(b []byte) (int, error) {
 	lf.mutex.Lock()
 	defer lf.mutex.Unlock()
 
+	atomic.AddUint32(&lf.written, 1)
+
 	l := len(b)
 	lenB := l
 
 	// long data write directly to the socket.
 	if l > 64000 {
+		// buffered data must be written first. otherwise the packets
+		// are reordered (or even mixed up if the buffer was flushed
+		// partially)
+		if err := lf.writer.Flush(); err != nil {
+			return 0, err
+		}
 		for {
 			n, e := lf.w.Write(b[lenB-l:])
 			if e != nil {
@@ -214,19 +251,9 @@ func (lf *linkFlusher) Write(b []byte) (int, error) {
 	}
 
 	lf.timer = time.AfterFunc(lf.latency, func() {
-		// KeepAlive packet is just 4 bytes with zero value
-		var keepAlivePacket = []byte{0, 0, 0, 0}
-
 		lf.mutex.Lock()
 		defer lf.mutex.Unlock()
 
-		// if we have no pending data to send we should
-		// send a KeepAlive packet
-		if !lf.pending {
-			lf.w.Write(keepAlivePacket)
-			return
-		}
-
 		lf.writer.Flush()
 		lf.pending = false
 	})
@@ -235,30 +262,92 @@ func (lf *linkFlusher) Write(b []byte) (int, error) {
 
 }
 
-func Handshake(conn net.Conn, tls bool, name, cookie string, hidden bool) (*Link, error) {
+func (lf *linkFlusher) flush() error {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+	lf.pending = false
+	return lf.writer.Flush()
+}
+
+// HandshakeOptions defines the options for the distribution handshake
+type HandshakeOptions struct {
+	Name     string
+	Cookie   string
+	TLS      bool
+	Hidden   bool
+	Creation uint32
+
+	// Version is the highest version of the distribution protocol supported
+	// by the peer (EPMD reports it). Initiator sends the 'N' name message
+	// (handshake version 6, OTP 23 and above) if it's 6 or greater,
+	// otherwise the 'n' name message (version 5) is used.
+	Version uint16
+
+	// Accept is called once the name and the flags of the peer have been
+	// received. The accepting side fails the handshake with the status
+	// not_allowed if it returns an error. The initiating side fails it
+	// before the challenge reply is sent.
+	Accept func(name string, hidden bool) error
+
+	// PeerCookie is called on the accepting side once the name of the peer
+	// has been received. It returns the cookie for this peer (Cookie is
+	// used if it's not defined).
+	PeerCookie func(name string) string
+}
+
+func defaultFlags() nodeFlag {
+	return toNodeFlag(PUBLISHED, UNICODE_IO, DIST_MONITOR, DIST_MONITOR_NAME,
+		EXTENDED_PIDS_PORTS, EXTENDED_REFERENCES, ATOM_CACHE,
+		DIST_HDR_ATOM_CACHE, HIDDEN_ATOM_CACHE, NEW_FUN_TAGS,
+		SMALL_ATOM_TAGS, UTF8_ATOMS, MAP_TAG,
+		FRAGMENTS, FUN_TAGS, NEW_FLOATS, EXPORT_PTR_TAG, BIT_BINARIES,
+		BIG_CREATION, HANDSHAKE_23, SEND_SENDER, EXIT_PAYLOAD, SPAWN, ALIAS,
+	)
+}
+
+// nodeFlags returns the flags of this node. Hidden node doesn't set PUBLISHED
+// so the peer treats the connection as a hidden one.
+func nodeFlags(hidden bool) nodeFlag {
+	flags := defaultFlags()
+	if hidden {
+		flags &^= toNodeFlag(PUBLISHED)
+	}
+	return flags
+}
+
+func Handshake(conn net.Conn, options HandshakeOptions) (*Link, error) {
 
 	link := &Link{
-		Name:   name,
-		Cookie: cookie,
-		Hidden: hidden,
+		Name:   options.Name,
+		Cookie: options.Cookie,
+		Hidden: options.Hidden,
 
-		flags: toNodeFlag(PUBLISHED, UNICODE_IO, DIST_MONITOR, DIST_MONITOR_NAME,
-			EXTENDED_PIDS_PORTS, EXTENDED_REFERENCES, ATOM_CACHE,
-			DIST_HDR_ATOM_CACHE, HIDDEN_ATOM_CACHE, NEW_FUN_TAGS,
-			SMALL_ATOM_TAGS, UTF8_ATOMS, MAP_TAG,
-			FRAGMENTS,
-		),
+		flags: nodeFlags(options.Hidden),
 
 		conn:       conn,
 		sequenceID: time.Now().UnixNano(),
-		version:    5,
+		challenge:  rand.Uint32(),
+		version:    ProtoHandshake5,
+		creation:   options.Creation,
 	}
 
+	tls := options.TLS
+
 	b := lib.TakeBuffer()
 	defer lib.ReleaseBuffer(b)
 
-	link.composeName(b, tls)
-	if e := b.WriteDataTo(conn); e != nil {
+	w := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(w)
+
+	// peer is able to handle 'N' name message (OTP 23 and above)
+	if options.Version >= ProtoHandshake6 {
+		link.version = ProtoHandshake6
+		link.composeNameVersion6(w, tls)
+	} else {
+		link.composeName(w, tls)
+	}
+
+	if e := w.WriteDataTo(conn); e != nil {
 		return nil, e
 	}
 
@@ -297,28 +386,61 @@ func Handshake(conn net.Conn, tls bool, name, cookie string, hidden bool) (*Link
 				return nil, e
 			}
 
-			buffer := b.B
-
 		next:
-			l := binary.BigEndian.Uint16(buffer[expectingBytes-2 : expectingBytes])
-			buffer = buffer[expectingBytes:]
+			buffer, length := readHandshakePacket(b, expectingBytes)
+			if length == 0 {
+				// wait for the rest of the packet
+				continue
+			}
 
-			if len(buffer) < int(l) {
-				return nil, fmt.Errorf("malformed handshake (wrong packet length)")
+			if len(buffer) == 0 {
+				return nil, fmt.Errorf("malformed handshake (empty packet)")
 			}
 
 			switch buffer[0] {
 			case 'n':
 				// 'n' + 2 (version) + 4 (flags) + 4 (challenge) + name...
-				if len(b.B) < 12 {
+				if len(buffer) < 11 {
 					return nil, fmt.Errorf("malformed handshake ('n')")
 				}
 
 				challenge := link.readChallenge(buffer[1:])
-				b.Reset()
-				link.composeChallengeReply(challenge, b, tls)
+				if e := link.acceptPeer(options); e != nil {
+					return nil, e
+				}
+				link.composeChallengeReply(challenge, w, tls)
+
+				if e := w.WriteDataTo(conn); e != nil {
+					return nil, e
+				}
+
+			case 'N':
+				// 'N' + 8 (flags) + 4 (challenge) + 4 (creation) + 2 (len) + name...
+				if len(buffer) < 19 {
+					return nil, fmt.Errorf("malformed handshake ('N')")
+				}
 
-				if e := b.WriteDataTo(conn); e != nil {
+				challenge, ok := link.readChallengeVersion6(buffer[1:])
+				if !ok {
+					return nil, fmt.Errorf("malformed handshake ('N' length of name)")
+				}
+				if e := link.acceptPeer(options); e != nil {
+					return nil, e
+				}
+
+				if link.version == ProtoHandshake5 {
+					// we have sent 'n' name message, but the peer supports
+					// handshake version 6. we must send the complement
+					// message with the high bits of our flags and creation
+					link.version = ProtoHandshake6
+					link.composeComplement(w, tls)
+					if e := w.WriteDataTo(conn); e != nil {
+						return nil, e
+					}
+				}
+
+				link.composeChallengeReply(challenge, w, tls)
+				if e := w.WriteDataTo(conn); e != nil {
 					return nil, e
 				}
 
@@ -335,52 +457,56 @@ func Handshake(conn net.Conn, tls bool, name, cookie string, hidden bool) (*Link
 
 				// handshaked
 				link.flusher = newLinkFlusher(link.conn, defaultLatency)
+
+				// the peer might have already sent some messages. keep
+				// them for the link reader
+				if b.Len() > length {
+					link.tail = append([]byte{}, b.B[length:]...)
+				}
 				return link, nil
 
 			case 's':
 				if !link.readStatus(buffer[1:]) {
 					return nil, fmt.Errorf("handshake negotiation failed")
 				}
-				// skip "sok"
-				if len(buffer[3:]) > 0 {
-					buffer = buffer[3:]
-					goto next
-				}
-
-				b.Reset()
 
 			default:
 				return nil, fmt.Errorf("malformed handshake ('%c' digest)", buffer[0])
 			}
 
+			// cut the handled packet and check whether we have
+			// got another one within the same read
+			b.Set(b.B[length:])
+			goto next
 		}
 
 	}
 
 }
 
-func HandshakeAccept(conn net.Conn, tls bool, name, cookie string, hidden bool) (*Link, error) {
+func HandshakeAccept(conn net.Conn, options HandshakeOptions) (*Link, error) {
 	link := &Link{
-		Name:   name,
-		Cookie: cookie,
-		Hidden: hidden,
+		Name:   options.Name,
+		Cookie: options.Cookie,
+		Hidden: options.Hidden,
 
-		flags: toNodeFlag(PUBLISHED, UNICODE_IO, DIST_MONITOR, DIST_MONITOR_NAME,
-			EXTENDED_PIDS_PORTS, EXTENDED_REFERENCES, ATOM_CACHE,
-			DIST_HDR_ATOM_CACHE, HIDDEN_ATOM_CACHE, NEW_FUN_TAGS,
-			SMALL_ATOM_TAGS, UTF8_ATOMS, MAP_TAG,
-			FRAGMENTS,
-		),
+		flags: nodeFlags(options.Hidden),
 
 		conn:       conn,
 		sequenceID: time.Now().UnixNano(),
 		challenge:  rand.Uint32(),
-		version:    5,
+		version:    ProtoHandshake5,
+		creation:   options.Creation,
 	}
 
+	tls := options.TLS
+
 	b := lib.TakeBuffer()
 	defer lib.ReleaseBuffer(b)
 
+	w := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(w)
+
 	// define timeout for the handshaking
 	timer := time.NewTimer(5 * time.Second)
 	defer timer.Stop()
@@ -420,48 +546,91 @@ func HandshakeAccept(conn net.Conn, tls bool, name, cookie string, hidden bool)
 				return nil, e
 			}
 
-			if b.Len() < expectingBytes+1 {
-				return nil, fmt.Errorf("malformed handshake (too short packet)")
+		next:
+			buffer, length := readHandshakePacket(b, expectingBytes)
+			if length == 0 {
+				// wait for the rest of the packet
+				continue
 			}
 
-			l := binary.BigEndian.Uint16(b.B[expectingBytes-2 : expectingBytes])
-			buffer := b.B[expectingBytes:]
-
-			if len(buffer) < int(l) {
-				return nil, fmt.Errorf("malformed handshake (wrong packet length)")
+			if len(buffer) == 0 {
+				return nil, fmt.Errorf("malformed handshake (too short packet)")
 			}
 
 			switch buffer[0] {
 			case 'n':
-				if len(buffer) < 7 {
+				// 'n' + 2 (version) + 4 (flags) + name...
+				if len(buffer) < 8 {
 					return nil, fmt.Errorf("malformed handshake ('n' length)")
 				}
 
 				link.peer = link.readName(buffer[1:])
-				b.Reset()
-				link.composeStatus(b, tls)
-				if e := b.WriteDataTo(conn); e != nil {
+				if e := link.accept(w, options); e != nil {
+					return nil, e
+				}
+				link.composeStatus(w, tls, "ok")
+				if e := w.WriteDataTo(conn); e != nil {
 					return nil, fmt.Errorf("malformed handshake ('n' accept name)")
 				}
 
-				link.composeChallenge(b, tls)
-				if e := b.WriteDataTo(conn); e != nil {
+				if link.peer.flags.isSet(HANDSHAKE_23) {
+					// the peer supports handshake version 6 but doesn't know
+					// our version. reply with 'N' challenge and wait for
+					// the complement message before the challenge reply
+					link.version = ProtoHandshake6
+					link.composeChallengeVersion6(w, tls)
+				} else {
+					link.composeChallenge(w, tls)
+				}
+				if e := w.WriteDataTo(conn); e != nil {
 					return nil, e
 				}
-				continue
+
+			case 'N':
+				// 'N' + 8 (flags) + 4 (creation) + 2 (len) + name...
+				if len(buffer) < 16 {
+					return nil, fmt.Errorf("malformed handshake ('N' length)")
+				}
+
+				peer, ok := link.readNameVersion6(buffer[1:])
+				if !ok {
+					return nil, fmt.Errorf("malformed handshake ('N' length of name)")
+				}
+				link.peer = peer
+				link.version = ProtoHandshake6
+
+				if e := link.accept(w, options); e != nil {
+					return nil, e
+				}
+				link.composeStatus(w, tls, "ok")
+				if e := w.WriteDataTo(conn); e != nil {
+					return nil, fmt.Errorf("malformed handshake ('N' accept name)")
+				}
+
+				link.composeChallengeVersion6(w, tls)
+				if e := w.WriteDataTo(conn); e != nil {
+					return nil, e
+				}
+
+			case 'c':
+				// 'c' + 4 (flags high) + 4 (creation)
+				if len(buffer) != 9 || link.peer == nil {
+					return nil, fmt.Errorf("malformed handshake ('c')")
+				}
+
+				link.readComplement(buffer[1:])
 
 			case 'r':
-				if len(buffer) < 19 {
+				if len(buffer) < 19 || link.peer == nil {
 					return nil, fmt.Errorf("malformed handshake ('r')")
 				}
 
 				if !link.validateChallengeReply(buffer[1:]) {
 					return nil, fmt.Errorf("malformed handshake ('r1')")
 				}
-				b.Reset()
 
-				link.composeChallengeAck(b, tls)
-				if e := b.WriteDataTo(conn); e != nil {
+				link.composeChallengeAck(w, tls)
+				if e := w.WriteDataTo(conn); e != nil {
 					return nil, e
 				}
 
@@ -471,20 +640,48 @@ func HandshakeAccept(conn net.Conn, tls bool, name, cookie string, hidden bool)
 				return link, nil
 
 			default:
-				return nil, fmt.Errorf("malformed handshake (unknown code %d)", b.B[0])
+				return nil, fmt.Errorf("malformed handshake (unknown code %d)", buffer[0])
 			}
 
+			// cut the handled packet and check whether we have
+			// got another one within the same read
+			b.Set(b.B[length:])
+			goto next
 		}
 
 	}
 }
 
+// readHandshakePacket returns the first complete handshake message from the buffer
+// and the number of bytes it takes (including the length header). Returns zero
+// length if the buffer has no complete message.
+func readHandshakePacket(b *lib.Buffer, headerLength int) ([]byte, int) {
+	if b.Len() < headerLength {
+		return nil, 0
+	}
+
+	l := int(binary.BigEndian.Uint16(b.B[headerLength-2 : headerLength]))
+	if b.Len() < headerLength+l {
+		return nil, 0
+	}
+
+	return b.B[headerLength : headerLength+l], headerLength + l
+}
+
 func (l *Link) Close() {
 	if l.conn != nil {
 		l.conn.Close()
 	}
 }
 
+// Flush writes the buffered data to the connection
+func (l *Link) Flush() error {
+	if l.flusher == nil {
+		return nil
+	}
+	return l.flusher.flush()
+}
+
 func (l *Link) PeerName() string {
 	if l.peer != nil {
 		return l.peer.Name
@@ -496,6 +693,11 @@ func (l *Link) Read(b *lib.Buffer) (int, error) {
 	// http://erlang.org/doc/apps/erts/erl_dist_protocol.html#protocol-between-connected-nodes
 	expectingBytes := 4
 
+	if l.tail != nil {
+		b.Append(l.tail)
+		l.tail = nil
+	}
+
 	for {
 		if b.Len() < expectingBytes {
 			n, e := b.ReadDataFrom(l.conn)
@@ -503,6 +705,7 @@ func (l *Link) Read(b *lib.Buffer) (int, error) {
 				// link was closed
 				return 0, nil
 			}
+			atomic.AddUint32(&l.received, 1)
 
 			if e != nil && e != io.EOF {
 				// something went wrong
@@ -515,8 +718,7 @@ func (l *Link) Read(b *lib.Buffer) (int, error) {
 
 		packetLength := binary.BigEndian.Uint32(b.B[:4])
 		if packetLength == 0 {
-			// keepalive
-			l.conn.Write(b.B[:4])
+			// tick (keepalive)
 			b.Set(b.B[4:])
 
 			expectingBytes = 4
@@ -533,70 +735,121 @@ func (l *Link) Read(b *lib.Buffer) (int, error) {
 
 }
 
-func (l *Link) ReadHandlePacket(ctx context.Context, recv chan *lib.Buffer,
+// Ticker implements the net_ticktime semantics. It sends the tick (4 bytes
+// with zero value) every netTickTime/4 if nothing has been sent during this
+// interval. Returns ErrNetTickTimeout if nothing has been received from
+// the peer within the last 4 intervals. Returns nil if ctx is done.
+func (l *Link) Ticker(ctx context.Context, netTickTime time.Duration) error {
+	var tick = []byte{0, 0, 0, 0}
+
+	ticker := time.NewTicker(netTickTime / 4)
+	defer ticker.Stop()
+
+	written := atomic.LoadUint32(&l.flusher.written)
+	received := atomic.LoadUint32(&l.received)
+	missed := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if atomic.LoadUint32(&l.flusher.written) == written {
+			l.flusher.Write(tick)
+		}
+		written = atomic.LoadUint32(&l.flusher.written)
+
+		if r := atomic.LoadUint32(&l.received); r != received {
+			received = r
+			missed = 0
+			continue
+		}
+
+		missed++
+		if missed > 3 {
+			dLog("[%s] net tick timeout. peer %s", l.Name, l.GetPeerName())
+			return ErrNetTickTimeout
+		}
+	}
+}
+
+// Packet is a received packet with the decoded control message. Its
+// payload is decoded by ReadHandlePacket
+type Packet struct {
+	Control etf.Term
+	payload []byte
+	cache   []etf.Atom
+	buffer  *lib.Buffer
+}
+
+func (p *Packet) decode() (etf.Term, error) {
+	if len(p.payload) == 0 {
+		return nil, nil
+	}
+
+	message, rest, err := etf.Decode(p.payload, p.cache)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("packet has extra %d byte(s)", len(rest))
+	}
+	return message, nil
+}
+
+func (p *Packet) release() {
+	if p.buffer != nil {
+		lib.ReleaseBuffer(p.buffer)
+	}
+}
+
+// ReadHandlePacket decodes the payload of the packets (they can be handled
+// by the multiple goroutines) and invokes the handler
+func (l *Link) ReadHandlePacket(ctx context.Context, recv chan *Packet,
 	handler func(string, etf.Term, etf.Term)) {
-	var b *lib.Buffer
 
 	for {
-		b = nil
-		b = <-recv
-		if b == nil {
+		packet := <-recv
+		if packet == nil {
 			// channel was closed
 			return
 		}
 
-		// read and decode recieved packet
-		control, message, err := l.ReadPacket(b.B)
-
-		//////////////////////////////////////////////////////////////////////
-		// The main idea of getting this packet back into the 'recv'
-		// channel is that we have N goroutines for the processing packets.
-		// There is a case when we got two packets like MONITOR, REG_SEND
-		// (which is pretty usual for the regular gen_server:call from the Erlang).
-		// The first packet (MONITOR) has new atom cache entries, which
-		// should use in the next packet (REG_SEND). But sometimes,
-		// doing handle REG_SEND packet, the atom cache still
-		// has no entries due to processing of the MONITOR packet
-		// is doing on another goroutine and hasn't finished yet.
-		//
-		// Besides that, if we have the only packet in the 'recv' channle add some
-		// delay before we take another attempt to handle this packet.
-		// If this delay is not enought it seems we got disordered data. Drop
-		// this connection.
-		if err == ErrMissingInCache {
-			select {
-			case recv <- b:
-				if len(recv) == 1 {
-					time.Sleep(100 * time.Millisecond)
-				}
-				continue
-			default:
-				fmt.Println("Disordered data at link with", l.PeerName())
-				l.Close()
-				lib.ReleaseBuffer(b)
-				return
-			}
-		}
-		//////////////////////////////////////////////////////////////////////
+		message, err := packet.decode()
+		packet.release()
 
 		if err != nil {
 			fmt.Println("Malformed Dist proto at link with", l.PeerName(), err)
 			l.Close()
-			lib.ReleaseBuffer(b)
 			return
 		}
 
-		if control == nil {
-			// fragment
-			continue
-		}
-
 		// handle message
-		handler(l.peer.Name, control, message)
+		handler(l.peer.Name, packet.Control, message)
+	}
+}
 
-		// we have to release this buffer
+// ReadControl decodes the dist header and the control message of the given
+// packet. It must be called in order of receiving packets since the atom
+// cache and the fragments are processed here. Returns nil if the packet is
+// a fragment of not yet assembled message. Takes the ownership of the buffer.
+func (l *Link) ReadControl(b *lib.Buffer) (*Packet, error) {
+	if len(b.B) < 5 {
 		lib.ReleaseBuffer(b)
+		return nil, fmt.Errorf("malformed packet")
+	}
 
+	// [:3] length
+	switch b.B[4] {
+	case protoDist:
+		return l.readDistControl(b, b.B[5:])
+	default:
+		// unknown proto
+		lib.ReleaseBuffer(b)
+		return nil, fmt.Errorf("unknown/unsupported proto")
 	}
 }
 
@@ -617,85 +870,157 @@ func (l *Link) ReadPacket(packet []byte) (etf.Term, etf.Term, error) {
 }
 
 func (l *Link) ReadDist(packet []byte) (etf.Term, etf.Term, error) {
-	switch packet[0] {
-	case protoDistCompressed:
-		// do we need it?
-		// zip.NewReader(...)
-		// ...unzipping to the new buffer b (lib.TakeBuffer)
-		// just in case: if b[0] == protoDistCompressed return error
-		// otherwise it will cause recursive call and im not sure if its ok
-		// return l.ReadDist(b)
-
-	case protoDistMessage, protoDistMessageRetry:
-		var control, message etf.Term
-		var cache []etf.Atom
-		var err error
-		var original []byte
-
-		original = packet
-		cache, packet, err = l.decodeDistHeaderAtomCache(packet[1:])
-		if err == ErrMissingInCache && original[0] == protoDistMessage {
-			original[0] = protoDistMessageRetry
-			return nil, nil, ErrMissingInCache
-		}
+	p, err := l.readDistControl(nil, packet)
+	if err != nil || p == nil {
+		return nil, nil, err
+	}
+	defer p.release()
 
-		if err != nil {
-			return nil, nil, fmt.Errorf("incorrect dist header atom cache: %s", err)
-		}
+	message, err := p.decode()
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.Control, message, nil
+}
 
-		control, packet, err = etf.Decode(packet, cache)
-		if err != nil {
-			return nil, nil, err
+// readDistControl takes the ownership of the buffer b (if it's not nil) which
+// holds the given packet
+func (l *Link) readDistControl(b *lib.Buffer, packet []byte) (*Packet, error) {
+	release := func() {
+		if b != nil {
+			lib.ReleaseBuffer(b)
 		}
+	}
 
-		if len(packet) == 0 {
-			return control, nil, nil
-		}
+	if len(packet) == 0 {
+		release()
+		return nil, fmt.Errorf("malformed packet")
+	}
 
-		message, packet, err = etf.Decode(packet, cache)
+	switch packet[0] {
+	case protoDistCompressed:
+		uncompressed, err := decompressDist(packet[1:])
+		release()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
-		if len(packet) != 0 {
-			return nil, nil, fmt.Errorf("packet has extra %d byte(s)", len(packet))
+		// compressed packet inside of the compressed one is not allowed.
+		// otherwise it will cause recursive call
+		if uncompressed.B[0] == protoDistCompressed {
+			lib.ReleaseBuffer(uncompressed)
+			return nil, fmt.Errorf("malformed compressed packet")
 		}
+		return l.readDistControl(uncompressed, uncompressed.B)
 
-		return control, message, nil
+	case protoDistMessage:
+		cache, packet, err := l.decodeDistHeaderAtomCache(packet[1:])
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("incorrect dist header atom cache: %s", err)
+		}
+		return l.decodeControl(b, packet, cache)
 
 	case protoDistFragment1, protoDistFragmentN:
 		first := packet[0] == protoDistFragment1
 		if len(packet) < 18 {
-			return nil, nil, fmt.Errorf("malformed fragment")
+			release()
+			return nil, fmt.Errorf("malformed fragment")
 		}
 
-		// We should decode first fragment in order to process Atom Cache Header
-		// to get rid the case when we get the first fragment of the packet
-		// and the next packet is not the part of the fragmented packet, but with
-		// the ids were encoded in the first fragment
+		// We should decode the atom cache header of the first fragment
+		// right away since the next packets (which are not the part of
+		// this fragmented packet) could use the ids were encoded there
+		var cache []etf.Atom
 		if first {
-			l.decodeDistHeaderAtomCache(packet[1:])
-		}
-
-		if assembled, err := l.decodeFragment(packet[1:], first); assembled != nil {
-			if err != nil {
-				return nil, nil, err
-			}
-			defer lib.ReleaseBuffer(assembled)
-			return l.ReadDist(assembled.B)
-		} else {
+			c, _, err := l.decodeDistHeaderAtomCache(packet[17:])
 			if err != nil {
-				return nil, nil, err
+				release()
+				return nil, fmt.Errorf("incorrect dist header atom cache: %s", err)
 			}
+			cache = c
 		}
 
-		return nil, nil, nil
+		assembled, cache, err := l.assembleFragment(packet[1:], first, cache)
+		release()
+		if err != nil || assembled == nil {
+			return nil, err
+		}
+
+		// atom cache header has been decoded with the first fragment. skip it
+		_, rest, err := l.decodeDistHeader(assembled.B[1:], false)
+		if err != nil {
+			lib.ReleaseBuffer(assembled)
+			return nil, fmt.Errorf("incorrect dist header atom cache: %s", err)
+		}
+		return l.decodeControl(assembled, rest, cache)
+	}
+
+	release()
+	return nil, fmt.Errorf("unknown packet type %d", packet[0])
+}
+
+func (l *Link) decodeControl(b *lib.Buffer, packet []byte, cache []etf.Atom) (*Packet, error) {
+	control, packet, err := etf.Decode(packet, cache)
+	if err != nil {
+		if b != nil {
+			lib.ReleaseBuffer(b)
+		}
+		return nil, err
 	}
 
-	return nil, nil, fmt.Errorf("unknown packet type %d", packet[0])
+	p := &Packet{
+		Control: control,
+		payload: packet,
+		cache:   cache,
+		buffer:  b,
+	}
+	return p, nil
+}
+
+func decompressDist(packet []byte) (*lib.Buffer, error) {
+	// 4 (uncompressed size) + zlib data
+	if len(packet) < 5 {
+		return nil, fmt.Errorf("malformed compressed packet")
+	}
+	size := binary.BigEndian.Uint32(packet)
+	if size == 0 {
+		return nil, fmt.Errorf("malformed compressed packet")
+	}
+	r := bytes.NewReader(packet[4:])
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("malformed compressed packet: %s", err)
+	}
+	defer zr.Close()
+
+	// the size comes from the peer so it isn't allocated in advance.
+	// the buffer grows while reading the uncompressed data
+	b := lib.TakeBuffer()
+	n, err := io.Copy(b, io.LimitReader(zr, int64(size)))
+	if err != nil || n != int64(size) {
+		lib.ReleaseBuffer(b)
+		return nil, fmt.Errorf("malformed compressed packet")
+	}
+
+	// must be the end of the zlib stream and the packet. it also makes
+	// zlib reader to verify the checksum
+	var tail [1]byte
+	if n, err := zr.Read(tail[:]); n != 0 || err != io.EOF || r.Len() != 0 {
+		lib.ReleaseBuffer(b)
+		return nil, fmt.Errorf("malformed compressed packet")
+	}
+	return b, nil
 }
 
 func (l *Link) decodeFragment(packet []byte, first bool) (*lib.Buffer, error) {
+	assembled, _, err := l.assembleFragment(packet, first, nil)
+	return assembled, err
+}
+
+// assembleFragment returns the assembled packet and the atom cache
+// which was decoded with the first fragment
+func (l *Link) assembleFragment(packet []byte, first bool, cache []etf.Atom) (*lib.Buffer, []etf.Atom, error) {
 	l.fragmentsMutex.Lock()
 	defer l.fragmentsMutex.Unlock()
 
@@ -706,7 +1031,7 @@ func (l *Link) decodeFragment(packet []byte, first bool) (*lib.Buffer, error) {
 	sequenceID := binary.BigEndian.Uint64(packet)
 	fragmentID := binary.BigEndian.Uint64(packet[8:])
 	if fragmentID == 0 {
-		return nil, fmt.Errorf("fragmentID can't be 0")
+		return nil, nil, fmt.Errorf("fragmentID can't be 0")
 	}
 
 	fragmented, ok := l.fragments[sequenceID]
@@ -724,6 +1049,7 @@ func (l *Link) decodeFragment(packet []byte, first bool) (*lib.Buffer, error) {
 	// until we get the first item everything will be treated as disordered
 	if first {
 		fragmented.fragmentID = fragmentID + 1
+		fragmented.cache = cache
 	}
 
 	if fragmented.fragmentID-fragmentID != 1 {
@@ -757,16 +1083,16 @@ func (l *Link) decodeFragment(packet []byte, first bool) (*lib.Buffer, error) {
 		// it was the last fragment
 		delete(l.fragments, sequenceID)
 		lib.ReleaseBuffer(fragmented.disordered)
-		return fragmented.buffer, nil
+		return fragmented.buffer, fragmented.cache, nil
 	}
 
 	if l.checkCleanPending {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	if l.checkCleanTimer != nil {
 		l.checkCleanTimer.Reset(l.checkCleanTimeout)
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	l.checkCleanTimer = time.AfterFunc(l.checkCleanTimeout, func() {
@@ -796,10 +1122,16 @@ func (l *Link) decodeFragment(packet []byte, first bool) (*lib.Buffer, error) {
 		l.checkCleanTimer.Reset(l.checkCleanTimeout)
 	})
 
-	return nil, nil
+	return nil, nil, nil
 }
 
 func (l *Link) decodeDistHeaderAtomCache(packet []byte) ([]etf.Atom, []byte, error) {
+	return l.decodeDistHeader(packet, true)
+}
+
+// decodeDistHeader decodes the atom cache header. If update is false the
+// link's cache is left untouched and only the new entries are returned
+func (l *Link) decodeDistHeader(packet []byte, update bool) ([]etf.Atom, []byte, error) {
 	// all the details are here https://erlang.org/doc/apps/erts/erl_ext_dist.html#normal-distribution-header
 
 	// number of atom references are present in package
@@ -857,13 +1189,20 @@ func (l *Link) decodeDistHeaderAtomCache(packet []byte) ([]etf.Atom, []byte, err
 			cache[i] = atom
 
 			// store in link' cache
-			l.cacheInMutex.Lock()
-			l.cacheIn[idx] = &atom
-			l.cacheInMutex.Unlock()
+			if update {
+				l.cacheInMutex.Lock()
+				l.cacheIn[idx] = &atom
+				l.cacheInMutex.Unlock()
+			}
 			packet = packet[atomLen:]
 			continue
 		}
 
+		if !update {
+			packet = packet[1:]
+			continue
+		}
+
 		l.cacheInMutex.Lock()
 		c := l.cacheIn[idx]
 		l.cacheInMutex.Unlock()
@@ -947,13 +1286,17 @@ func (l *Link) encodeDistHeaderAtomCache(b *lib.Buffer,
 	}
 }
 
-func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int) {
+// Writer encodes and sends messages from the given channel. The message term
+// is compressed if its encoded size exceeds compressionThreshold (zero value
+// disables compression)
+func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int, compressionThreshold int) {
 	var terms []etf.Term
 
 	var encodingAtomCache *etf.ListAtomCache
 	var writerAtomCache map[etf.Atom]etf.CacheItem
 	var linkAtomCache *etf.AtomCache
 	var lastCacheID int16 = -1
+	var encodeOptions, encodeMessageOptions etf.EncodeOptions
 
 	var lenControl, lenMessage, lenAtomCache, lenPacket, startDataPosition int
 	var atomCacheBuffer, packetBuffer *lib.Buffer
@@ -975,6 +1318,16 @@ func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int) {
 		linkAtomCache = l.cacheOut
 	}
 
+	encodeOptions = etf.EncodeOptions{
+		LinkAtomCache:     linkAtomCache,
+		WriterAtomCache:   writerAtomCache,
+		EncodingAtomCache: encodingAtomCache,
+		FlagBigCreation:   l.peer.flags.isSet(BIG_CREATION),
+	}
+	// control message is never compressed
+	encodeMessageOptions = encodeOptions
+	encodeMessageOptions.CompressionThreshold = compressionThreshold
+
 	for {
 		terms = nil
 		terms = <-send
@@ -996,7 +1349,7 @@ func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int) {
 		}
 
 		// encode Control
-		err = etf.Encode(terms[0], packetBuffer, linkAtomCache, writerAtomCache, encodingAtomCache)
+		err = etf.EncodeWithOptions(terms[0], packetBuffer, encodeOptions)
 		if err != nil {
 			fmt.Println(err)
 			lib.ReleaseBuffer(packetBuffer)
@@ -1006,7 +1359,7 @@ func (l *Link) Writer(send <-chan []etf.Term, fragmentationUnit int) {
 
 		// encode Message if present
 		if len(terms) == 2 {
-			err = etf.Encode(terms[1], packetBuffer, linkAtomCache, writerAtomCache, encodingAtomCache)
+			err = etf.EncodeWithOptions(terms[1], packetBuffer, encodeMessageOptions)
 			if err != nil {
 				fmt.Println(err)
 				lib.ReleaseBuffer(packetBuffer)
@@ -1140,13 +1493,28 @@ func (l *Link) GetRemoteName() string {
 	return l.peer.Name
 }
 
+// IsPeerHidden returns true if the peer is hidden
+func (l *Link) IsPeerHidden() bool {
+	return !l.peer.flags.isSet(PUBLISHED)
+}
+
+// IsHidden returns true if this node or the peer is hidden
+func (l *Link) IsHidden() bool {
+	return l.Hidden || !l.peer.flags.isSet(PUBLISHED)
+}
+
+// IsSendSender returns true if the peer supports SEND_SENDER control message
+func (l *Link) IsSendSender() bool {
+	return l.peer.flags.isSet(SEND_SENDER)
+}
+
 func (l *Link) composeName(b *lib.Buffer, tls bool) {
 	if tls {
 		b.Allocate(11)
 		dataLength := uint32(7 + len(l.Name)) // byte + uint16 + uint32 + len(l.Name)
 		binary.BigEndian.PutUint32(b.B[0:4], dataLength)
 		b.B[4] = 'n'
-		binary.BigEndian.PutUint16(b.B[5:7], l.version)           // uint16
+		binary.BigEndian.PutUint16(b.B[5:7], ProtoHandshake5)     // uint16
 		binary.BigEndian.PutUint32(b.B[7:11], l.flags.toUint32()) // uint32
 		b.Append([]byte(l.Name))
 		return
@@ -1156,11 +1524,48 @@ func (l *Link) composeName(b *lib.Buffer, tls bool) {
 	dataLength := uint16(7 + len(l.Name)) // byte + uint16 + uint32 + len(l.Name)
 	binary.BigEndian.PutUint16(b.B[0:2], dataLength)
 	b.B[2] = 'n'
-	binary.BigEndian.PutUint16(b.B[3:5], l.version)          // uint16
+	binary.BigEndian.PutUint16(b.B[3:5], ProtoHandshake5)    // uint16
 	binary.BigEndian.PutUint32(b.B[5:9], l.flags.toUint32()) // uint32
 	b.Append([]byte(l.Name))
 }
 
+func (l *Link) composeNameVersion6(b *lib.Buffer, tls bool) {
+	if tls {
+		b.Allocate(19)
+		dataLength := uint32(15 + len(l.Name)) // 1 + 8 (flags) + 4 (creation) + 2 (len l.Name) + len(l.Name)
+		binary.BigEndian.PutUint32(b.B[0:4], dataLength)
+		b.B[4] = 'N'
+		binary.BigEndian.PutUint64(b.B[5:13], l.flags.toUint64())   // uint64
+		binary.BigEndian.PutUint32(b.B[13:17], l.creation)          // uint32
+		binary.BigEndian.PutUint16(b.B[17:19], uint16(len(l.Name))) // uint16
+		b.Append([]byte(l.Name))
+		return
+	}
+
+	b.Allocate(17)
+	dataLength := uint16(15 + len(l.Name)) // 1 + 8 (flags) + 4 (creation) + 2 (len l.Name) + len(l.Name)
+	binary.BigEndian.PutUint16(b.B[0:2], dataLength)
+	b.B[2] = 'N'
+	binary.BigEndian.PutUint64(b.B[3:11], l.flags.toUint64())   // uint64
+	binary.BigEndian.PutUint32(b.B[11:15], l.creation)          // uint32
+	binary.BigEndian.PutUint16(b.B[15:17], uint16(len(l.Name))) // uint16
+	b.Append([]byte(l.Name))
+}
+
+func (l *Link) readNameVersion6(b []byte) (*Link, bool) {
+	nameLen := int(binary.BigEndian.Uint16(b[12:14]))
+	if len(b[14:]) < nameLen {
+		return nil, false
+	}
+	peer := &Link{
+		Name:     string(b[14 : 14+nameLen]),
+		version:  ProtoHandshake6,
+		flags:    nodeFlag(binary.BigEndian.Uint64(b[0:8])),
+		creation: binary.BigEndian.Uint32(b[8:12]),
+	}
+	return peer, true
+}
+
 func (l *Link) readName(b []byte) *Link {
 	peer := &Link{
 		Name:    fmt.Sprintf("%s", b[6:]),
@@ -1170,23 +1575,48 @@ func (l *Link) readName(b []byte) *Link {
 	return peer
 }
 
-func (l *Link) composeStatus(b *lib.Buffer, tls bool) {
+func (l *Link) composeStatus(b *lib.Buffer, tls bool, status string) {
 	//FIXME: there are few options for the status:
 	//	   ok, ok_simultaneous, nok, not_allowed, alive
 	// More details here: https://erlang.org/doc/apps/erts/erl_dist_protocol.html#the-handshake-in-detail
 
 	if tls {
 		b.Allocate(4)
-		dataLength := uint32(3) // 's' + "ok"
+		dataLength := uint32(1 + len(status)) // 's' + status
 		binary.BigEndian.PutUint32(b.B[0:4], dataLength)
-		b.Append([]byte("sok"))
+		b.Append([]byte("s" + status))
 		return
 	}
 
 	b.Allocate(2)
-	dataLength := uint16(3) // 's' + "ok"
+	dataLength := uint16(1 + len(status)) // 's' + status
 	binary.BigEndian.PutUint16(b.B[0:2], dataLength)
-	b.Append([]byte("sok"))
+	b.Append([]byte("s" + status))
+}
+
+// accept asks the node whether the peer is allowed to connect (replies
+// with the status not_allowed if it's not) and takes the cookie for this peer.
+func (l *Link) accept(b *lib.Buffer, options HandshakeOptions) error {
+	if options.Accept != nil {
+		if err := options.Accept(l.peer.Name, l.IsPeerHidden()); err != nil {
+			l.composeStatus(b, options.TLS, "not_allowed")
+			b.WriteDataTo(l.conn)
+			return err
+		}
+	}
+	if options.PeerCookie != nil {
+		l.Cookie = options.PeerCookie(l.peer.Name)
+	}
+	return nil
+}
+
+// acceptPeer asks the node whether the peer is allowed to be connected with.
+// It's used by the initiating side before the challenge reply is sent.
+func (l *Link) acceptPeer(options HandshakeOptions) error {
+	if options.Accept == nil {
+		return nil
+	}
+	return options.Accept(l.peer.Name, l.IsPeerHidden())
 }
 
 func (l *Link) readStatus(msg []byte) bool {
@@ -1203,7 +1633,7 @@ func (l *Link) composeChallenge(b *lib.Buffer, tls bool) {
 		dataLength := uint32(11 + len(l.Name))
 		binary.BigEndian.PutUint32(b.B[0:4], dataLength)
 		b.B[4] = 'n'
-		binary.BigEndian.PutUint16(b.B[5:7], l.version)           // uint16
+		binary.BigEndian.PutUint16(b.B[5:7], ProtoHandshake5)     // uint16
 		binary.BigEndian.PutUint32(b.B[7:11], l.flags.toUint32()) // uint32
 		binary.BigEndian.PutUint32(b.B[11:15], l.challenge)       // uint32
 		b.Append([]byte(l.Name))
@@ -1214,12 +1644,82 @@ func (l *Link) composeChallenge(b *lib.Buffer, tls bool) {
 	dataLength := uint16(11 + len(l.Name))
 	binary.BigEndian.PutUint16(b.B[0:2], dataLength)
 	b.B[2] = 'n'
-	binary.BigEndian.PutUint16(b.B[3:5], l.version)          // uint16
+	binary.BigEndian.PutUint16(b.B[3:5], ProtoHandshake5)    // uint16
 	binary.BigEndian.PutUint32(b.B[5:9], l.flags.toUint32()) // uint32
 	binary.BigEndian.PutUint32(b.B[9:13], l.challenge)       // uint32
 	b.Append([]byte(l.Name))
 }
 
+func (l *Link) composeChallengeVersion6(b *lib.Buffer, tls bool) {
+	if tls {
+		b.Allocate(23)
+		// 1 ('N') + 8 (flags) + 4 (challenge) + 4 (creation) + 2 (len(l.Name)) + len(l.Name)
+		dataLength := uint32(19 + len(l.Name))
+		binary.BigEndian.PutUint32(b.B[0:4], dataLength)
+		b.B[4] = 'N'
+		binary.BigEndian.PutUint64(b.B[5:13], l.flags.toUint64())   // uint64
+		binary.BigEndian.PutUint32(b.B[13:17], l.challenge)         // uint32
+		binary.BigEndian.PutUint32(b.B[17:21], l.creation)          // uint32
+		binary.BigEndian.PutUint16(b.B[21:23], uint16(len(l.Name))) // uint16
+		b.Append([]byte(l.Name))
+		return
+	}
+
+	b.Allocate(21)
+	// 1 ('N') + 8 (flags) + 4 (challenge) + 4 (creation) + 2 (len(l.Name)) + len(l.Name)
+	dataLength := uint16(19 + len(l.Name))
+	binary.BigEndian.PutUint16(b.B[0:2], dataLength)
+	b.B[2] = 'N'
+	binary.BigEndian.PutUint64(b.B[3:11], l.flags.toUint64())   // uint64
+	binary.BigEndian.PutUint32(b.B[11:15], l.challenge)         // uint32
+	binary.BigEndian.PutUint32(b.B[15:19], l.creation)          // uint32
+	binary.BigEndian.PutUint16(b.B[19:21], uint16(len(l.Name))) // uint16
+	b.Append([]byte(l.Name))
+}
+
+func (l *Link) readChallengeVersion6(msg []byte) (uint32, bool) {
+	nameLen := int(binary.BigEndian.Uint16(msg[16:18]))
+	if len(msg[18:]) < nameLen {
+		return 0, false
+	}
+	link := &Link{
+		Name:     string(msg[18 : 18+nameLen]),
+		version:  ProtoHandshake6,
+		flags:    nodeFlag(binary.BigEndian.Uint64(msg[0:8])),
+		creation: binary.BigEndian.Uint32(msg[12:16]),
+	}
+	l.peer = link
+	return binary.BigEndian.Uint32(msg[8:12]), true
+}
+
+func (l *Link) composeComplement(b *lib.Buffer, tls bool) {
+	// cast must cut the low bits, leaving the high ones only
+	flagsHigh := uint32(l.flags.toUint64() >> 32)
+	if tls {
+		b.Allocate(13)
+		dataLength := uint32(9) // 1 + 4 (flag high) + 4 (creation)
+		binary.BigEndian.PutUint32(b.B[0:4], dataLength)
+		b.B[4] = 'c'
+		binary.BigEndian.PutUint32(b.B[5:9], flagsHigh)
+		binary.BigEndian.PutUint32(b.B[9:13], l.creation)
+		return
+	}
+
+	b.Allocate(11)
+	dataLength := uint16(9) // 1 + 4 (flag high) + 4 (creation)
+	binary.BigEndian.PutUint16(b.B[0:2], dataLength)
+	b.B[2] = 'c'
+	binary.BigEndian.PutUint32(b.B[3:7], flagsHigh)
+	binary.BigEndian.PutUint32(b.B[7:11], l.creation)
+}
+
+func (l *Link) readComplement(msg []byte) {
+	flagsHigh := uint64(binary.BigEndian.Uint32(msg[0:4])) << 32
+	l.peer.flags = nodeFlag(l.peer.flags.toUint64() | flagsHigh)
+	l.peer.creation = binary.BigEndian.Uint32(msg[4:8])
+	l.peer.version = ProtoHandshake6
+}
+
 func (l *Link) readChallenge(msg []byte) (challenge uint32) {
 	link := &Link{
 		Name:    fmt.Sprintf("%s", msg[10:]),
@@ -1279,8 +1779,8 @@ func (l *Link) composeChallengeReply(challenge uint32, b *lib.Buffer, tls bool)
 }
 
 func (l *Link) validateChallengeAck(msg []byte) bool {
-	//FIXME
-	return true
+	digest := genDigest(l.challenge, l.Cookie)
+	return bytes.Equal(digest[:], msg)
 }
 
 func genDigest(challenge uint32, cookie string) [16]byte {
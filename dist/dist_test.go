@@ -2,6 +2,7 @@ package dist
 
 import (
 	"bytes"
+	"compress/zlib"
 	"fmt"
 	"github.com/halturin/ergo/etf"
 	"github.com/halturin/ergo/lib"
@@ -110,10 +111,292 @@ func TestValidateChallengeAck(t *testing.T) {
 
 }
 
+func TestComposeNameVersion6(t *testing.T) {
+	link := &Link{
+		Name:     "a@b",
+		flags:    toNodeFlag(PUBLISHED, HANDSHAKE_23, SPAWN),
+		creation: 0x01020304,
+	}
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	link.composeNameVersion6(b, false)
+	expected := []byte{
+		0, 18, // length
+		'N',
+		0, 0, 0, 1, 1, 0, 0, 1, // flags
+		1, 2, 3, 4, // creation
+		0, 3, 'a', '@', 'b', // name
+	}
+	if !bytes.Equal(b.B, expected) {
+		t.Fatal("exp:", expected, "got:", b.B)
+	}
+
+	peer, ok := link.readNameVersion6(b.B[3:])
+	if !ok {
+		t.Fatal("can't read name")
+	}
+	if peer.Name != link.Name || peer.flags != link.flags || peer.creation != link.creation {
+		t.Fatalf("incorrect value: %#v", peer)
+	}
+}
+
+func TestComposeComplement(t *testing.T) {
+	link := &Link{
+		flags:    toNodeFlag(PUBLISHED, HANDSHAKE_23, SPAWN),
+		creation: 0x01020304,
+	}
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	link.composeComplement(b, false)
+	expected := []byte{
+		0, 9, // length
+		'c',
+		0, 0, 0, 1, // flags (high)
+		1, 2, 3, 4, // creation
+	}
+	if !bytes.Equal(b.B, expected) {
+		t.Fatal("exp:", expected, "got:", b.B)
+	}
+
+	acceptor := &Link{
+		peer: &Link{
+			flags: toNodeFlag(PUBLISHED, HANDSHAKE_23),
+		},
+	}
+	acceptor.readComplement(b.B[3:])
+	if acceptor.peer.flags != link.flags || acceptor.peer.creation != link.creation {
+		t.Fatalf("incorrect value: %#v", acceptor.peer)
+	}
+}
+
+func testHandshake(t *testing.T, tls bool, version uint16, hidden bool) (*Link, *Link) {
+	server, client := net.Pipe()
+
+	type result struct {
+		link *Link
+		err  error
+	}
+	accepted := make(chan result)
+	go func() {
+		options := HandshakeOptions{
+			Name:     "acceptor@localhost",
+			Cookie:   "cookie",
+			TLS:      tls,
+			Creation: 0x11223344,
+		}
+		link, err := HandshakeAccept(server, options)
+		accepted <- result{link, err}
+	}()
+
+	options := HandshakeOptions{
+		Name:     "initiator@localhost",
+		Cookie:   "cookie",
+		TLS:      tls,
+		Creation: 0x55667788,
+		Version:  version,
+		Hidden:   hidden,
+	}
+	initiator, err := Handshake(client, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	acceptor := r.link
+
+	if initiator.GetPeerName() != "acceptor@localhost" {
+		t.Fatal("incorrect peer name", initiator.GetPeerName())
+	}
+	if acceptor.GetPeerName() != "initiator@localhost" {
+		t.Fatal("incorrect peer name", acceptor.GetPeerName())
+	}
+
+	return initiator, acceptor
+}
+
+func TestHandshakeVersion5(t *testing.T) {
+	for _, tls := range []bool{false, true} {
+		initiator, acceptor := testHandshake(t, tls, ProtoHandshake5, false)
+
+		// both sides support handshake version 6, so the acceptor must
+		// reply with the 'N' challenge and get the complement message
+		if initiator.version != ProtoHandshake6 || acceptor.version != ProtoHandshake6 {
+			t.Fatal("handshake version 6 should be negotiated")
+		}
+		if initiator.peer.creation != 0x11223344 || acceptor.peer.creation != 0x55667788 {
+			t.Fatal("incorrect creation", initiator.peer.creation, acceptor.peer.creation)
+		}
+		if acceptor.peer.flags != defaultFlags() {
+			t.Fatal("high bits of the flags are lost")
+		}
+		if !initiator.peer.flags.isSet(BIG_CREATION) || !acceptor.peer.flags.isSet(BIG_CREATION) {
+			t.Fatal("BIG_CREATION flag is not set")
+		}
+	}
+}
+
+func TestHandshakeVersion6(t *testing.T) {
+	for _, tls := range []bool{false, true} {
+		initiator, acceptor := testHandshake(t, tls, ProtoHandshake6, false)
+
+		if initiator.version != ProtoHandshake6 || acceptor.version != ProtoHandshake6 {
+			t.Fatal("handshake version 6 should be negotiated")
+		}
+		if initiator.peer.creation != 0x11223344 || acceptor.peer.creation != 0x55667788 {
+			t.Fatal("incorrect creation", initiator.peer.creation, acceptor.peer.creation)
+		}
+		if acceptor.peer.flags != defaultFlags() || initiator.peer.flags != defaultFlags() {
+			t.Fatal("incorrect flags")
+		}
+	}
+}
+
+func TestHandshakeHidden(t *testing.T) {
+	for _, version := range []uint16{ProtoHandshake5, ProtoHandshake6} {
+		initiator, acceptor := testHandshake(t, false, version, true)
+
+		if acceptor.peer.flags.isSet(PUBLISHED) {
+			t.Fatal("hidden node must not set PUBLISHED flag")
+		}
+		if !acceptor.IsHidden() || !initiator.IsHidden() {
+			t.Fatal("connection must be hidden on both sides")
+		}
+		if !acceptor.IsPeerHidden() || initiator.IsPeerHidden() {
+			t.Fatal("incorrect type of the peer")
+		}
+	}
+}
+
+func TestHandshakeFallbackVersion5(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	// emulate an old node (before OTP.23) that doesn't support
+	// handshake version 6
+	go func() {
+		buf := make([]byte, 1024)
+		// read name
+		if _, err := server.Read(buf); err != nil {
+			return
+		}
+		old := &Link{
+			Name:      "old@localhost",
+			Cookie:    "cookie",
+			flags:     toNodeFlag(PUBLISHED, EXTENDED_REFERENCES, EXTENDED_PIDS_PORTS),
+			challenge: 12345,
+		}
+		old.peer = old.readName(buf[3:])
+		if old.peer.flags.isSet(HANDSHAKE_23) == false {
+			return
+		}
+		b := lib.TakeBuffer()
+		old.composeStatus(b, false, "ok")
+		server.Write(b.B)
+		old.composeChallenge(b, false)
+		server.Write(b.B)
+
+		// read challenge reply
+		n, err := server.Read(buf)
+		if err != nil || n < 23 || buf[2] != 'r' {
+			return
+		}
+		if !old.validateChallengeReply(buf[3:n]) {
+			return
+		}
+		old.composeChallengeAck(b, false)
+		server.Write(b.B)
+	}()
+
+	options := HandshakeOptions{
+		Name:     "initiator@localhost",
+		Cookie:   "cookie",
+		Creation: 0x55667788,
+		Version:  ProtoHandshake5,
+	}
+	link, err := Handshake(client, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.version != ProtoHandshake5 {
+		t.Fatal("handshake version 5 should be negotiated")
+	}
+	if link.peer.flags.isSet(BIG_CREATION) {
+		t.Fatal("peer doesn't support BIG_CREATION")
+	}
+}
+
+func TestHandshakeWrongCookie(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		options := HandshakeOptions{
+			Name:   "acceptor@localhost",
+			Cookie: "cookie1",
+		}
+		HandshakeAccept(server, options)
+		server.Close()
+	}()
+
+	options := HandshakeOptions{
+		Name:    "initiator@localhost",
+		Cookie:  "cookie2",
+		Version: ProtoHandshake6,
+	}
+	if _, err := Handshake(client, options); err == nil {
+		t.Fatal("handshake with the wrong cookie should fail")
+	}
+}
+
+func TestHandshakeRefusedByInitiator(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		options := HandshakeOptions{
+			Name:   "acceptor@localhost",
+			Cookie: "cookie",
+			Hidden: true,
+		}
+		_, err := HandshakeAccept(server, options)
+		accepted <- err
+		server.Close()
+	}()
+
+	options := HandshakeOptions{
+		Name:    "initiator@localhost",
+		Cookie:  "cookie",
+		Version: ProtoHandshake6,
+	}
+	options.Accept = func(name string, hidden bool) error {
+		if name != "acceptor@localhost" || hidden == false {
+			t.Error("wrong peer", name, hidden)
+		}
+		return fmt.Errorf("refused")
+	}
+	if _, err := Handshake(client, options); err == nil {
+		t.Fatal("handshake should be refused")
+	}
+	client.Close()
+	if err := <-accepted; err == nil {
+		t.Fatal("challenge reply must not be sent")
+	}
+}
+
 func TestDecodeDistHeaderAtomCache(t *testing.T) {
 	link := Link{}
-	link.cacheIn[1034] = "atom1"
-	link.cacheIn[5] = "atom2"
+	a1 := etf.Atom("atom1")
+	a2 := etf.Atom("atom2")
+	link.cacheIn[1034] = &a1
+	link.cacheIn[5] = &a2
 	packet := []byte{
 		131, 68, // start dist header
 		5, 4, 137, 9, // 5 atoms and theirs flags
@@ -132,12 +415,18 @@ func TestDecodeDistHeaderAtomCache(t *testing.T) {
 
 	cacheExpected := []etf.Atom{"atom1", "atom2", "reg", "call", "set_get_state"}
 	cacheInExpected := link.cacheIn
-	cacheInExpected[492] = "reg"
-	cacheInExpected[9] = "call"
-	cacheInExpected[494] = "set_get_state"
+	a3 := etf.Atom("reg")
+	a4 := etf.Atom("call")
+	a5 := etf.Atom("set_get_state")
+	cacheInExpected[492] = &a3
+	cacheInExpected[9] = &a4
+	cacheInExpected[494] = &a5
 
 	packetExpected := packet[34:]
-	cache, packet1 := link.decodeDistHeaderAtomCache(packet[2:])
+	cache, packet1, err := link.decodeDistHeaderAtomCache(packet[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if !bytes.Equal(packet1, packetExpected) {
 		t.Fatal("incorrect packet")
@@ -261,6 +550,75 @@ func BenchmarkEncodeDistHeaderAtomCache(b *testing.B) {
 	}
 }
 
+func TestReadDistCompressed(t *testing.T) {
+	link := &Link{}
+
+	packet := []byte{
+		protoDistMessage,
+		0,             // no atom cache
+		104, 1, 97, 6, // control: {6}
+		97, 1, // message: 1
+	}
+
+	z := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(z)
+	z.Append([]byte{protoDistCompressed, 0, 0, 0, byte(len(packet))})
+	zw := zlib.NewWriter(z)
+	zw.Write(packet)
+	zw.Close()
+
+	control, message, err := link.ReadDist(z.B)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(control, etf.Tuple{6}) {
+		t.Fatal("incorrect control", control)
+	}
+	if message != 1 {
+		t.Fatal("incorrect message", message)
+	}
+
+	// compressed packet inside of the compressed one
+	z.Reset()
+	packet[0] = protoDistCompressed
+	z.Append([]byte{protoDistCompressed, 0, 0, 0, byte(len(packet))})
+	zw = zlib.NewWriter(z)
+	zw.Write(packet)
+	zw.Close()
+	if _, _, err := link.ReadDist(z.B); err == nil {
+		t.Fatal("should be error here")
+	}
+
+	// uncompressed size is larger than the data
+	packet[0] = protoDistMessage
+	z.Reset()
+	z.Append([]byte{protoDistCompressed, 255, 255, 255, 255})
+	zw = zlib.NewWriter(z)
+	zw.Write(packet)
+	zw.Close()
+	if _, _, err := link.ReadDist(z.B); err == nil {
+		t.Fatal("should be error here")
+	}
+
+	// corrupted checksum
+	z.B[4] = byte(len(packet))
+	z.B[0], z.B[1], z.B[2], z.B[3] = protoDistCompressed, 0, 0, 0
+	z.B[len(z.B)-1]++
+	if _, _, err := link.ReadDist(z.B); err == nil {
+		t.Fatal("should be error here")
+	}
+
+	// trailing data
+	z.B[len(z.B)-1]--
+	if _, _, err := link.ReadDist(z.B); err != nil {
+		t.Fatal(err)
+	}
+	z.Append([]byte{1})
+	if _, _, err := link.ReadDist(z.B); err == nil {
+		t.Fatal("should be error here")
+	}
+}
+
 func TestDecodeFragment(t *testing.T) {
 	link := &Link{}
 
@@ -303,26 +661,33 @@ func TestDecodeFragment(t *testing.T) {
 		}
 	}
 
+	link.fragmentsMutex.Lock()
 	link.checkCleanTimeout = 50 * time.Millisecond
 	link.checkCleanDeadline = 150 * time.Millisecond
+	link.fragmentsMutex.Unlock()
 	// test lost fragment
 	// add the first fragment and wait 160ms
 	if x, e := link.decodeFragment(fragment1, true); x != nil || e != nil {
 		t.Fatal("should be nil here", e)
 	}
+	link.fragmentsMutex.Lock()
 	if len(link.fragments) == 0 {
 		t.Fatal("fragments should have a record ")
 	}
+	link.fragmentsMutex.Unlock()
 	// check and clean process should remove this record
 	time.Sleep(360 * time.Millisecond)
 
-	// map of the fragments should be empty here
+	// map of the fragments should be empty here. the cleaner
+	// is still running, so the link must be locked
+	link.fragmentsMutex.Lock()
 	if len(link.fragments) > 0 {
 		t.Fatal("fragments should be empty")
 	}
 
 	link.checkCleanTimeout = 0
 	link.checkCleanDeadline = 0
+	link.fragmentsMutex.Unlock()
 	fragments := [][]byte{
 		[]byte{0, 0, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 9, 1, 2, 3},
 		[]byte{0, 0, 0, 0, 0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 8, 4, 5, 6},
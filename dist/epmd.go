@@ -6,17 +6,20 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/halturin/ergo/lib"
 )
 
 const (
-	EPMD_ALIVE2_REQ  = 120
-	EPMD_ALIVE2_RESP = 121
+	EPMD_ALIVE2_REQ    = 120
+	EPMD_ALIVE2_RESP   = 121
+	EPMD_ALIVE2_X_RESP = 118 // since OTP.23 (32-bit creation)
 
 	EPMD_PORT_PLEASE2_REQ = 122
 	EPMD_PORT2_RESP       = 119
@@ -28,6 +31,12 @@ const (
 	EPMD_STOP_REQ = 115 // $s
 )
 
+const (
+	epmdTimeout  = 5 * time.Second
+	epmdRetryMin = 500 * time.Millisecond
+	epmdRetryMax = 10 * time.Second
+)
+
 type EPMD struct {
 	FullName string
 	Name     string
@@ -46,23 +55,36 @@ type EPMD struct {
 	HighVsn  uint16
 	LowVsn   uint16
 	Extra    []byte
-	Creation uint16
+	Creation uint32
 
-	staticRoutes map[string]uint16
-	mtx          sync.RWMutex
+	// ServerAddress is a comma separated list of the addresses the embedded
+	// EPMD server listens on (in fashion of ERL_EPMD_ADDRESS). It listens on
+	// all the interfaces if it's empty
+	ServerAddress string
+
+	staticRoutes  map[string]uint16
+	registered    bool
+	registerError error
+	unregister    context.CancelFunc
+	unregistered  chan struct{}
+	mtx           sync.RWMutex
 
 	response chan interface{}
 }
 
-func (e *EPMD) Init(ctx context.Context, name string, listenport uint16, epmdport uint16, hidden bool, disableServer bool) {
-	ns := strings.Split(name, "@")
-	if len(ns) != 2 {
-		panic("FQDN for node name is required (example: node@hostname)")
+// Init registers the node in EPMD (the embedded EPMD server is started unless
+// disableServer is true). Returns error if the registration has failed. Once
+// registered, the node is registered again (with backoff) if the connection
+// with EPMD has been lost. The node is unregistered when the context is done.
+func (e *EPMD) Init(ctx context.Context, name string, listenport uint16, epmdport uint16, hidden bool, disableServer bool) error {
+	alive, host, err := SplitNodeName(name)
+	if err != nil {
+		return err
 	}
 
 	e.FullName = name
-	e.Name = ns[0]
-	e.Domain = ns[1]
+	e.Name = alive
+	e.Domain = host
 	e.Port = listenport
 	e.PortEMPD = epmdport
 
@@ -73,72 +95,160 @@ func (e *EPMD) Init(ctx context.Context, name string, listenport uint16, epmdpor
 	}
 
 	e.Protocol = 0
-	e.HighVsn = 5
-	e.LowVsn = 5
+	e.HighVsn = ProtoHandshake6
+	e.LowVsn = ProtoHandshake5
 	e.Creation = 0
 
+	e.mtx.Lock()
 	e.staticRoutes = make(map[string]uint16)
+	e.mtx.Unlock()
 
-	ready := make(chan bool)
+	ctx, e.unregister = context.WithCancel(ctx)
+	e.unregistered = make(chan struct{})
 
-	go func(e *EPMD) {
-		defer close(ready)
-		for {
-			if !disableServer {
-				// trying to start embedded EPMD before we go further
-				Server(ctx, epmdport)
-			}
-			dsn := net.JoinHostPort("", strconv.Itoa(int(epmdport)))
-			conn, err := net.Dial("tcp", dsn)
-			if err != nil {
-				// we can't work without epmd service
-				panic(err.Error())
-			}
+	conn, err := e.register(ctx, disableServer)
+	if err != nil {
+		e.unregister()
+		e.unregister = nil
+		return err
+	}
+	go func() {
+		defer close(e.unregistered)
+		e.keepRegistered(ctx, conn, disableServer)
+	}()
+	return nil
+}
 
-			conn.Write(compose_ALIVE2_REQ(e))
+// Unregister closes the connection with EPMD (so the node is unregistered)
+// and stops the registration attempts
+func (e *EPMD) Unregister() {
+	if e.unregister == nil {
+		return
+	}
+	e.unregister()
+	<-e.unregistered
+}
 
-			for {
-				buf := make([]byte, 1024)
-				_, err := conn.Read(buf)
-				if err != nil {
-					lib.Log("EPMD: closing connection")
-					conn.Close()
-					break
-				}
+// Registered returns true if the node is registered in EPMD. Otherwise, it
+// returns the reason of the last failed registration attempt
+func (e *EPMD) Registered() (bool, error) {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.registered, e.registerError
+}
 
-				if buf[0] == EPMD_ALIVE2_RESP {
-					creation := read_ALIVE2_RESP(buf)
-					switch creation {
-					case false:
-						panic(fmt.Sprintf("Duplicate name '%s'", e.Name))
-					default:
-						e.Creation = creation.(uint16)
-					}
-					ready <- true
-				} else {
-					lib.Log("Malformed EPMD reply")
-					conn.Close()
-					break
+func (e *EPMD) setRegistered(registered bool, err error) {
+	e.mtx.Lock()
+	e.registered = registered
+	e.registerError = err
+	e.mtx.Unlock()
+}
+
+func (e *EPMD) register(ctx context.Context, disableServer bool) (net.Conn, error) {
+	if !disableServer {
+		// trying to start embedded EPMD before we go further
+		ServerWithAddress(ctx, e.ServerAddress, e.PortEMPD)
+	}
+
+	dsn := net.JoinHostPort("", strconv.Itoa(int(e.PortEMPD)))
+	conn, err := net.DialTimeout("tcp", dsn, epmdTimeout)
+	if err != nil {
+		e.setRegistered(false, err)
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(epmdTimeout))
+	creation, err := func() (uint32, error) {
+		if _, err := conn.Write(compose_ALIVE2_REQ(e)); err != nil {
+			return 0, err
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		return read_ALIVE2_RESP(buf[:n])
+	}()
+	if err != nil {
+		lib.Log("EPMD: can't register '%s': %s", e.Name, err)
+		conn.Close()
+		e.setRegistered(false, err)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if e.Creation == 0 {
+		e.Creation = creation
+	}
+	// the creation of the registered again node is kept since it's
+	// already used in the pids, references and the established connections
+	e.setRegistered(true, nil)
+	return conn, nil
+}
+
+func (e *EPMD) keepRegistered(ctx context.Context, conn net.Conn, disableServer bool) {
+	var delay time.Duration
+	for {
+		if conn != nil {
+			closed := make(chan struct{})
+			go func(c net.Conn) {
+				select {
+				case <-ctx.Done():
+					// closing connection unregisters the node
+					c.Close()
+				case <-closed:
 				}
+			}(conn)
+
+			// EPMD sends nothing, so it returns once the connection is closed
+			_, err := conn.Read(make([]byte, 1024))
+			close(closed)
+			conn.Close()
+
+			if ctx.Err() != nil {
+				e.setRegistered(false, nil)
+				return
 			}
+			lib.Log("EPMD: connection has been lost: %s", err)
+			e.setRegistered(false, err)
+			delay = 0
+		}
+
+		delay *= 2
+		if delay < epmdRetryMin {
+			delay = epmdRetryMin
+		}
+		if delay > epmdRetryMax {
+			delay = epmdRetryMax
+		}
 
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
 		}
-	}(e)
 
-	<-ready
+		conn, _ = e.register(ctx, disableServer)
+		if conn != nil {
+			lib.Log("EPMD: node '%s' has been registered again", e.Name)
+		}
+	}
 }
 
 func (e *EPMD) AddStaticRoute(name string, port uint16) error {
-	ns := strings.Split(name, "@")
-	if len(ns) != 2 {
-		return fmt.Errorf("wrong FQDN")
+	_, host, err := SplitNodeName(name)
+	if err != nil {
+		return err
 	}
-	if _, err := net.LookupHost(ns[1]); err != nil {
+	if _, err := net.LookupHost(host); err != nil {
 		return err
 	}
 
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
+	if e.staticRoutes == nil {
+		e.staticRoutes = make(map[string]uint16)
+	}
 	if _, ok := e.staticRoutes[name]; ok {
 		// already exist
 		return fmt.Errorf("already exist")
@@ -155,12 +265,28 @@ func (e *EPMD) RemoveStaticRoute(name string) {
 	return
 }
 
+// StaticRoute returns the port of the static route for the given node
+func (e *EPMD) StaticRoute(name string) (uint16, bool) {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	port, ok := e.staticRoutes[name]
+	return port, ok
+}
+
 func (e *EPMD) ResolvePort(name string) (int, error) {
+	port, _, err := e.Resolve(name)
+	return port, err
+}
+
+// Resolve returns the listening port of the given node and the highest
+// version of the distribution protocol it supports. Static routes
+// are considered as the nodes with the handshake version 5.
+func (e *EPMD) Resolve(name string) (int, uint16, error) {
 	// chech static routes first
 	e.mtx.RLock()
 	if port, ok := e.staticRoutes[name]; ok {
 		e.mtx.RUnlock()
-		return int(port), nil
+		return int(port), ProtoHandshake5, nil
 	}
 
 	e.mtx.RUnlock()
@@ -168,36 +294,46 @@ func (e *EPMD) ResolvePort(name string) (int, error) {
 	return e.resolvePort(name)
 }
 
-func (e *EPMD) resolvePort(name string) (int, error) {
-	ns := strings.Split(name, "@")
-	conn, err := net.Dial("tcp", net.JoinHostPort(ns[1], fmt.Sprintf("%d", e.PortEMPD)))
+func (e *EPMD) resolvePort(name string) (int, uint16, error) {
+	alive, host, err := SplitNodeName(name)
+	if err != nil {
+		return -1, 0, err
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", e.PortEMPD)))
 	if err != nil {
-		return -1, err
+		return -1, 0, err
 	}
 
 	defer conn.Close()
 
-	buf := compose_PORT_PLEASE2_REQ(ns[0])
+	buf := compose_PORT_PLEASE2_REQ(alive)
 	_, err = conn.Write(buf)
 	if err != nil {
-		return -1, fmt.Errorf("initiate connection - %s", err)
+		return -1, 0, fmt.Errorf("initiate connection - %s", err)
 	}
 
 	buf = make([]byte, 1024)
-	_, err = conn.Read(buf)
+	n, err := conn.Read(buf)
 	if err != nil && err != io.EOF {
-		return -1, fmt.Errorf("reading from link - %s", err)
+		return -1, 0, fmt.Errorf("reading from link - %s", err)
 	}
 
-	if buf[0] == EPMD_PORT2_RESP && buf[1] == 0 {
-		p := binary.BigEndian.Uint16(buf[2:4])
-		// we don't use all the extra info for a while. FIXME (do we need it?)
-		return int(p), nil
-	} else if buf[1] > 0 {
-		return -1, fmt.Errorf("desired node not found")
-	} else {
-		return -1, fmt.Errorf("malformed reply - %#v", buf)
+	return read_PORT2_RESP(buf[:n])
+}
+
+// SplitNodeName splits the node name into the name and host parts. Node name
+// is split at the first '@', so the host can be an IPv6 address (node@::1).
+// The brackets around the host are removed (node@[::1])
+func SplitNodeName(name string) (string, string, error) {
+	i := strings.Index(name, "@")
+	if i < 1 || i == len(name)-1 {
+		return "", "", fmt.Errorf("FQDN for node name is required (example: node@hostname)")
 	}
+	host := name[i+1:]
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return name[:i], host, nil
 }
 
 func compose_ALIVE2_REQ(e *EPMD) (reply []byte) {
@@ -219,11 +355,35 @@ func compose_ALIVE2_REQ(e *EPMD) (reply []byte) {
 	return
 }
 
-func read_ALIVE2_RESP(reply []byte) interface{} {
-	if reply[1] == 0 {
-		return binary.BigEndian.Uint16(reply[2:4])
+func read_ALIVE2_RESP(reply []byte) (uint32, error) {
+	switch {
+	case len(reply) < 2 || (reply[0] != EPMD_ALIVE2_RESP && reply[0] != EPMD_ALIVE2_X_RESP):
+		return 0, fmt.Errorf("malformed EPMD reply - %#v", reply)
+	case reply[1] != 0:
+		return 0, ErrDuplicateName
+	case reply[0] == EPMD_ALIVE2_X_RESP && len(reply) >= 6:
+		// OTP.23 and above replies with 32-bit creation
+		return binary.BigEndian.Uint32(reply[2:6]), nil
+	case reply[0] == EPMD_ALIVE2_RESP && len(reply) >= 4:
+		return uint32(binary.BigEndian.Uint16(reply[2:4])), nil
+	}
+	return 0, fmt.Errorf("malformed EPMD reply - %#v", reply)
+}
+
+func read_PORT2_RESP(reply []byte) (int, uint16, error) {
+	if len(reply) < 2 || reply[0] != EPMD_PORT2_RESP {
+		return -1, 0, fmt.Errorf("malformed reply - %#v", reply)
+	}
+	if reply[1] > 0 {
+		return -1, 0, fmt.Errorf("desired node not found")
+	}
+	if len(reply) < 10 {
+		return -1, 0, fmt.Errorf("malformed reply - %#v", reply)
 	}
-	return false
+	// [2:4] port, [4] node type, [5] protocol, [6:8] highest version, [8:10] lowest version
+	port := binary.BigEndian.Uint16(reply[2:4])
+	version := binary.BigEndian.Uint16(reply[6:8])
+	return int(port), version, nil
 }
 
 func compose_PORT_PLEASE2_REQ(name string) (reply []byte) {
@@ -243,11 +403,27 @@ type nodeinfo struct {
 	HiVersion uint16
 	LoVersion uint16
 	Extra     []byte
+	conn      net.Conn
 }
 
 type embeddedEPMDserver struct {
-	portmap map[string]*nodeinfo
-	mtx     sync.RWMutex
+	portmap  map[string]*nodeinfo
+	mtx      sync.RWMutex
+	creation uint32
+	relaxed  bool
+	kill     chan struct{}
+	killOnce sync.Once
+}
+
+// ServerOptions defines the options of the EPMD service
+type ServerOptions struct {
+	// Address is a comma separated list of the addresses to listen on
+	// (see ServerWithAddress)
+	Address string
+	Port    uint16
+	// RelaxedCommandCheck allows KILL_REQ while there are registered nodes
+	// and STOP_REQ (in fashion of epmd -relaxed_command_check)
+	RelaxedCommandCheck bool
 }
 
 func (e *embeddedEPMDserver) Join(name string, info *nodeinfo) bool {
@@ -273,12 +449,45 @@ func (e *embeddedEPMDserver) Get(name string) *nodeinfo {
 	return nil
 }
 
-func (e *embeddedEPMDserver) Leave(name string) {
+func (e *embeddedEPMDserver) Leave(name string, c net.Conn) {
 	lib.Log("EPMD unregistering node: '%s'", name)
 
 	e.mtx.Lock()
+	// the name could be registered again (after STOP_REQ) by another connection
+	if info, ok := e.portmap[name]; ok && info.conn == c {
+		delete(e.portmap, name)
+	}
+	e.mtx.Unlock()
+}
+
+func (e *embeddedEPMDserver) Stop(name string) bool {
+	e.mtx.Lock()
+	info, ok := e.portmap[name]
 	delete(e.portmap, name)
 	e.mtx.Unlock()
+	if !ok {
+		return false
+	}
+	lib.Log("EPMD stopping node: '%s'", name)
+	if info.conn != nil {
+		info.conn.Close()
+	}
+	return true
+}
+
+func (e *embeddedEPMDserver) Kill() {
+	e.killOnce.Do(func() {
+		lib.Log("EPMD killed")
+		e.mtx.Lock()
+		for name, info := range e.portmap {
+			if info.conn != nil {
+				info.conn.Close()
+			}
+			delete(e.portmap, name)
+		}
+		e.mtx.Unlock()
+		close(e.kill)
+	})
 }
 
 func (e *embeddedEPMDserver) ListAll() map[string]uint16 {
@@ -291,26 +500,103 @@ func (e *embeddedEPMDserver) ListAll() map[string]uint16 {
 	return lst
 }
 
+// Server starts EPMD service listening on all the interfaces
 func Server(ctx context.Context, port uint16) error {
+	return ServerWithAddress(ctx, "", port)
+}
 
-	lc := net.ListenConfig{}
-	epmd, err := lc.Listen(ctx, "tcp", net.JoinHostPort("", strconv.Itoa(int(port))))
-	if err != nil {
-		lib.Log("Can't start embedded EPMD service: %s", err)
-		return fmt.Errorf("Can't start embedded EPMD service: %s", err)
+// ServerWithAddress starts EPMD service listening on the given comma
+// separated list of addresses (in fashion of ERL_EPMD_ADDRESS). The loopback
+// address is added implicitly. Empty address means all the interfaces
+func ServerWithAddress(ctx context.Context, address string, port uint16) error {
+	_, err := ServerWithOptions(ctx, ServerOptions{Address: address, Port: port})
+	return err
+}
 
+// ServerWithOptions starts EPMD service with the given options. Returned
+// channel is closed once the service has been killed by KILL_REQ
+func ServerWithOptions(ctx context.Context, options ServerOptions) (<-chan struct{}, error) {
+	var listeners []net.Listener
+
+	lc := net.ListenConfig{}
+	for _, addr := range serverAddresses(options.Address) {
+		epmd, err := lc.Listen(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(int(options.Port))))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			lib.Log("Can't start embedded EPMD service: %s", err)
+			return nil, fmt.Errorf("Can't start embedded EPMD service: %s", err)
+		}
+		listeners = append(listeners, epmd)
 	}
 
 	epmdServer := &embeddedEPMDserver{
-		portmap: make(map[string]*nodeinfo),
+		portmap:  make(map[string]*nodeinfo),
+		creation: uint32(time.Now().Unix()),
+		relaxed:  options.RelaxedCommandCheck,
+		kill:     make(chan struct{}),
 	}
 
-	lib.Log("Started embedded EMPD service and listen port: %d", port)
+	lib.Log("Started embedded EMPD service and listen port: %d", options.Port)
+
+	for _, epmd := range listeners {
+		epmdServer.serve(epmd, options.Port)
+	}
 
+	go func() {
+		<-epmdServer.kill
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	return epmdServer.kill, nil
+}
+
+func serverAddresses(address string) []string {
+	var addresses []string
+	var loopback4, loopback6, ipv6 bool
+
+	for _, addr := range strings.Split(address, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if ip := net.ParseIP(addr); ip != nil {
+			switch {
+			case ip.To4() == nil:
+				ipv6 = true
+				loopback6 = loopback6 || ip.IsLoopback()
+			default:
+				loopback4 = loopback4 || ip.IsLoopback()
+			}
+		}
+		addresses = append(addresses, addr)
+	}
+
+	if len(addresses) == 0 {
+		// all the interfaces
+		return []string{""}
+	}
+	if !loopback4 {
+		addresses = append(addresses, "127.0.0.1")
+	}
+	if ipv6 && !loopback6 {
+		addresses = append(addresses, "::1")
+	}
+	return addresses
+}
+
+func (e *embeddedEPMDserver) serve(epmd net.Listener, port uint16) {
 	go func() {
 		for {
 			c, err := epmd.Accept()
 			if err != nil {
+				select {
+				case <-e.kill:
+					return
+				default:
+				}
 				lib.Log(err.Error())
 				continue
 			}
@@ -327,7 +613,7 @@ func Server(ctx context.Context, port uint16) error {
 					lib.Log("Request from EPMD client: %v", buf[:n])
 					if err != nil {
 						if name != "" {
-							epmdServer.Leave(name)
+							e.Leave(name, c)
 						}
 						return
 					}
@@ -338,7 +624,7 @@ func Server(ctx context.Context, port uint16) error {
 
 					switch buf[2] {
 					case EPMD_ALIVE2_REQ:
-						reply, registered := epmdServer.compose_ALIVE2_RESP(buf[3:n])
+						reply, registered := e.compose_ALIVE2_RESP(c, buf[3:n])
 						c.Write(reply)
 						if registered == "" {
 							return
@@ -351,10 +637,45 @@ func Server(ctx context.Context, port uint16) error {
 						}
 						continue
 					case EPMD_PORT_PLEASE2_REQ:
-						c.Write(epmdServer.compose_EPMD_PORT2_RESP(buf[3:n]))
+						c.Write(e.compose_EPMD_PORT2_RESP(buf[3:n]))
 						return
 					case EPMD_NAMES_REQ:
-						c.Write(epmdServer.compose_EPMD_NAMES_RESP(port, buf[3:n]))
+						c.Write(e.compose_EPMD_NAMES_RESP(port, buf[3:n]))
+						return
+					case EPMD_DUMP_REQ:
+						if !isLocalPeer(c) {
+							lib.Log("EPMD: DUMP_REQ from non local address")
+							return
+						}
+						c.Write(e.compose_EPMD_DUMP_RESP(port))
+						return
+					case EPMD_KILL_REQ:
+						if !isLocalPeer(c) {
+							lib.Log("EPMD: KILL_REQ from non local address")
+							return
+						}
+						if !e.relaxed && len(e.ListAll()) > 0 {
+							lib.Log("EPMD: disallowed KILL_REQ, live nodes")
+							c.Write([]byte("NO"))
+							return
+						}
+						c.Write([]byte("OK"))
+						e.Kill()
+						return
+					case EPMD_STOP_REQ:
+						if !isLocalPeer(c) {
+							lib.Log("EPMD: STOP_REQ from non local address")
+							return
+						}
+						if !e.relaxed {
+							lib.Log("EPMD: disallowed STOP_REQ")
+							return
+						}
+						if e.Stop(string(buf[3:n])) {
+							c.Write([]byte("STOPPED"))
+						} else {
+							c.Write([]byte("NOEXIST"))
+						}
 						return
 					default:
 						lib.Log("unknown EPMD request")
@@ -366,11 +687,14 @@ func Server(ctx context.Context, port uint16) error {
 
 		}
 	}()
+}
 
-	return nil
+func isLocalPeer(c net.Conn) bool {
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
 }
 
-func (e *embeddedEPMDserver) compose_ALIVE2_RESP(req []byte) ([]byte, string) {
+func (e *embeddedEPMDserver) compose_ALIVE2_RESP(c net.Conn, req []byte) ([]byte, string) {
 
 	hidden := false //
 	if req[2] == 72 {
@@ -385,10 +709,23 @@ func (e *embeddedEPMDserver) compose_ALIVE2_RESP(req []byte) ([]byte, string) {
 		Hidden:    hidden,
 		HiVersion: binary.BigEndian.Uint16(req[4:6]),
 		LoVersion: binary.BigEndian.Uint16(req[6:8]),
+		conn:      c,
 	}
 
-	reply := make([]byte, 4)
-	reply[0] = EPMD_ALIVE2_RESP
+	creation := atomic.AddUint32(&e.creation, 1)
+
+	var reply []byte
+	if info.HiVersion >= ProtoHandshake6 {
+		// the node supports 32-bit creation
+		reply = make([]byte, 6)
+		reply[0] = EPMD_ALIVE2_X_RESP
+		binary.BigEndian.PutUint32(reply[2:], creation)
+	} else {
+		reply = make([]byte, 4)
+		reply[0] = EPMD_ALIVE2_RESP
+		// old nodes use 2-bit creation (1..3)
+		binary.BigEndian.PutUint16(reply[2:], uint16(creation%3+1))
+	}
 
 	registered := ""
 	if e.Join(name, &info) {
@@ -398,7 +735,6 @@ func (e *embeddedEPMDserver) compose_ALIVE2_RESP(req []byte) ([]byte, string) {
 		reply[1] = 1
 	}
 
-	binary.BigEndian.PutUint16(reply[2:], uint16(1))
 	lib.Log("Made reply for ALIVE2_REQ: (%s) %#v", name, reply)
 	return reply, registered
 }
@@ -451,3 +787,30 @@ func (e *embeddedEPMDserver) compose_EPMD_NAMES_RESP(port uint16, req []byte) []
 
 	return []byte(str.String())
 }
+
+func (e *embeddedEPMDserver) compose_EPMD_DUMP_RESP(port uint16) []byte {
+	// the same format as the NAMES_RESP has. there are no unused names here
+	var str strings.Builder
+	var portbuf [4]byte
+	binary.BigEndian.PutUint32(portbuf[0:4], uint32(port))
+	str.WriteString(string(portbuf[0:]))
+
+	e.mtx.RLock()
+	names := make([]string, 0, len(e.portmap))
+	for name := range e.portmap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := e.portmap[name]
+		nodeType := "visible"
+		if info.Hidden {
+			nodeType = "hidden"
+		}
+		str.WriteString(fmt.Sprintf("active name     <%s> at port %d, %s, version %d..%d\n",
+			name, info.Port, nodeType, info.LoVersion, info.HiVersion))
+	}
+	e.mtx.RUnlock()
+
+	return []byte(str.String())
+}
@@ -0,0 +1,178 @@
+package dist
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitNodeName(t *testing.T) {
+	cases := []struct {
+		name  string
+		alive string
+		host  string
+	}{
+		{"node@localhost", "node", "localhost"},
+		{"node@127.0.0.1", "node", "127.0.0.1"},
+		{"node@::1", "node", "::1"},
+		{"node@[::1]", "node", "::1"},
+		{"node@fe80::1@x", "node", "fe80::1@x"},
+	}
+	for _, c := range cases {
+		alive, host, err := SplitNodeName(c.name)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if alive != c.alive || host != c.host {
+			t.Fatal(c.name, "got", alive, host)
+		}
+	}
+
+	for _, name := range []string{"node", "@localhost", "node@", ""} {
+		if _, _, err := SplitNodeName(name); err == nil {
+			t.Fatal("expected error for", name)
+		}
+	}
+}
+
+func TestEPMDServerAddress(t *testing.T) {
+	cases := []struct {
+		address   string
+		addresses []string
+	}{
+		{"", []string{""}},
+		{"127.0.0.1", []string{"127.0.0.1"}},
+		{"10.0.0.1", []string{"10.0.0.1", "127.0.0.1"}},
+		{"10.0.0.1, fd00::1", []string{"10.0.0.1", "fd00::1", "127.0.0.1", "::1"}},
+		{"::1", []string{"::1", "127.0.0.1"}},
+	}
+	for _, c := range cases {
+		if addresses := serverAddresses(c.address); !reflect.DeepEqual(addresses, c.addresses) {
+			t.Fatal(c.address, "expected", c.addresses, "got", addresses)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	if err := ServerWithAddress(ctx, "127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	epmd := EPMD{PortEMPD: port}
+	if _, _, err := epmd.Resolve("unknown@127.0.0.1"); err == nil || err.Error() != "desired node not found" {
+		t.Fatal("expected 'desired node not found', got", err)
+	}
+}
+
+func testEPMDRequest(t *testing.T, port uint16, command byte, payload string) string {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 3+len(payload))
+	binary.BigEndian.PutUint16(buf[0:2], uint16(1+len(payload)))
+	buf[2] = command
+	copy(buf[3:], payload)
+	conn.Write(buf)
+	reply, _ := ioutil.ReadAll(conn)
+	return string(reply)
+}
+
+func testEPMDRegister(t *testing.T, port uint16, name string) net.Conn {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &EPMD{Name: name, Port: 12345, Type: 77, HighVsn: ProtoHandshake6, LowVsn: ProtoHandshake5}
+	conn.Write(compose_ALIVE2_REQ(e))
+	buf := make([]byte, 16)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read_ALIVE2_RESP(buf); err != nil {
+		t.Fatal("can't register", name, err)
+	}
+	return conn
+}
+
+func testEPMDPort(t *testing.T) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestEPMDCommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := testEPMDPort(t)
+	if _, err := ServerWithOptions(ctx, ServerOptions{Address: "127.0.0.1", Port: port}); err != nil {
+		t.Fatal(err)
+	}
+	conn := testEPMDRegister(t, port, "nodeDump")
+	defer conn.Close()
+
+	dump := testEPMDRequest(t, port, EPMD_DUMP_REQ, "")
+	if binary.BigEndian.Uint32([]byte(dump[0:4])) != uint32(port) {
+		t.Fatal("wrong port in DUMP_RESP")
+	}
+	if !strings.Contains(dump, "active name     <nodeDump> at port 12345") {
+		t.Fatal("unexpected DUMP_RESP", dump)
+	}
+	if reply := testEPMDRequest(t, port, EPMD_KILL_REQ, ""); reply != "NO" {
+		t.Fatal("KILL_REQ with live nodes must be disallowed, got", reply)
+	}
+	if reply := testEPMDRequest(t, port, EPMD_STOP_REQ, "nodeDump"); reply != "" {
+		t.Fatal("STOP_REQ must be disallowed, got", reply)
+	}
+
+	// relaxed command check
+	port = testEPMDPort(t)
+	killed, err := ServerWithOptions(ctx, ServerOptions{Address: "127.0.0.1", Port: port, RelaxedCommandCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn = testEPMDRegister(t, port, "nodeStop")
+	defer conn.Close()
+
+	if reply := testEPMDRequest(t, port, EPMD_STOP_REQ, "nodeStop"); reply != "STOPPED" {
+		t.Fatal("expected STOPPED, got", reply)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("registration connection must be closed")
+	}
+	if reply := testEPMDRequest(t, port, EPMD_STOP_REQ, "nodeStop"); reply != "NOEXIST" {
+		t.Fatal("expected NOEXIST, got", reply)
+	}
+
+	conn = testEPMDRegister(t, port, "nodeKill")
+	defer conn.Close()
+	if reply := testEPMDRequest(t, port, EPMD_KILL_REQ, ""); reply != "OK" {
+		t.Fatal("expected OK, got", reply)
+	}
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("EPMD is not killed")
+	}
+	if _, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), time.Second); err == nil {
+		t.Fatal("EPMD is still listening")
+	}
+}
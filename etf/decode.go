@@ -1,8 +1,11 @@
 package etf
 
 import (
+	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 )
@@ -50,6 +53,7 @@ var (
 	errMalformedFun           = fmt.Errorf("Malformed ETF. ettNewFun")
 	errMalformedExport        = fmt.Errorf("Malformed ETF. ettExport")
 	errMalformedUnknownType   = fmt.Errorf("Malformed ETF. unknown type")
+	errMalformedCompressed    = fmt.Errorf("Malformed ETF. ettCompressed")
 
 	errMalformed = fmt.Errorf("Malformed ETF")
 	errInternal  = fmt.Errorf("Internal error")
@@ -65,6 +69,55 @@ var (
 // see comments within this function
 
 func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr error) {
+	if len(packet) > 0 && packet[0] == ettCompressed {
+		return decodeCompressed(packet[1:], cache)
+	}
+	return decode(packet, cache)
+}
+
+func decodeCompressed(packet []byte, cache []Atom) (Term, []byte, error) {
+	// 4 (uncompressed size) + zlib data
+	if len(packet) < 5 {
+		return nil, nil, errMalformedCompressed
+	}
+
+	size := binary.BigEndian.Uint32(packet)
+	r := bytes.NewReader(packet[4:])
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, nil, errMalformedCompressed
+	}
+	defer zr.Close()
+
+	// the size comes from the peer so it isn't allocated in advance.
+	// the buffer grows while reading the uncompressed data
+	var uncompressed bytes.Buffer
+	n, err := io.Copy(&uncompressed, io.LimitReader(zr, int64(size)))
+	if err != nil || n != int64(size) {
+		return nil, nil, errMalformedCompressed
+	}
+
+	// must be the end of the zlib stream. it also makes
+	// zlib reader to verify the checksum
+	var tail [1]byte
+	if n, err := zr.Read(tail[:]); n != 0 || err != io.EOF {
+		return nil, nil, errMalformedCompressed
+	}
+
+	term, rest, err := decode(uncompressed.Bytes(), cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, errMalformedCompressed
+	}
+
+	// bytes.Reader implements io.ByteReader so the zlib reader
+	// doesn't read beyond the end of the compressed data
+	return term, packet[len(packet)-r.Len():], nil
+}
+
+func decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr error) {
 	var term Term
 	var stack *stackElement
 	var child *stackElement
@@ -477,9 +530,13 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 			case ettList:
 				stack.term.(List)[stack.i] = term
 				stack.i++
-				// remove the last element for proper list (its ettNil)
-				if stack.i == stack.children && t == ettNil {
-					stack.term = stack.term.(List)[:stack.i-1]
+				if stack.i == stack.children {
+					// remove the last element for proper list (its ettNil)
+					if tail, ok := term.(List); ok && len(tail) == 0 {
+						stack.term = stack.term.(List)[:stack.i-1]
+						break
+					}
+					stack.term = ListImproper(stack.term.(List))
 				}
 
 			case ettSmallTuple, ettLargeTuple:
@@ -511,7 +568,7 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 					Node:     name,
 					ID:       binary.BigEndian.Uint32(packet[:4]),
 					Serial:   binary.BigEndian.Uint32(packet[4:8]),
-					Creation: packet[8] & 3, // only two bits are significant, rest are to be 0
+					Creation: uint32(packet[8] & 3), // only two bits are significant, rest are to be 0
 				}
 
 				packet = packet[9:]
@@ -529,12 +586,10 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 				}
 
 				pid := Pid{
-					Node:   name,
-					ID:     binary.BigEndian.Uint32(packet[:4]),
-					Serial: binary.BigEndian.Uint32(packet[4:8]),
-					// FIXME: we must upgrade this type to uint32
-					// Creation: binary.BigEndian.Uint32(packet[8:12])
-					Creation: packet[11], // use the last byte for a while
+					Node:     name,
+					ID:       binary.BigEndian.Uint32(packet[:4]),
+					Serial:   binary.BigEndian.Uint32(packet[4:8]),
+					Creation: binary.BigEndian.Uint32(packet[8:12]),
 				}
 
 				packet = packet[12:]
@@ -559,7 +614,7 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 				ref := Ref{
 					Node:     name,
 					ID:       make([]uint32, l),
-					Creation: packet[0],
+					Creation: uint32(packet[0]),
 				}
 				packet = packet[1:]
 
@@ -588,11 +643,9 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 				}
 
 				ref := Ref{
-					Node: name,
-					ID:   make([]uint32, l),
-					// FIXME: we must upgrade this type to uint32
-					// Creation: binary.BigEndian.Uint32(packet[:4])
-					Creation: packet[3],
+					Node:     name,
+					ID:       make([]uint32, l),
+					Creation: binary.BigEndian.Uint32(packet[:4]),
 				}
 				packet = packet[4:]
 
@@ -618,7 +671,7 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 				port := Port{
 					Node:     name,
 					ID:       binary.BigEndian.Uint32(packet[:4]),
-					Creation: packet[4],
+					Creation: uint32(packet[4]),
 				}
 
 				packet = packet[5:]
@@ -636,11 +689,9 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 				}
 
 				port := Port{
-					Node: name,
-					ID:   binary.BigEndian.Uint32(packet[:4]),
-					// FIXME: we must upgrade this type to uint32
-					// Creation: binary.BigEndian.Uint32(packet[4:8])
-					Creation: packet[7],
+					Node:     name,
+					ID:       binary.BigEndian.Uint32(packet[:4]),
+					Creation: binary.BigEndian.Uint32(packet[4:8]),
 				}
 
 				packet = packet[8:]
@@ -721,6 +772,9 @@ func Decode(packet []byte, cache []Atom) (retTerm Term, retByte []byte, retErr e
 
 				}
 
+				stack.term = exp
+				stack.i++
+
 			default:
 				return nil, nil, errInternal
 			}
@@ -1,6 +1,7 @@
 package etf
 
 import (
+	"bytes"
 	"math/big"
 	"reflect"
 	"testing"
@@ -178,6 +179,23 @@ func TestDecodeListNested(t *testing.T) {
 	}
 }
 
+func TestDecodeListImproper(t *testing.T) {
+	// [alias|{1,2}]
+	expected := ListImproper{Atom("alias"), Tuple{1, 2}}
+	packet := []byte{ettList, 0, 0, 0, 1, ettSmallAtomUTF8, 5, 97, 108, 105, 97, 115,
+		ettSmallTuple, 2, ettSmallInteger, 1, ettSmallInteger, 2}
+
+	term, _, err := Decode(packet, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := term.(ListImproper)
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatal("result != expected")
+	}
+}
+
 func TestDecodeTuple(t *testing.T) {
 	expected := Tuple{3.14, Atom("abc"), int64(987654321)}
 	packet := []byte{ettSmallTuple, 3, 70, 64, 9, 30, 184, 81, 235, 133, 31, 100, 0, 3, 97, 98, 99,
@@ -228,6 +246,40 @@ func TestDecodeBinary(t *testing.T) {
 	}
 }
 
+func TestDecodeCompressed(t *testing.T) {
+	expected := bytes.Repeat([]byte{1}, 100)
+	packet := []byte{ettCompressed, 0, 0, 0, 105, // uncompressed size
+		120, 156, 203, 101, 96, 96, 72, 97, 164, 3, 0, 0, 104, 76, 1, 54, // zlib
+		ettNil, // the next term
+	}
+
+	term, rest, err := Decode(packet, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := term.([]byte)
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatal("result != expected")
+	}
+
+	if !reflect.DeepEqual(rest, []byte{ettNil}) {
+		t.Fatal("incorrect tail", rest)
+	}
+
+	// wrong uncompressed size
+	packet[4] = 106
+	if _, _, err := Decode(packet, []Atom{}); err == nil {
+		t.Fatal("should be error here")
+	}
+
+	// huge uncompressed size must not be allocated in advance
+	packet[1], packet[2], packet[3], packet[4] = 255, 255, 255, 255
+	if _, _, err := Decode(packet, []Atom{}); err == nil {
+		t.Fatal("should be error here")
+	}
+}
+
 func TestDecodeBitBinary(t *testing.T) {
 	expected := []byte{1, 2, 3, 4, 5}
 	packet := []byte{77, 0, 0, 0, 5, 3, 1, 2, 3, 4, 160}
@@ -477,6 +529,23 @@ func TestDecodeFunction(t *testing.T) {
 
 }
 
+func TestDecodeExport(t *testing.T) {
+	// fun global:random_exit_name/3
+	packet := []byte{ettExport, ettAtom, 0, 6, 'g', 'l', 'o', 'b', 'a', 'l',
+		ettAtom, 0, 16, 'r', 'a', 'n', 'd', 'o', 'm', '_', 'e', 'x', 'i', 't', '_', 'n', 'a', 'm', 'e',
+		ettSmallInteger, 3}
+	expected := Export{Module: "global", Function: "random_exit_name", Arity: 3}
+
+	term, _, err := Decode(packet, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(term, expected) {
+		t.Fatal("incorrect value", term)
+	}
+}
+
 //
 // benchmarks
 //
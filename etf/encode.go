@@ -1,6 +1,7 @@
 package etf
 
 import (
+	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"github.com/halturin/ergo/lib"
@@ -11,19 +12,108 @@ import (
 
 var (
 	ErrStringTooLong = fmt.Errorf("Encoding error. String too long")
+	ErrListImproper  = fmt.Errorf("Encoding error. Improper list must have a tail")
 
 	goSlice  = byte(240) // internal type
 	goMap    = byte(241) // internal type
 	goStruct = byte(242) // internal type
+
+	ettListImproper = byte(243) // internal type
 )
 
+// EncodeOptions defines the options for the encoding process
+type EncodeOptions struct {
+	LinkAtomCache     *AtomCache
+	WriterAtomCache   map[Atom]CacheItem
+	EncodingAtomCache *ListAtomCache
+
+	// FlagBigCreation enables encoding Pid and Ref with 32-bit creation
+	// (NEW_PID_EXT and NEWER_REFERENCE_EXT). Should be set if the peer
+	// has negotiated BIG_CREATION flag (OTP 23 and above)
+	FlagBigCreation bool
+
+	// CompressionThreshold enables compression of the encoded term if its size
+	// exceeds the given value (in bytes). Zero value disables compression.
+	CompressionThreshold int
+	// CompressionLevel is a zlib compression level. Zero value means
+	// zlib.DefaultCompression
+	CompressionLevel int
+}
+
+// Encode encodes term into the buffer b using the given atom cache
 func Encode(term Term, b *lib.Buffer,
 	linkAtomCache *AtomCache,
 	writerAtomCache map[Atom]CacheItem,
 	encodingAtomCache *ListAtomCache) error {
 
+	options := EncodeOptions{
+		LinkAtomCache:     linkAtomCache,
+		WriterAtomCache:   writerAtomCache,
+		EncodingAtomCache: encodingAtomCache,
+	}
+	return EncodeWithOptions(term, b, options)
+}
+
+// EncodeWithOptions encodes term into the buffer b using the given options
+func EncodeWithOptions(term Term, b *lib.Buffer, options EncodeOptions) error {
+	if options.CompressionThreshold < 1 {
+		return encode(term, b, options)
+	}
+
+	start := b.Len()
+	if err := encode(term, b, options); err != nil {
+		return err
+	}
+
+	if b.Len()-start < options.CompressionThreshold {
+		return nil
+	}
+
+	return compress(b, start, options.CompressionLevel)
+}
+
+// compress replaces the encoded term (starting at the given position
+// of the buffer) by its compressed form (ettCompressed)
+func compress(b *lib.Buffer, start int, level int) error {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+
+	zBuffer := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(zBuffer)
+
+	zw, err := zlib.NewWriterLevel(zBuffer, level)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(b.B[start:]); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	size := b.Len() - start
+	if zBuffer.Len()+5 >= size {
+		// there is no reason to send it compressed
+		return nil
+	}
+
+	b.B = b.B[:start]
+	buf := b.Extend(5)
+	buf[0] = ettCompressed
+	binary.BigEndian.PutUint32(buf[1:5], uint32(size))
+	b.Append(zBuffer.B)
+	return nil
+}
+
+func encode(term Term, b *lib.Buffer, options EncodeOptions) error {
 	var stack, child *stackElement
 
+	linkAtomCache := options.LinkAtomCache
+	writerAtomCache := options.WriterAtomCache
+	encodingAtomCache := options.EncodingAtomCache
+
 	cacheEnabled := linkAtomCache != nil
 
 	cacheIndex := uint16(0)
@@ -46,6 +136,11 @@ func Encode(term Term, b *lib.Buffer,
 		if stack != nil {
 
 			if stack.i == stack.children {
+				if stack.termType == ettNewFun {
+					// Size of the ettNewFun includes the Size field itself
+					start := stack.tmp.(int)
+					binary.BigEndian.PutUint32(b.B[start:start+4], uint32(b.Len()-start))
+				}
 				if stack.parent == nil {
 					return nil
 				}
@@ -62,6 +157,10 @@ func Encode(term Term, b *lib.Buffer,
 				}
 				term = stack.term.(List)[stack.i]
 
+			case ettListImproper:
+				// the last item is a tail
+				term = stack.term.(ListImproper)[stack.i]
+
 			case ettSmallTuple:
 				term = stack.term.(Tuple)[stack.i]
 
@@ -75,7 +174,22 @@ func Encode(term Term, b *lib.Buffer,
 				buf := b.Extend(9)
 				binary.BigEndian.PutUint32(buf[:4], p.ID)
 				binary.BigEndian.PutUint32(buf[4:8], p.Serial)
-				buf[8] = p.Creation
+				buf[8] = byte(p.Creation)
+
+				stack.i++
+				continue
+
+			case ettNewPid:
+				p := stack.term.(Pid)
+				if stack.i == 0 {
+					term = p.Node
+					break
+				}
+
+				buf := b.Extend(12)
+				binary.BigEndian.PutUint32(buf[:4], p.ID)
+				binary.BigEndian.PutUint32(buf[4:8], p.Serial)
+				binary.BigEndian.PutUint32(buf[8:12], p.Creation)
 
 				stack.i++
 				continue
@@ -89,7 +203,7 @@ func Encode(term Term, b *lib.Buffer,
 
 				lenID := len(r.ID)
 				buf := b.Extend(1 + lenID*4)
-				buf[0] = r.Creation
+				buf[0] = byte(r.Creation)
 				buf = buf[1:]
 				for i := 0; i < lenID; i++ {
 					binary.BigEndian.PutUint32(buf[:4], r.ID[i])
@@ -99,6 +213,57 @@ func Encode(term Term, b *lib.Buffer,
 				stack.i++
 				continue
 
+			case ettNewerRef:
+				r := stack.term.(Ref)
+				if stack.i == 0 {
+					term = r.Node
+					break
+				}
+
+				lenID := len(r.ID)
+				buf := b.Extend(4 + lenID*4)
+				binary.BigEndian.PutUint32(buf[:4], r.Creation)
+				buf = buf[4:]
+				for i := 0; i < lenID; i++ {
+					binary.BigEndian.PutUint32(buf[:4], r.ID[i])
+					buf = buf[4:]
+				}
+
+				stack.i++
+				continue
+
+			case ettExport:
+				e := stack.term.(Export)
+				switch stack.i {
+				case 0:
+					term = e.Module
+				case 1:
+					term = e.Function
+				case 2:
+					b.Append([]byte{ettSmallInteger, byte(e.Arity)})
+					stack.i++
+					continue
+				}
+
+			case ettNewFun:
+				f := stack.term.(Function)
+				switch stack.i {
+				case 0:
+					term = f.Module
+				case 1:
+					term = f.OldIndex
+				case 2:
+					buf := b.Extend(5)
+					buf[0] = ettInteger
+					binary.BigEndian.PutUint32(buf[1:5], f.OldUnique)
+					stack.i++
+					continue
+				case 3:
+					term = f.Pid
+				default:
+					term = f.FreeVars[stack.i-4]
+				}
+
 			case ettMap:
 				key := stack.tmp.(List)[stack.i/2]
 				if stack.i&0x01 == 0x01 { // a value
@@ -324,6 +489,11 @@ func Encode(term Term, b *lib.Buffer,
 				b.Append(buf)
 			}
 
+		case *big.Int:
+			// the decoder returns big numbers as a pointer
+			term = *t
+			goto recasting
+
 		case big.Int:
 			bytes := t.Bytes()
 			negative := t.Sign() < 0
@@ -436,26 +606,59 @@ func Encode(term Term, b *lib.Buffer,
 			}
 
 		case Pid:
-			b.AppendByte(ettPid)
+			termType := ettPid
+			if options.FlagBigCreation {
+				termType = ettNewPid
+			}
+			b.AppendByte(termType)
 			child = &stackElement{
 				parent:   stack,
-				termType: ettPid,
+				termType: termType,
 				term:     t,
 				children: 2,
 			}
 
 		case Ref:
+			termType := ettNewRef
+			if options.FlagBigCreation {
+				termType = ettNewerRef
+			}
 			buf := b.Extend(3)
-			buf[0] = ettNewRef
+			buf[0] = termType
 			binary.BigEndian.PutUint16(buf[1:3], uint16(len(t.ID)))
 
 			child = &stackElement{
 				parent:   stack,
-				termType: ettNewRef,
+				termType: termType,
 				term:     t,
 				children: 2,
 			}
 
+		case Export:
+			b.AppendByte(ettExport)
+			child = &stackElement{
+				parent:   stack,
+				termType: ettExport,
+				term:     t,
+				children: 3,
+			}
+
+		case Function:
+			// 1 (ettNewFun) + 4 (size) + 1 (arity) + 16 (unique) + 4 (index) + 4 (num free)
+			buf := b.Extend(1 + 4 + 1 + 16 + 4 + 4)
+			buf[0] = ettNewFun
+			buf[5] = t.Arity
+			copy(buf[6:22], t.Unique[:])
+			binary.BigEndian.PutUint32(buf[22:26], t.Index)
+			binary.BigEndian.PutUint32(buf[26:30], uint32(len(t.FreeVars)))
+			child = &stackElement{
+				parent:   stack,
+				termType: ettNewFun,
+				term:     t,
+				children: 4 + len(t.FreeVars),
+				tmp:      b.Len() - len(buf) + 1, // position of the size
+			}
+
 		case Map:
 			lenMap := len(t)
 			buf := b.Extend(5)
@@ -477,6 +680,11 @@ func Encode(term Term, b *lib.Buffer,
 
 		case List:
 			lenList := len(t)
+			if lenList == 0 {
+				// empty list must be encoded as ettNil
+				b.AppendByte(ettNil)
+				break
+			}
 			buf := b.Extend(5)
 			buf[0] = ettList
 			binary.BigEndian.PutUint32(buf[1:], uint32(lenList))
@@ -487,6 +695,21 @@ func Encode(term Term, b *lib.Buffer,
 				children: lenList + 1,
 			}
 
+		case ListImproper:
+			lenList := len(t)
+			if lenList < 2 {
+				return ErrListImproper
+			}
+			buf := b.Extend(5)
+			buf[0] = ettList
+			binary.BigEndian.PutUint32(buf[1:], uint32(lenList-1))
+			child = &stackElement{
+				parent:   stack,
+				termType: ettListImproper,
+				term:     t,
+				children: lenList,
+			}
+
 		case []byte:
 			lenBinary := len(t)
 			buf := b.Extend(1 + 4 + lenBinary)
@@ -1,7 +1,9 @@
 package etf
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/halturin/ergo/lib"
 	"math/big"
@@ -133,6 +135,7 @@ func integerCases() []integerCase {
 		integerCase{"int64::-9223372036854775808", int64(-9223372036854775808), []byte{ettSmallBig, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}},
 
 		integerCase{"big.int::-9223372036854775807123456789", bigIntNegative, []byte{ettSmallBig, 12, 1, 21, 3, 193, 203, 255, 255, 255, 255, 255, 100, 205, 29}},
+		integerCase{"*big.int::-9223372036854775807123456789", &bigIntNegative, []byte{ettSmallBig, 12, 1, 21, 3, 193, 203, 255, 255, 255, 255, 255, 100, 205, 29}},
 	}
 }
 
@@ -324,6 +327,44 @@ func TestEncodeBinary(t *testing.T) {
 	}
 }
 
+func TestEncodeCompressed(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	blob := bytes.Repeat([]byte{1, 2, 3}, 1000)
+	options := EncodeOptions{
+		CompressionThreshold: 1024,
+	}
+	if err := EncodeWithOptions(blob, b, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.B[0] != ettCompressed {
+		t.Fatal("should be compressed")
+	}
+	if b.Len() > 1024 {
+		t.Fatal("compressed size is too large", b.Len())
+	}
+
+	term, _, err := Decode(b.B, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(term, blob) {
+		t.Fatal("result != expected")
+	}
+
+	// below the threshold
+	b.Reset()
+	blob = bytes.Repeat([]byte{1, 2, 3}, 100)
+	if err := EncodeWithOptions(blob, b, options); err != nil {
+		t.Fatal(err)
+	}
+	if b.B[0] != ettBinary {
+		t.Fatal("shouldn't be compressed")
+	}
+}
+
 func TestEncodeList(t *testing.T) {
 	b := lib.TakeBuffer()
 	defer lib.ReleaseBuffer(b)
@@ -342,6 +383,47 @@ func TestEncodeList(t *testing.T) {
 	}
 }
 
+func TestEncodeListEmpty(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	expected := []byte{ettSmallTuple, 2, ettNil, ettNil}
+	term := Tuple{List{}, List(nil)}
+	err := Encode(term, b, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b.B, expected) {
+		fmt.Println("exp", expected)
+		fmt.Println("got", b.B)
+		t.Fatal("incorrect value")
+	}
+}
+
+func TestEncodeListImproper(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	expected := []byte{ettList, 0, 0, 0, 2, ettSmallAtomUTF8, 1, 97, ettSmallInteger, 2, ettSmallInteger, 3}
+	term := ListImproper{Atom("a"), 2, 3}
+	err := Encode(term, b, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b.B, expected) {
+		fmt.Println("exp", expected)
+		fmt.Println("got", b.B)
+		t.Fatal("incorrect value")
+	}
+
+	b.Reset()
+	if err := Encode(ListImproper{Atom("a")}, b, nil, nil, nil); err != ErrListImproper {
+		t.Fatal("expected error", ErrListImproper)
+	}
+}
+
 func TestEncodeSlice(t *testing.T) {
 	b := lib.TakeBuffer()
 	defer lib.ReleaseBuffer(b)
@@ -583,6 +665,68 @@ func TestEncodeRef(t *testing.T) {
 
 }
 
+func TestEncodePidBigCreation(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	expected := []byte{ettNewPid, 119, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49, 50,
+		55, 46, 48, 46, 48, 46, 49, 0, 0, 1, 56, 0, 0, 0, 0, 18, 52, 86, 120}
+	term := Pid{Node: "erl-demo@127.0.0.1", ID: 312, Serial: 0, Creation: 0x12345678}
+
+	err := EncodeWithOptions(term, b, EncodeOptions{FlagBigCreation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b.B, expected) {
+		fmt.Println("exp", expected)
+		fmt.Println("got", b.B)
+		t.Fatal("incorrect value")
+	}
+
+	decoded, _, err := Decode(b.B, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, term) {
+		t.Fatal("incorrect decoded value", decoded)
+	}
+}
+
+func TestEncodeRefBigCreation(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	expected := []byte{ettNewerRef, 0, 3, 119, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64,
+		49, 50, 55, 46, 48, 46, 48, 46, 49, 18, 52, 86, 120, 0, 1, 30, 228, 183, 192, 0, 1, 141,
+		122, 203, 35}
+
+	term := Ref{
+		Node:     Atom("erl-demo@127.0.0.1"),
+		Creation: 0x12345678,
+		ID:       []uint32{73444, 3082813441, 2373634851},
+	}
+
+	err := EncodeWithOptions(term, b, EncodeOptions{FlagBigCreation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b.B, expected) {
+		fmt.Println("exp", expected)
+		fmt.Println("got", b.B)
+		t.Fatal("incorrect value")
+	}
+
+	decoded, _, err := Decode(b.B, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, term) {
+		t.Fatal("incorrect decoded value", decoded)
+	}
+}
+
 func TestEncodeTupleRefPid(t *testing.T) {
 	b := lib.TakeBuffer()
 	defer lib.ReleaseBuffer(b)
@@ -616,6 +760,63 @@ func TestEncodeTupleRefPid(t *testing.T) {
 	}
 }
 
+func TestEncodeExport(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	expected := []byte{ettExport, ettSmallAtomUTF8, 6, 'g', 'l', 'o', 'b', 'a', 'l',
+		ettSmallAtomUTF8, 16, 'r', 'a', 'n', 'd', 'o', 'm', '_', 'e', 'x', 'i', 't', '_', 'n', 'a', 'm', 'e',
+		ettSmallInteger, 3}
+	term := Export{Module: "global", Function: "random_exit_name", Arity: 3}
+
+	err := Encode(term, b, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(b.B, expected) {
+		fmt.Println("exp", expected)
+		fmt.Println("got", b.B)
+		t.Fatal("incorrect value")
+	}
+}
+
+func TestEncodeFunction(t *testing.T) {
+	b := lib.TakeBuffer()
+	defer lib.ReleaseBuffer(b)
+
+	term := Function{
+		Arity:     3,
+		Unique:    [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Index:     2,
+		Module:    "erl_eval",
+		OldIndex:  6,
+		OldUnique: 123456789,
+		Pid:       Pid{Node: "erl-demo@127.0.0.1", ID: 312, Serial: 0, Creation: 2},
+		FreeVars:  []Term{Atom("a"), Tuple{1, 2}},
+	}
+
+	err := Encode(term, b, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := binary.BigEndian.Uint32(b.B[1:5]); int(size) != b.Len()-1 {
+		t.Fatal("incorrect size", size)
+	}
+
+	decoded, _, err := Decode(b.B, []Atom{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, term) {
+		fmt.Println("exp", term)
+		fmt.Println("got", decoded)
+		t.Fatal("incorrect value")
+	}
+}
+
 func TestEncodeGoPtrNil(t *testing.T) {
 	var x *int
 	b := lib.TakeBuffer()
@@ -10,6 +10,9 @@ import (
 type Term interface{}
 type Tuple []Term
 type List []Term
+
+// ListImproper is a list with the last element as a tail (e.g. [a, b | c])
+type ListImproper []Term
 type Atom string
 type Map map[Term]Term
 
@@ -17,18 +20,18 @@ type Pid struct {
 	Node     Atom
 	ID       uint32
 	Serial   uint32
-	Creation byte
+	Creation uint32
 }
 
 type Port struct {
 	Node     Atom
 	ID       uint32
-	Creation byte
+	Creation uint32
 }
 
 type Ref struct {
 	Node     Atom
-	Creation byte
+	Creation uint32
 	ID       []uint32
 }
 
@@ -117,6 +120,8 @@ const (
 	// ettRef        = byte(101) deprecated
 
 	ettFloat = byte(99) // legacy
+
+	ettCompressed = byte(80) // zlib compressed term (term_to_binary(T, [compressed]))
 )
 
 func (m Map) Element(k Term) Term {
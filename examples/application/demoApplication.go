@@ -165,7 +165,10 @@ func main() {
 	}
 
 	// Initialize new node with given name, cookie, listening port range and epmd port
-	node := ergo.CreateNode(NodeName, Cookie, opts)
+	node, err := ergo.CreateNode(NodeName, Cookie, opts)
+	if err != nil {
+		panic(err)
+	}
 
 	// start application
 	if err := node.ApplicationLoad(&demoApp{}); err != nil {
@@ -98,7 +98,10 @@ func main() {
 	}
 
 	// Initialize new node with given name, cookie, listening port range and epmd port
-	node := ergo.CreateNode(NodeName, Cookie, opts)
+	node, err := ergo.CreateNode(NodeName, Cookie, opts)
+	if err != nil {
+		panic(err)
+	}
 
 	// Initialize new instance of demoGenServ structure which implements Process behaviour
 	demoGS := new(demoGenServ)
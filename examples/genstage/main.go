@@ -12,8 +12,14 @@ import (
 func main() {
 	// create nodes for producer and consumers
 	fmt.Println("Starting nodes 'node_abc@localhost' and 'node_def@localhost'")
-	node_abc := ergo.CreateNode("node_abc@localhost", "cookies", ergo.NodeOptions{})
-	node_def := ergo.CreateNode("node_def@localhost", "cookies", ergo.NodeOptions{})
+	node_abc, err := ergo.CreateNode("node_abc@localhost", "cookies", ergo.NodeOptions{})
+	if err != nil {
+		panic(err)
+	}
+	node_def, err := ergo.CreateNode("node_def@localhost", "cookies", ergo.NodeOptions{})
+	if err != nil {
+		panic(err)
+	}
 
 	// create producer and consumer objects
 	producer := &Producer{}
@@ -100,12 +100,18 @@ func main() {
 	optsNode01 := ergo.NodeOptions{
 		EPMDPort: 7878,
 	}
-	node01 := ergo.CreateNode("demoNode7878@127.0.0.1", "cookie123", optsNode01)
+	node01, err := ergo.CreateNode("demoNode7878@127.0.0.1", "cookie123", optsNode01)
+	if err != nil {
+		panic(err)
+	}
 	fmt.Println("Started ergo node: demoNode7878@127.0.0.1 on port 7878")
 	optsNode02 := ergo.NodeOptions{
 		EPMDPort: 8787,
 	}
-	node02 := ergo.CreateNode("demoNode8787@127.0.0.1", "cookie456", optsNode02)
+	node02, err := ergo.CreateNode("demoNode8787@127.0.0.1", "cookie456", optsNode02)
+	if err != nil {
+		panic(err)
+	}
 	fmt.Println("Started ergo node: demoNode8787@127.0.0.1 on port 8787")
 
 	// Spawn process with one arguments
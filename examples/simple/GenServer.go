@@ -76,7 +76,10 @@ func (egs *ExampleGenServer) Terminate(reason string, state interface{}) {
 
 func main() {
 	// create a new node
-	node := ergo.CreateNode("node@localhost", "cookies", ergo.NodeOptions{})
+	node, err := ergo.CreateNode("node@localhost", "cookies", ergo.NodeOptions{})
+	if err != nil {
+		panic(err)
+	}
 	gs1 := &ExampleGenServer{}
 
 	// spawn new process of genserver
@@ -135,7 +135,10 @@ func main() {
 	}
 
 	// Initialize new node with given name, cookie, listening port range and epmd port
-	node := ergo.CreateNode(NodeName, Cookie, opts)
+	node, err := ergo.CreateNode(NodeName, Cookie, opts)
+	if err != nil {
+		panic(err)
+	}
 
 	// Spawn supervisor process
 	process, _ := node.Spawn("demo_sup", ergo.ProcessOptions{}, &demoSup{})
@@ -1,6 +1,7 @@
 package ergo
 
 import (
+	"runtime/debug"
 	"sync"
 
 	"github.com/halturin/ergo/etf"
@@ -41,9 +42,54 @@ func (gs *GenServer) Loop(p *Process, args ...interface{}) string {
 	p.state = object.(GenServerBehaviour).Init(p, args...)
 	p.ready <- nil
 
+	if p.strictOrder {
+		return gs.loopStrictOrder(p)
+	}
+
 	stop := make(chan string, 2)
+	crash := make(chan ProcessPanic, 1)
+	// terminated is guarded by lockState. The callbacks are not invoked
+	// once the process has been stopped or crashed, so the state is kept
+	// unchanged for Terminate handler
+	terminated := false
+
+	// We need to wrap it out using goroutine in order to serve
+	// sync-requests (like 'process.Call') within callback execution
+	// since reply (etf.Ref) comes through the same mailBox channel.
+	// The panic within the callback terminates the process.
+	handle := func(name string, callback func() (string, interface{})) {
+		go func() {
+			lockState.Lock()
+			defer lockState.Unlock()
+			if terminated {
+				return
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					terminated = true
+					crash <- ProcessPanic{
+						Value: r,
+						Stack: string(debug.Stack()),
+					}
+				}
+			}()
+
+			cf := p.getCurrentFunction()
+			p.setCurrentFunction(name)
+			code, state := callback()
+			p.setCurrentFunction(cf)
+
+			if code == "stop" {
+				terminated = true
+				stop <- state.(string)
+				return
+			}
+			p.setState(state)
+		}()
+	}
 
-	p.currentFunction = "GenServer:loop"
+	p.setCurrentFunction("GenServer:loop")
 
 	for {
 		var message etf.Term
@@ -51,13 +97,26 @@ func (gs *GenServer) Loop(p *Process, args ...interface{}) string {
 
 		select {
 		case ex := <-p.gracefulExit:
-			object.(GenServerBehaviour).Terminate(ex.reason, p.state)
-			return ex.reason
+			// the running callback must be completed before Terminate. the
+			// loop keeps forwarding the replies to it in the meantime
+			reason := ex.reason
+			handle("GenServer:Terminate", func() (string, interface{}) {
+				return "stop", reason
+			})
+			continue
 
 		case reason := <-stop:
+			// no callback is invoked once it's stopped
+			lockState.Lock()
+			p.setCurrentFunction("GenServer:Terminate")
 			object.(GenServerBehaviour).Terminate(reason, p.state)
+			lockState.Unlock()
 			return reason
 
+		case c := <-crash:
+			// is recovered by the Spawn and delivered to the links and monitors
+			panic(c)
+
 		case msg := <-p.mailBox:
 			fromPid = msg.Element(1).(etf.Pid)
 			message = msg.Element(2)
@@ -74,116 +133,177 @@ func (gs *GenServer) Loop(p *Process, args ...interface{}) string {
 
 		p.reductions++
 
+		handleInfo := func() (string, interface{}) {
+			return object.(GenServerBehaviour).HandleInfo(message, p.state)
+		}
+
 		switch m := message.(type) {
 		case etf.Tuple:
 			switch mtag := m.Element(1).(type) {
 			case etf.Atom:
 				switch mtag {
 				case etf.Atom("$gen_call"):
-					// We need to wrap it out using goroutine in order to serve
-					// sync-requests (like 'process.Call') within callback execution
-					// since reply (etf.Ref) comes through the same mailBox channel
-					go func() {
+					handle("GenServer:HandleCall", func() (string, interface{}) {
 						fromTuple := m.Element(2).(etf.Tuple)
-						lockState.Lock()
-
-						cf := p.currentFunction
-						p.currentFunction = "GenServer:HandleCall"
 						code, reply, state := object.(GenServerBehaviour).HandleCall(fromTuple, m.Element(3), p.state)
-						p.currentFunction = cf
-
 						if code == "stop" {
-							stop <- reply.(string)
-							// do not unlock, coz we have to keep this state unchanged for Terminate handler
-							return
+							return code, reply
 						}
-
-						p.state = state
-						lockState.Unlock()
-
 						if reply != nil && code == "reply" {
-							pid := fromTuple.Element(1).(etf.Pid)
-							ref := fromTuple.Element(2)
-							rep := etf.Term(etf.Tuple{ref, reply})
-							p.Send(pid, rep)
+							gs.sendReply(p, fromTuple, reply)
 						}
-					}()
+						return code, state
+					})
 
 				case etf.Atom("$gen_cast"):
-					go func() {
-						lockState.Lock()
+					handle("GenServer:HandleCast", func() (string, interface{}) {
+						return object.(GenServerBehaviour).HandleCast(m.Element(2), p.state)
+					})
 
-						cf := p.currentFunction
-						p.currentFunction = "GenServer:HandleCast"
-						code, state := object.(GenServerBehaviour).HandleCast(m.Element(2), p.state)
-						p.currentFunction = cf
+				default:
+					handle("GenServer:HandleInfo", handleInfo)
+				}
 
-						if code == "stop" {
-							stop <- state.(string)
-							return
-						}
-						p.state = state
-						lockState.Unlock()
-					}()
+			case etf.Ref, etf.ListImproper:
+				gs.forwardReply(p, m)
 
-				default:
-					go func() {
-						lockState.Lock()
+			default:
+				lib.Log("mtag: %#v", mtag)
+				handle("GenServer:HandleInfo", handleInfo)
+			}
 
-						cf := p.currentFunction
-						p.currentFunction = "GenServer:HandleInfo"
-						code, state := object.(GenServerBehaviour).HandleInfo(message, p.state)
-						p.currentFunction = cf
+		default:
+			lib.Log("m: %#v", m)
+			handle("GenServer:HandleInfo", handleInfo)
+		}
+	}
+}
 
-						if code == "stop" {
-							stop <- state.(string)
-							return
-						}
-						p.state = state
-						lockState.Unlock()
-					}()
+// loopStrictOrder handles the messages one by one in the mailbox order within
+// the process goroutine. The replies to the calls made by the callbacks are
+// delivered to the reply channel (see registrar.deliver), so Process.Call
+// works within the callback.
+func (gs *GenServer) loopStrictOrder(p *Process) string {
+	object := p.object.(GenServerBehaviour)
+	p.setCurrentFunction("GenServer:loop")
 
-				}
+	for {
+		var message etf.Term
+		var fromPid etf.Pid
 
-			case etf.Ref:
-				lib.Log("got reply: %#v\n%#v", mtag, message)
-				p.reply <- m
+		select {
+		case ex := <-p.gracefulExit:
+			object.Terminate(ex.reason, p.state)
+			return ex.reason
 
-			default:
-				lib.Log("mtag: %#v", mtag)
-				go func() {
-					lockState.Lock()
+		case msg := <-p.mailBox:
+			fromPid = msg.Element(1).(etf.Pid)
+			message = msg.Element(2)
 
-					cf := p.currentFunction
-					p.currentFunction = "GenServer:HandleInfo"
-					code, state := object.(GenServerBehaviour).HandleInfo(message, p.state)
-					p.currentFunction = cf
+		case <-p.Context.Done():
+			return "kill"
 
-					if code == "stop" {
-						stop <- state.(string)
-					}
-					p.state = state
-					lockState.Unlock()
-				}()
+		case direct := <-p.direct:
+			gs.handleDirect(direct)
+			continue
+		}
+
+		lib.Log("[%s]. %v got message from %#v\n", p.Node.FullName, p.self, fromPid)
+
+		p.reductions++
+
+		var code string
+		var state interface{}
+
+		m, _ := message.(etf.Tuple)
+		tag := etf.Term(nil)
+		if len(m) > 0 {
+			tag = m.Element(1)
+		}
+
+		switch tag.(type) {
+		case etf.Ref, etf.ListImproper:
+			gs.forwardReply(p, m)
+			continue
+		}
+
+		switch {
+		case tag == etf.Atom("$gen_call") && len(m) == 3:
+			var reply etf.Term
+			fromTuple := m.Element(2).(etf.Tuple)
+			p.setCurrentFunction("GenServer:HandleCall")
+			code, reply, state = object.HandleCall(fromTuple, m.Element(3), p.state)
+			if code == "stop" {
+				// keep the state unchanged for Terminate handler
+				object.Terminate(reply.(string), p.state)
+				return reply.(string)
 			}
+			if reply != nil && code == "reply" {
+				gs.sendReply(p, fromTuple, reply)
+			}
+
+		case tag == etf.Atom("$gen_cast") && len(m) == 2:
+			p.setCurrentFunction("GenServer:HandleCast")
+			code, state = object.HandleCast(m.Element(2), p.state)
 
 		default:
-			lib.Log("m: %#v", m)
-			go func() {
-				lockState.Lock()
+			p.setCurrentFunction("GenServer:HandleInfo")
+			code, state = object.HandleInfo(message, p.state)
+		}
+		p.setCurrentFunction("GenServer:loop")
 
-				cf := p.currentFunction
-				p.currentFunction = "GenServer:HandleInfo"
-				code, state := object.(GenServerBehaviour).HandleInfo(message, p.state)
-				p.currentFunction = cf
+		if code == "stop" {
+			object.Terminate(state.(string), p.state)
+			return state.(string)
+		}
+		p.setState(state)
+	}
+}
 
-				if code == "stop" {
-					stop <- state.(string)
-					return
-				}
-				p.state = state
-				lockState.Unlock()
-			}()
+// sendReply sends the reply of HandleCall to the caller
+func (gs *GenServer) sendReply(p *Process, from etf.Tuple, reply etf.Term) {
+	pid := from.Element(1).(etf.Pid)
+	ref := from.Element(2)
+	rep := etf.Term(etf.Tuple{ref, reply})
+	if alias, ok := ref.(etf.ListImproper); ok {
+		// OTP 24 (and ergo) expects the reply to be sent
+		// to the alias [alias|Ref]
+		if to, ok := replyRef(alias); ok {
+			p.Send(to, rep)
+			return
+		}
+	}
+	p.Send(pid, rep)
+}
+
+// forwardReply puts the reply to the call made by this process into
+// the reply channel
+func (gs *GenServer) forwardReply(p *Process, m etf.Tuple) {
+	lib.Log("got reply: %#v", m)
+	switch mtag := m.Element(1).(type) {
+	case etf.Ref:
+		select {
+		case p.reply <- m:
+		default:
+			lib.Log("[%s] reply %#v is dropped: reply queue is full", p.Node.FullName, mtag)
+		}
+
+	case etf.ListImproper:
+		// reply with alias tagged reference [alias|Ref]
+		alias, ok := replyRef(mtag)
+		if !ok {
+			lib.Log("[%s] unknown reply tag: %#v", p.Node.FullName, mtag)
+			return
+		}
+		if p.Node.registrar.GetProcessByAlias(alias) != p {
+			// the alias has been deactivated (timeout). drop this reply
+			lib.Log("[%s] late reply %#v is dropped", p.Node.FullName, alias)
+			return
+		}
+		select {
+		case p.reply <- m:
+		default:
+			lib.Log("[%s] reply %#v is dropped: reply queue is full", p.Node.FullName, alias)
 		}
 	}
 }
@@ -3,6 +3,7 @@ package ergo
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -59,8 +60,8 @@ func (tgs *testGenServer) Terminate(reason string, state interface{}) {
 func TestGenServer(t *testing.T) {
 	fmt.Printf("\n=== Test GenServer\n")
 	fmt.Printf("Starting nodes: nodeGS1@localhost, nodeGS2@localhost: ")
-	node1 := CreateNode("nodeGS1@localhost", "cookies", NodeOptions{})
-	node2 := CreateNode("nodeGS2@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeGS1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeGS2@localhost", "cookies", NodeOptions{})
 	if node1 == nil || node2 == nil {
 		t.Fatal("can't start nodes")
 	} else {
@@ -178,6 +179,139 @@ func TestGenServer(t *testing.T) {
 	node2.Stop()
 }
 
+type testAliasGenServer struct {
+	GenServer
+	from chan etf.Tuple
+}
+
+func (tgs *testAliasGenServer) Init(p *Process, args ...interface{}) (state interface{}) {
+	return nil
+}
+func (tgs *testAliasGenServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testAliasGenServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	if message == etf.Atom("noreply") {
+		tgs.from <- from
+		return "noreply", nil, state
+	}
+	return "reply", message, state
+}
+func (tgs *testAliasGenServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testAliasGenServer) Terminate(reason string, state interface{}) {
+}
+
+func TestGenServerAlias(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer alias\n")
+	fmt.Printf("Starting nodes: nodeGS1Alias@localhost, nodeGS2Alias@localhost: ")
+	node1, _ := CreateNode("nodeGS1Alias@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeGS2Alias@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	} else {
+		fmt.Println("OK")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs3 := &testAliasGenServer{
+		from: make(chan etf.Tuple, 2),
+	}
+
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	node2gs3, _ := node2.Spawn("gs3", ProcessOptions{}, gs3, nil)
+
+	fmt.Printf("    process.Send (by alias) local (gs1) -> local (gs1): ")
+	alias, err := node1gs1.CreateAlias()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node1gs1.Send(alias, etf.Atom("hi"))
+	waitForResultWithValue(t, gs1.v, etf.Atom("hi"))
+
+	fmt.Printf("    process.Send (by alias) remote (gs2) -> local (gs1): ")
+	node2gs2.Send(alias, etf.Atom("hi remote"))
+	waitForResultWithValue(t, gs1.v, etf.Atom("hi remote"))
+
+	fmt.Printf("    process.DeleteAlias by not an owner: ")
+	if err := node2gs2.DeleteAlias(alias); err != ErrAliasUnknown {
+		t.Fatal("expected", ErrAliasUnknown, "got", err)
+	}
+	node1gs2, _ := node1.Spawn("", ProcessOptions{}, &testAliasGenServer{}, nil)
+	if err := node1gs2.DeleteAlias(alias); err != ErrAliasOwner {
+		t.Fatal("expected", ErrAliasOwner, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process.DeleteAlias. messages must be dropped: ")
+	if err := node1gs1.DeleteAlias(alias); err != nil {
+		t.Fatal(err)
+	}
+	if err := node1gs1.DeleteAlias(alias); err != ErrAliasUnknown {
+		t.Fatal("expected", ErrAliasUnknown, "got", err)
+	}
+	node1gs1.Send(alias, etf.Atom("hi"))
+	node2gs2.Send(alias, etf.Atom("hi remote"))
+	select {
+	case v := <-gs1.v:
+		t.Fatal("got message sent to the deleted alias", v)
+	case <-time.After(200 * time.Millisecond):
+		fmt.Println("OK")
+	}
+
+	fmt.Printf("    process.Call local (gs1) -> remote (gs3) with alias tagged reply: ")
+	if v, err := node1gs1.Call(node2gs3.Self(), etf.Atom("hi call")); err != nil {
+		t.Fatal(err)
+	} else if v != etf.Atom("hi call") {
+		t.Fatal("expected 'hi call', got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process.Call with timeout. late reply must be dropped: ")
+	if _, err := node1gs1.CallWithTimeout(node2gs3.Self(), etf.Atom("noreply"), 1); err == nil {
+		t.Fatal("expected timeout")
+	}
+	from := <-gs3.from
+	if _, ok := from.Element(2).(etf.ListImproper); !ok {
+		t.Fatal("expected alias tagged reference, got", from.Element(2))
+	}
+	// reply using the pid as it does OTP 23 and older
+	node2gs3.Send(from.Element(1), etf.Tuple{from.Element(2), etf.Atom("late reply")})
+	select {
+	case v := <-gs1.v:
+		t.Fatal("late reply has landed in the mailbox", v)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if v, err := node1gs1.Call(node2gs3.Self(), etf.Atom("hi call")); err != nil {
+		t.Fatal(err)
+	} else if v != etf.Atom("hi call") {
+		t.Fatal("expected 'hi call', got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    replies tagged by reference are dropped if the reply queue is full: ")
+	for i := 0; i < 3; i++ {
+		node2gs2.Send(node1gs1.Self(), etf.Tuple{node2.MakeRef(), etf.Atom("stray reply")})
+	}
+	node2gs2.Send(node1gs1.Self(), etf.Atom("hi"))
+	waitForResultWithValue(t, gs1.v, etf.Atom("hi"))
+}
+
 func waitForResult(t *testing.T, w chan error) {
 	select {
 	case e := <-w:
@@ -270,3 +404,414 @@ func waitForTimeout(t *testing.T, w chan interface{}) {
 		return
 	}
 }
+
+type testStrictOrderGenServer struct {
+	GenServer
+	process  *Process
+	received chan etf.Term
+}
+
+func (tgs *testStrictOrderGenServer) Init(p *Process, args ...interface{}) (state interface{}) {
+	tgs.process = p
+	return 0
+}
+func (tgs *testStrictOrderGenServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.received <- message
+	return "noreply", state.(int) + 1
+}
+func (tgs *testStrictOrderGenServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	switch m := message.(type) {
+	case etf.Tuple:
+		// nested call
+		reply, err := tgs.process.Call(m.Element(2), m.Element(1))
+		if err != nil {
+			return "reply", etf.Atom(err.Error()), state
+		}
+		return "reply", reply, state
+	case etf.Atom:
+		if m == "count" {
+			return "reply", state, state
+		}
+	}
+	return "reply", message, state
+}
+func (tgs *testStrictOrderGenServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	tgs.received <- message
+	return "noreply", state.(int) + 1
+}
+func (tgs *testStrictOrderGenServer) Terminate(reason string, state interface{}) {
+}
+
+func TestGenServerStrictOrder(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer strict order\n")
+	fmt.Printf("Starting nodes: nodeGS1StrictOrder@localhost, nodeGS2StrictOrder@localhost: ")
+	node1, _ := CreateNode("nodeGS1StrictOrder@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeGS2StrictOrder@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	} else {
+		fmt.Println("OK")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+
+	n := 1000
+	gs1 := &testStrictOrderGenServer{
+		received: make(chan etf.Term, n),
+	}
+	gs2 := &testStrictOrderGenServer{
+		received: make(chan etf.Term, n),
+	}
+	gs3 := &testStrictOrderGenServer{
+		received: make(chan etf.Term, n),
+	}
+	opts := ProcessOptions{
+		MailboxSize: uint16(n),
+		StrictOrder: true,
+	}
+	node1gs1, _ := node1.Spawn("gs1", opts, gs1, nil)
+	node1gs2, _ := node1.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	node2gs3, _ := node2.Spawn("gs3", ProcessOptions{}, gs3, nil)
+
+	fmt.Printf("    messages are handled in the mailbox order: ")
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			node1gs2.Cast(node1gs1.Self(), i)
+			continue
+		}
+		node1gs2.Send(node1gs1.Self(), i)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case v := <-gs1.received:
+			if v != i {
+				t.Fatal("expected", i, "got", v)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("result timeout")
+		}
+	}
+	if v, err := node1gs2.Call(node1gs1.Self(), etf.Atom("count")); err != nil {
+		t.Fatal(err)
+	} else if v != n {
+		t.Fatal("expected", n, "got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process.Call within HandleCall (local): ")
+	nested := etf.Tuple{etf.Atom("hi local"), node1gs2.Self()}
+	if v, err := node1gs2.Call(node1gs1.Self(), nested); err != nil {
+		t.Fatal(err)
+	} else if v != etf.Atom("hi local") {
+		t.Fatal("expected 'hi local', got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process.Call within HandleCall (remote): ")
+	nested = etf.Tuple{etf.Atom("hi remote"), node2gs3.Self()}
+	if v, err := node1gs2.Call(node1gs1.Self(), nested); err != nil {
+		t.Fatal(err)
+	} else if v != etf.Atom("hi remote") {
+		t.Fatal("expected 'hi remote', got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    messages are handled in the order after the nested call: ")
+	for i := 0; i < 10; i++ {
+		node1gs2.Send(node1gs1.Self(), i)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case v := <-gs1.received:
+			if v != i {
+				t.Fatal("expected", i, "got", v)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("result timeout")
+		}
+	}
+	fmt.Println("OK")
+}
+
+type benchCastGS struct {
+	GenServer
+	n    int
+	done chan bool
+}
+
+func (b *benchCastGS) Init(p *Process, args ...interface{}) interface{} {
+	return 0
+}
+
+func (b *benchCastGS) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", etf.Atom("ok"), state
+}
+
+func (b *benchCastGS) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	count := state.(int) + 1
+	if count%benchCastBatch == 0 || count == b.n {
+		b.done <- true
+	}
+	return "noreply", count
+}
+
+func (b *benchCastGS) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+func (b *benchCastGS) Terminate(reason string, state interface{}) {
+
+}
+
+// the casts are sent by batches to avoid the mailbox overflow
+const benchCastBatch = 1000
+
+func BenchmarkGenServerDefault(b *testing.B) {
+	benchGenServer(b, "nodeBGSDefault", ProcessOptions{})
+}
+
+func BenchmarkGenServerStrictOrder(b *testing.B) {
+	benchGenServer(b, "nodeBGSStrictOrder", ProcessOptions{StrictOrder: true})
+}
+
+func benchGenServer(b *testing.B, name string, opts ProcessOptions) {
+	node1name := fmt.Sprintf("%s_%d@localhost", name, b.N)
+	node1, _ := CreateNode(node1name, "bench", NodeOptions{})
+	defer node1.Stop()
+
+	opts.MailboxSize = 10000
+
+	b.Run("call", func(b *testing.B) {
+		p1, _ := node1.Spawn("", ProcessOptions{}, &benchGS{})
+		p2, e := node1.Spawn("", opts, &benchGS{})
+		if e != nil {
+			b.Fatal(e)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, e := p1.Call(p2.Self(), i); e != nil {
+				b.Fatal(e, i)
+			}
+		}
+	})
+
+	b.Run("cast", func(b *testing.B) {
+		bgs := &benchCastGS{
+			n:    b.N,
+			done: make(chan bool, 1),
+		}
+		p1, _ := node1.Spawn("", ProcessOptions{}, &benchGS{})
+		p2, e := node1.Spawn("", opts, bgs)
+		if e != nil {
+			b.Fatal(e)
+		}
+		b.ResetTimer()
+		for i := 1; i <= b.N; i++ {
+			p1.Cast(p2.Self(), i)
+			if i%benchCastBatch == 0 || i == b.N {
+				<-bgs.done
+			}
+		}
+	})
+}
+
+type testPanicGenServer struct {
+	GenServer
+	v chan interface{}
+}
+
+func (tgs *testPanicGenServer) Init(p *Process, args ...interface{}) (state interface{}) {
+	if len(args) > 0 && args[0] == "init" {
+		panic("init boom")
+	}
+	tgs.v <- p.Self()
+	return nil
+}
+func (tgs *testPanicGenServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	if message == etf.Atom("panic") {
+		panic("cast boom")
+	}
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testPanicGenServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	if message == etf.Atom("panic") {
+		panic("call boom")
+	}
+	return "reply", message, state
+}
+func (tgs *testPanicGenServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	if message == etf.Atom("panic") {
+		var m map[string]int
+		m["info"] = 1 // nil map
+	}
+	tgs.v <- message
+	return "noreply", state
+}
+func (tgs *testPanicGenServer) Terminate(reason string, state interface{}) {
+}
+
+type testPanicSupervisor struct {
+	Supervisor
+}
+
+func (ts *testPanicSupervisor) Init(args ...interface{}) SupervisorSpec {
+	return SupervisorSpec{
+		Children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:    "testPanicGS",
+				Child:   args[0].(*testPanicGenServer),
+				Restart: SupervisorChildRestartTransient,
+			},
+		},
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategyOneForOne,
+			Intensity: 10,
+			Period:    5,
+		},
+	}
+}
+
+func TestGenServerPanic(t *testing.T) {
+	fmt.Printf("\n=== Test GenServer panic\n")
+	fmt.Printf("Starting nodes: nodeGS1Panic@localhost, nodeGS2Panic@localhost: ")
+	node1, _ := CreateNode("nodeGS1Panic@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeGS2Panic@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	} else {
+		fmt.Println("OK")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs3 := &testPanicGenServer{
+		v: make(chan interface{}, 2),
+	}
+
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+	node1gs1.SetTrapExit(true)
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	checkReason := func(reason etf.Term, value string) {
+		r, ok := reason.(etf.Tuple)
+		if !ok || len(r) != 3 || r[0] != etf.Atom("panic") || r[1] != value {
+			t.Fatalf("expected {panic, Value, Stack}, got %#v", reason)
+		}
+		if stack, _ := r[2].(string); !strings.Contains(stack, "gen_server_test.go") {
+			t.Fatal("stack trace doesn't point to the callback:", r[2])
+		}
+	}
+
+	cases := []struct {
+		name     string
+		opts     ProcessOptions
+		send     func(p *Process, to etf.Pid)
+		expected string
+	}{
+		{"HandleCast", ProcessOptions{}, func(p *Process, to etf.Pid) { p.Cast(to, etf.Atom("panic")) }, "cast boom"},
+		{"HandleCall", ProcessOptions{}, func(p *Process, to etf.Pid) { p.CallWithTimeout(to, etf.Atom("panic"), 1) }, "call boom"},
+		{"HandleInfo", ProcessOptions{}, func(p *Process, to etf.Pid) { p.Send(to, etf.Atom("panic")) }, "assignment to entry in nil map"},
+		{"HandleCast (strict order)", ProcessOptions{StrictOrder: true}, func(p *Process, to etf.Pid) { p.Cast(to, etf.Atom("panic")) }, "cast boom"},
+		{"HandleCall (strict order)", ProcessOptions{StrictOrder: true}, func(p *Process, to etf.Pid) { p.CallWithTimeout(to, etf.Atom("panic"), 1) }, "call boom"},
+	}
+
+	for _, c := range cases {
+		fmt.Printf("    panic in %s terminates the process only: ", c.name)
+		node1gs3, err := node1.Spawn("", c.opts, gs3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gs3pid := node1gs3.Self()
+		<-gs3.v
+		node1gs1.Link(gs3pid)
+		ref := node1gs1.MonitorProcess(gs3pid)
+		ref2 := node2gs2.MonitorProcess(gs3pid)
+		// make sure the remote monitor has been created
+		if _, err := node2gs2.Call(gs3pid, etf.Atom("ping")); err != nil {
+			t.Fatal(err)
+		}
+
+		c.send(node1gs1, gs3pid)
+
+		// local link (trap exit) and local monitor. the messages are
+		// handled concurrently, so they could come in any order
+		for i := 0; i < 2; i++ {
+			select {
+			case v := <-gs1.v:
+				m, ok := v.(etf.Tuple)
+				switch {
+				case ok && len(m) == 3 && m[0] == etf.Atom("EXIT") && m[1] == gs3pid:
+					checkReason(m[2], c.expected)
+				case ok && len(m) == 5 && m[0] == etf.Atom("DOWN") && reflect.DeepEqual(m[1], ref):
+					checkReason(m[4], c.expected)
+				default:
+					t.Fatal("expected 'EXIT' or 'DOWN' message, got", v)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("result timeout")
+			}
+		}
+		// remote monitor
+		select {
+		case v := <-gs2.v:
+			m, ok := v.(etf.Tuple)
+			if !ok || len(m) != 5 || m[0] != etf.Atom("DOWN") || !reflect.DeepEqual(m[1], ref2) {
+				t.Fatal("expected 'DOWN' message, got", v)
+			}
+			checkReason(m[4], c.expected)
+		case <-time.After(2 * time.Second):
+			t.Fatal("result timeout")
+		}
+		if node1.IsProcessAlive(gs3pid) {
+			t.Fatal("process is still alive")
+		}
+		if !node1gs1.IsAlive() || !node2gs2.IsAlive() {
+			t.Fatal("another process has been terminated")
+		}
+		if err := node1gs3.WaitWithTimeout(time.Second); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("OK")
+	}
+
+	fmt.Printf("    panic in Init returns error: ")
+	if _, err := node1.Spawn("", ProcessOptions{}, gs3, "init"); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    supervisor restarts the child terminated by panic: ")
+	gs4 := &testPanicGenServer{
+		v: make(chan interface{}, 2),
+	}
+	sv, err := node1.Spawn("testPanicSupervisor", ProcessOptions{}, &testPanicSupervisor{}, gs4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := (<-gs4.v).(etf.Pid)
+	node1gs1.Cast(pid, etf.Atom("panic"))
+	select {
+	case v := <-gs4.v:
+		if v == pid {
+			t.Fatal("child hasn't been restarted")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("result timeout")
+	}
+	if !sv.IsAlive() {
+		t.Fatal("supervisor has been terminated")
+	}
+	fmt.Println("OK")
+}
@@ -1,33 +1,188 @@
 package ergo
 
-// TODO: https://github.com/erlang/otp/blob/master/lib/kernel/src/global.erl
+// https://github.com/erlang/otp/blob/master/lib/kernel/src/global.erl
 
 import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+
 	"github.com/halturin/ergo/etf"
 	"github.com/halturin/ergo/lib"
 )
 
+const (
+	globalNameServerName  = "global_name_server"
+	globalProtocolVersion = 6
+	// ?GLOBAL_RID. this resource is used to lock the name table
+	// across the cluster (registration, synchronization)
+	globalResourceID = etf.Atom("global")
+
+	globalLockRetries = 10
+)
+
+var (
+	// Method which is used to resolve the name conflict of the names
+	// registered by this node (fun global:random_notify_name/3)
+	globalNameMethod = etf.Export{Module: "global", Function: "random_notify_name", Arity: 3}
+)
+
 type globalNameServer struct {
 	GenServer
 	process *Process
 }
 
-type state struct {
+type globalLock struct {
+	requester etf.Term
+	pids      []etf.Pid
+}
+
+type globalState struct {
+	locker etf.Pid
+	// synchronized nodes
+	known map[string]bool
+	// tags of the synchronization sessions
+	tagMy  map[string]etf.Term
+	tagHis map[string]etf.Term
+	// init_connect message received before nodeup
+	preConnect map[string]etf.Tuple
+	// exchange message received before init_connect
+	preExchange map[string]etf.Tuple
+	// nodes whose exchange message is handled
+	exchanged map[string]bool
+	// resolved message received before the exchange one
+	preResolved map[string]etf.Tuple
+	// operations on the name table to be done once the synchronization
+	// with the node is finished (save_ops in global.erl)
+	ops map[string]etf.List
+	// methods of the registered names
+	methods map[string]etf.Term
+	// set locks (by resource id)
+	locks map[string]*globalLock
+	// monitors of the processes registered names or set locks
+	monitors map[etf.Pid]etf.Ref
 }
 
 // Init initializes process state using arbitrary arguments
 // Init -> state
-func (ns *globalNameServer) Init(p *Process, args ...interface{}) interface{} {
+func (gns *globalNameServer) Init(p *Process, args ...interface{}) interface{} {
 	lib.Log("GLOBAL_NAME_SERVER: Init: %#v", args)
-	ns.process = p
+	gns.process = p
+
+	state := &globalState{
+		known:       make(map[string]bool),
+		tagMy:       make(map[string]etf.Term),
+		tagHis:      make(map[string]etf.Term),
+		preConnect:  make(map[string]etf.Tuple),
+		preExchange: make(map[string]etf.Tuple),
+		exchanged:   make(map[string]bool),
+		preResolved: make(map[string]etf.Tuple),
+		ops:         make(map[string]etf.List),
+		methods:     make(map[string]etf.Term),
+		locks:       make(map[string]*globalLock),
+		monitors:    make(map[etf.Pid]etf.Ref),
+	}
+
+	opts := ProcessOptions{
+		parent: p,
+	}
+	locker, err := p.Node.Spawn("", opts, &globalLocker{})
+	if err != nil {
+		panic(err)
+	}
+	state.locker = locker.Self()
+	p.Link(state.locker)
+	p.Node.MonitorNodes(p.Self(), MonitorNodesOptions{})
 
-	return state{}
+	return state
 }
 
 // HandleCast -> ("noreply", state) - noreply
 //		         ("stop", reason) - stop with reason
-func (ns *globalNameServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+func (gns *globalNameServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
 	lib.Log("GLOBAL_NAME_SERVER: HandleCast: %#v", message)
+	st := state.(*globalState)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) == 0 {
+		return "noreply", state
+	}
+
+	switch m.Element(1) {
+	case etf.Atom("init_connect"):
+		// {init_connect, {Vsn, HisTag}, Node, {locker, _, HisKnown, HisTheLocker}}
+		node := atomToString(m.Element(3))
+		if _, ok := st.tagMy[node]; !ok {
+			// nodeup hasn't been received yet
+			st.preConnect[node] = m
+			return "noreply", state
+		}
+		gns.initConnect(st, node, m)
+
+	case etf.Atom("lock_is_set"):
+		// {lock_is_set, Node, MyTag, LockId}. sent by the locker
+		node := atomToString(m.Element(2))
+		if !sameTag(st.tagMy[node], m.Element(3)) {
+			return "noreply", state
+		}
+		names := etf.List{}
+		for name, pid := range gns.process.Node.registrar.GlobalNames() {
+			names = append(names, etf.Tuple{etf.Atom(name), pid, gns.method(st, name)})
+		}
+		exchange := etf.Tuple{etf.Atom("exchange"), etf.Atom(gns.process.Node.FullName),
+			names, etf.List{}, st.tagMy[node]}
+		gns.process.Cast(globalServerAt(gns.process, node), exchange)
+
+	case etf.Atom("exchange"):
+		// {exchange, Node, NameList, NameExtList, HisTag}
+		node := atomToString(m.Element(2))
+		if _, ok := st.tagHis[node]; !ok {
+			// init_connect hasn't been received yet
+			st.preExchange[node] = m
+			return "noreply", state
+		}
+		gns.exchange(st, node, m)
+
+	case etf.Atom("resolved"):
+		// {resolved, Node, HisResolved, HisKnown, HisKnown_v2, Names_ext, MyTag}
+		node := atomToString(m.Element(2))
+		if !sameTag(st.tagMy[node], m.Element(7)) {
+			return "noreply", state
+		}
+		if !st.exchanged[node] {
+			// his exchange hasn't been handled yet
+			st.preResolved[node] = m
+			return "noreply", state
+		}
+		gns.resolved(st, node, m)
+
+	case etf.Atom("new_nodes"):
+		// {new_nodes, Node, Ops, Names_ext, Nodes, ExtraInfo}
+		ops, _ := m.Element(3).(etf.List)
+		gns.applyOps(st, ops)
+		nodes, _ := m.Element(5).(etf.List)
+		gns.connectNodes(nodes)
+
+	case etf.Atom("in_sync"):
+		// {in_sync, Node, IsKnown}
+		lib.Log("GLOBAL_NAME_SERVER: in sync with %v", m.Element(2))
+
+	case etf.Atom("async_del_name"):
+		// {async_del_name, Name, Pid}
+		name := atomToString(m.Element(2))
+		if pid, ok := gns.process.Node.registrar.GetGlobalName(name); ok && pid == m.Element(3) {
+			gns.deleteName(st, name)
+		}
+
+	case etf.Atom("async_del_lock"):
+		// {async_del_lock, ResourceId, Pid}
+		if pid, ok := m.Element(3).(etf.Pid); ok {
+			gns.deleteLock(st, m.Element(2), nil, pid)
+		}
+	}
+
 	return "noreply", state
 }
 
@@ -35,21 +190,792 @@ func (ns *globalNameServer) HandleCast(message etf.Term, state interface{}) (str
 // HandleCall -> ("reply", message, state) - reply
 //				 ("noreply", _, state) - noreply
 //		         ("stop", reason, _) - normal stop
-func (ns *globalNameServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+func (gns *globalNameServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
 	lib.Log("GLOBAL_NAME_SERVER: HandleCall: %#v, From: %#v", message, from)
-	reply := etf.Term(etf.Atom("reply"))
-	return "reply", reply, state
+	st := state.(*globalState)
+	fromPid, _ := from.Element(1).(etf.Pid)
+
+	switch m := message.(type) {
+	case etf.Atom:
+		switch m {
+		case etf.Atom("get_known"):
+			known := etf.List{}
+			for node := range st.known {
+				known = append(known, etf.Atom(node))
+			}
+			return "reply", known, state
+
+		case etf.Atom("get_protocol_version"):
+			return "reply", globalProtocolVersion, state
+		}
+
+	case etf.Tuple:
+		switch m.Element(1) {
+		case etf.Atom("set_lock"):
+			// {set_lock, {ResourceId, LockRequesterId}}
+			lock, ok := m.Element(2).(etf.Tuple)
+			if !ok || len(lock) != 2 {
+				return "reply", false, state
+			}
+			return "reply", gns.setLock(st, lock.Element(1), lock.Element(2), fromPid), state
+
+		case etf.Atom("del_lock"):
+			// {del_lock, {ResourceId, LockRequesterId}}
+			if lock, ok := m.Element(2).(etf.Tuple); ok && len(lock) == 2 {
+				gns.deleteLock(st, lock.Element(1), lock.Element(2), fromPid)
+			}
+			return "reply", true, state
+
+		case etf.Atom("register"):
+			// {register, Name, Pid, Method}
+			name := atomToString(m.Element(2))
+			pid, ok := m.Element(3).(etf.Pid)
+			if name == "" || !ok {
+				return "reply", etf.Atom("no"), state
+			}
+			gns.insertName(st, name, pid, m.Element(4))
+			return "reply", etf.Atom("yes"), state
+
+		case etf.Atom("unregister"):
+			// {unregister, Name}
+			gns.deleteName(st, atomToString(m.Element(2)))
+			return "reply", etf.Atom("ok"), state
+
+		case etf.Atom("whereis"):
+			// {whereis, Name}
+			if pid, ok := gns.process.Node.registrar.GetGlobalName(atomToString(m.Element(2))); ok {
+				return "reply", pid, state
+			}
+			return "reply", etf.Atom("undefined"), state
+		}
+	}
+
+	return "reply", etf.Atom("unknown"), state
 }
 
 // HandleInfo serves all another incoming messages (Pid ! message)
 // HandleInfo -> ("noreply", state) - noreply
 //		         ("stop", reason) - normal stop
-func (ns *globalNameServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+func (gns *globalNameServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
 	lib.Log("GLOBAL_NAME_SERVER: HandleInfo: %#v", message)
+	st := state.(*globalState)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) == 0 {
+		return "noreply", state
+	}
+
+	switch m.Element(1) {
+	case etf.Atom("nodeup"):
+		// {nodeup, Node, InfoList}
+		node := atomToString(m.Element(2))
+		if st.known[node] {
+			return "noreply", state
+		}
+		if _, ok := st.tagMy[node]; ok {
+			// synchronization is in progress
+			return "noreply", state
+		}
+		// the tag is used to separate the different synchronization
+		// sessions from each other
+		tag := atomic.AddInt64(&gns.process.Node.uniqID, 1)
+		st.tagMy[node] = tag
+
+		initConnect := etf.Tuple{
+			etf.Atom("init_connect"),
+			etf.Tuple{globalProtocolVersion, tag},
+			etf.Atom(gns.process.Node.FullName),
+			etf.Tuple{etf.Atom("locker"), etf.Atom("no_longer_a_pid"), gns.knownList(st), st.locker},
+		}
+		gns.process.Cast(globalServerAt(gns.process, node), initConnect)
+
+		if pre, ok := st.preConnect[node]; ok {
+			delete(st.preConnect, node)
+			gns.initConnect(st, node, pre)
+		}
+
+	case etf.Atom("nodedown"):
+		// {nodedown, Node, InfoList}
+		node := atomToString(m.Element(2))
+		delete(st.known, node)
+		delete(st.tagMy, node)
+		delete(st.tagHis, node)
+		delete(st.preConnect, node)
+		delete(st.preExchange, node)
+		delete(st.exchanged, node)
+		delete(st.preResolved, node)
+		delete(st.ops, node)
+		gns.process.Send(st.locker, etf.Tuple{etf.Atom("cancel"), etf.Atom(node)})
+
+		// release locks set by the processes on this node. names will be
+		// removed on receiving 'DOWN' messages with 'noconnection' reason
+		for _, lock := range st.locks {
+			for _, pid := range lock.pids {
+				if string(pid.Node) == node {
+					gns.deleteLock(st, nil, nil, pid)
+				}
+			}
+		}
+
+	case etf.Atom("DOWN"):
+		// {'DOWN', Ref, process, Pid, Reason}
+		pid, ok := m.Element(4).(etf.Pid)
+		if !ok {
+			return "noreply", state
+		}
+		delete(st.monitors, pid)
+		for name, p := range gns.process.Node.registrar.GlobalNames() {
+			if p == pid {
+				gns.process.Node.registrar.UnregisterGlobalName(name)
+				delete(st.methods, name)
+			}
+		}
+		gns.deleteLock(st, nil, nil, pid)
+	}
+
 	return "noreply", state
 }
 
 // Terminate called when process died
-func (ns *globalNameServer) Terminate(reason string, state interface{}) {
+func (gns *globalNameServer) Terminate(reason string, state interface{}) {
 	lib.Log("GLOBAL_NAME_SERVER: Terminate: %#v", reason)
 }
+
+func (gns *globalNameServer) initConnect(st *globalState, node string, m etf.Tuple) {
+	vsn, ok := m.Element(2).(etf.Tuple)
+	if !ok || len(vsn) != 2 {
+		return
+	}
+	locker, ok := m.Element(4).(etf.Tuple)
+	if !ok || len(locker) != 4 {
+		return
+	}
+	hisLocker, ok := locker.Element(4).(etf.Pid)
+	if !ok {
+		return
+	}
+	st.tagHis[node] = vsn.Element(2)
+
+	message := etf.Tuple{etf.Atom("his_the_locker"), hisLocker, etf.Atom(node), st.tagMy[node]}
+	gns.process.Send(st.locker, message)
+
+	if pre, ok := st.preExchange[node]; ok {
+		delete(st.preExchange, node)
+		gns.exchange(st, node, pre)
+	}
+}
+
+func (gns *globalNameServer) exchange(st *globalState, node string, m etf.Tuple) {
+	if !sameTag(st.tagHis[node], m.Element(5)) {
+		return
+	}
+	// ops are applied on this side, resolved ones - on the other side
+	ops := etf.List{}
+	resolved := etf.List{}
+	names, _ := m.Element(3).(etf.List)
+	for i := range names {
+		item, ok := names[i].(etf.Tuple)
+		if !ok || len(item) != 3 {
+			continue
+		}
+		name := atomToString(item.Element(1))
+		pid, ok := item.Element(2).(etf.Pid)
+		if name == "" || !ok {
+			continue
+		}
+		insert := etf.Tuple{etf.Atom("insert"), etf.Tuple{etf.Atom(name), pid, item.Element(3)}}
+		current, ok := gns.process.Node.registrar.GetGlobalName(name)
+		if !ok {
+			ops = append(ops, insert)
+			continue
+		}
+		if current == pid || gns.process.Node.FullName > node {
+			// the same process or the other side resolves the conflict
+			continue
+		}
+
+		method := gns.method(st, name)
+		switch gns.resolveIt(method, name, pid, current) {
+		case pid:
+			ops = append(ops, insert)
+		case current:
+			keep := etf.Tuple{etf.Atom("insert"), etf.Tuple{etf.Atom(name), current, method}}
+			resolved = append(resolved, keep)
+		default:
+			del := etf.Tuple{etf.Atom("delete"), etf.Atom(name)}
+			ops = append(ops, del)
+			resolved = append(resolved, del)
+		}
+	}
+
+	message := etf.Tuple{
+		etf.Atom("resolved"),
+		etf.Atom(gns.process.Node.FullName),
+		resolved,
+		gns.knownList(st),
+		gns.knownList(st),
+		etf.List{},
+		st.tagHis[node],
+	}
+	gns.process.Cast(globalServerAt(gns.process, node), message)
+
+	st.ops[node] = ops
+	st.exchanged[node] = true
+	if pre, ok := st.preResolved[node]; ok {
+		gns.resolved(st, node, pre)
+	}
+}
+
+func (gns *globalNameServer) resolved(st *globalState, node string, m etf.Tuple) {
+	hisResolved, _ := m.Element(3).(etf.List)
+	hisKnown, _ := m.Element(4).(etf.List)
+	ops := append(st.ops[node], hisResolved...)
+	gns.applyOps(st, ops)
+
+	// let the other known nodes to know about the new nodes and the names
+	newNodes := etf.Tuple{
+		etf.Atom("new_nodes"),
+		etf.Atom(gns.process.Node.FullName),
+		ops,
+		etf.List{},
+		append(etf.List{etf.Atom(node)}, hisKnown...),
+		etf.List{},
+	}
+	for known := range st.known {
+		gns.process.Cast(globalServerAt(gns.process, known), newNodes)
+	}
+
+	st.known[node] = true
+	delete(st.tagMy, node)
+	delete(st.tagHis, node)
+	delete(st.exchanged, node)
+	delete(st.preResolved, node)
+	delete(st.ops, node)
+
+	gns.process.Send(st.locker, etf.Tuple{etf.Atom("cancel"), etf.Atom(node)})
+
+	inSync := etf.Tuple{etf.Atom("in_sync"), etf.Atom(gns.process.Node.FullName), true}
+	gns.process.Cast(globalServerAt(gns.process, node), inSync)
+
+	// connect to the nodes known by the other side
+	gns.connectNodes(hisKnown)
+}
+
+// applyOps makes the operations on the name table. Deletions go first
+func (gns *globalNameServer) applyOps(st *globalState, ops etf.List) {
+	inserts := etf.List{}
+	for i := range ops {
+		op, ok := ops[i].(etf.Tuple)
+		if !ok || len(op) != 2 {
+			continue
+		}
+		switch op.Element(1) {
+		case etf.Atom("insert"):
+			inserts = append(inserts, op.Element(2))
+
+		case etf.Atom("delete"):
+			// {delete, Name}
+			gns.deleteName(st, atomToString(op.Element(2)))
+		}
+	}
+
+	for i := range inserts {
+		// {insert, {Name, Pid, Method}}
+		item, ok := inserts[i].(etf.Tuple)
+		if !ok || len(item) != 3 {
+			continue
+		}
+		name := atomToString(item.Element(1))
+		pid, ok := item.Element(2).(etf.Pid)
+		if name == "" || !ok {
+			continue
+		}
+		gns.insertName(st, name, pid, item.Element(3))
+	}
+}
+
+func (gns *globalNameServer) connectNodes(nodes etf.List) {
+	peers := make(map[string]bool)
+	for _, peer := range gns.process.Node.GetPeerList() {
+		peers[peer] = true
+	}
+	for i := range nodes {
+		node := atomToString(nodes[i])
+		if node == "" || node == gns.process.Node.FullName || peers[node] {
+			continue
+		}
+		go gns.process.Node.connect(etf.Atom(node))
+	}
+}
+
+// resolveIt resolves the name conflict using the method the name (registered
+// by pid2) has been registered with. Returns the pid keeping the name or
+// 'none' if the name must be removed
+func (gns *globalNameServer) resolveIt(method etf.Term, name string, pid1, pid2 etf.Pid) etf.Term {
+	var function etf.Atom
+	switch m := method.(type) {
+	case etf.Export:
+		if m.Module == etf.Atom("global") && m.Arity == 3 {
+			function = m.Function
+		}
+	case etf.Tuple:
+		// {Module, Function}
+		if len(m) == 2 && m.Element(1) == etf.Atom("global") {
+			function, _ = m.Element(2).(etf.Atom)
+		}
+	}
+
+	min, max := globalMinMax(pid1, pid2)
+	switch function {
+	case etf.Atom("random_notify_name"):
+		gns.process.Send(max, etf.Tuple{etf.Atom("global_name_conflict"), etf.Atom(name)})
+		return min
+
+	case etf.Atom("random_exit_name"):
+		lib.Log("GLOBAL_NAME_SERVER: name conflict %q. terminating %v", name, max)
+		gns.kill(max)
+		return min
+
+	case etf.Atom("notify_all_name"):
+		gns.process.Send(pid1, etf.Tuple{etf.Atom("global_name_conflict"), etf.Atom(name), pid2})
+		gns.process.Send(pid2, etf.Tuple{etf.Atom("global_name_conflict"), etf.Atom(name), pid1})
+		return etf.Atom("none")
+	}
+
+	// custom method can be called by the node it was registered on only
+	if string(pid2.Node) == gns.process.Node.FullName {
+		lib.Log("GLOBAL_NAME_SERVER: unsupported method %#v", method)
+		return etf.Atom("none")
+	}
+	reply, err := gns.process.CallRPC(string(pid2.Node), "global", "resolve_it", method, etf.Atom(name), pid1, pid2)
+	if err != nil {
+		return etf.Atom("none")
+	}
+	return reply
+}
+
+// kill sends the exit signal 'kill' to the given process
+func (gns *globalNameServer) kill(pid etf.Pid) {
+	if string(pid.Node) == gns.process.Node.FullName {
+		gns.process.Node.monitor.ExitSignal(pid, gns.process.Self(), "kill")
+		return
+	}
+	message := etf.Tuple{distProtoEXIT2, gns.process.Self(), pid, etf.Atom("kill")}
+	gns.process.Node.registrar.routeRaw(pid.Node, message)
+}
+
+func (gns *globalNameServer) insertName(st *globalState, name string, pid etf.Pid, method etf.Term) {
+	if current, ok := gns.process.Node.registrar.GetGlobalName(name); ok && current != pid {
+		gns.process.Node.registrar.UnregisterGlobalName(name)
+		gns.checkMonitor(st, current)
+	}
+	gns.process.Node.registrar.RegisterGlobalName(name, pid)
+	st.methods[name] = method
+	if _, ok := st.monitors[pid]; !ok {
+		st.monitors[pid] = gns.process.MonitorProcess(pid)
+	}
+}
+
+func (gns *globalNameServer) deleteName(st *globalState, name string) {
+	pid, ok := gns.process.Node.registrar.GetGlobalName(name)
+	if !ok {
+		return
+	}
+	gns.process.Node.registrar.UnregisterGlobalName(name)
+	delete(st.methods, name)
+	gns.checkMonitor(st, pid)
+}
+
+// method returns the method the name has been registered with
+func (gns *globalNameServer) method(st *globalState, name string) etf.Term {
+	if method, ok := st.methods[name]; ok && method != nil {
+		return method
+	}
+	return globalNameMethod
+}
+
+func (gns *globalNameServer) setLock(st *globalState, id etf.Term, requester etf.Term, pid etf.Pid) bool {
+	key := fmt.Sprintf("%#v", id)
+	lock, ok := st.locks[key]
+	if !ok {
+		st.locks[key] = &globalLock{
+			requester: requester,
+			pids:      []etf.Pid{pid},
+		}
+	} else {
+		if !reflect.DeepEqual(lock.requester, requester) {
+			return false
+		}
+		for i := range lock.pids {
+			if lock.pids[i] == pid {
+				return true
+			}
+		}
+		lock.pids = append(lock.pids, pid)
+	}
+
+	if _, ok := st.monitors[pid]; !ok {
+		st.monitors[pid] = gns.process.MonitorProcess(pid)
+	}
+	return true
+}
+
+// deleteLock removes pid from the lock holders. Removes it from all
+// the locks if id is nil
+func (gns *globalNameServer) deleteLock(st *globalState, id etf.Term, requester etf.Term, pid etf.Pid) {
+	for key, lock := range st.locks {
+		if id != nil && key != fmt.Sprintf("%#v", id) {
+			continue
+		}
+		if requester != nil && !reflect.DeepEqual(lock.requester, requester) {
+			continue
+		}
+		for i := range lock.pids {
+			if lock.pids[i] != pid {
+				continue
+			}
+			lock.pids[i] = lock.pids[0]
+			lock.pids = lock.pids[1:]
+			break
+		}
+		if len(lock.pids) == 0 {
+			delete(st.locks, key)
+		}
+	}
+	gns.checkMonitor(st, pid)
+}
+
+// checkMonitor removes the monitor if the given process has
+// no registered names and no locks
+func (gns *globalNameServer) checkMonitor(st *globalState, pid etf.Pid) {
+	ref, ok := st.monitors[pid]
+	if !ok {
+		return
+	}
+	for _, p := range gns.process.Node.registrar.GlobalNames() {
+		if p == pid {
+			return
+		}
+	}
+	for _, lock := range st.locks {
+		for i := range lock.pids {
+			if lock.pids[i] == pid {
+				return
+			}
+		}
+	}
+	gns.process.DemonitorProcess(ref)
+	delete(st.monitors, pid)
+}
+
+func (gns *globalNameServer) knownList(st *globalState) etf.List {
+	known := etf.List{}
+	for node := range st.known {
+		known = append(known, etf.Atom(node))
+	}
+	return known
+}
+
+// globalLocker is a process which sets the lock across the nodes
+// being synchronized (the_locker in global.erl)
+type globalLocker struct {
+	GenServer
+	process *Process
+}
+
+type globalLockerNode struct {
+	tag       etf.Term
+	hisLocker etf.Pid
+	lockID    etf.Tuple
+	locked    bool
+	attempt   int
+}
+
+type globalLockerState struct {
+	nodes map[string]*globalLockerNode
+	// lock_set messages received before his_the_locker
+	preLockSet map[string]etf.Pid
+}
+
+// Init initializes process state using arbitrary arguments
+func (gl *globalLocker) Init(p *Process, args ...interface{}) interface{} {
+	gl.process = p
+	return &globalLockerState{
+		nodes:      make(map[string]*globalLockerNode),
+		preLockSet: make(map[string]etf.Pid),
+	}
+}
+
+// HandleCast serves incoming messages sending via gen_server:cast
+func (gl *globalLocker) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+// HandleCall serves incoming messages sending via gen_server:call
+func (gl *globalLocker) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", etf.Atom("ok"), state
+}
+
+// HandleInfo serves all another incoming messages (Pid ! message)
+func (gl *globalLocker) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	lib.Log("GLOBAL_LOCKER: HandleInfo: %#v", message)
+	st := state.(*globalLockerState)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) == 0 {
+		return "noreply", state
+	}
+
+	switch m.Element(1) {
+	case etf.Atom("his_the_locker"):
+		// {his_the_locker, HisTheLocker, Node, MyTag}. sent by the global_name_server
+		hisLocker, ok := m.Element(2).(etf.Pid)
+		if !ok {
+			return "noreply", state
+		}
+		node := atomToString(m.Element(3))
+		ids := []etf.Pid{gl.process.Self(), hisLocker}
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i].Node < ids[j].Node
+		})
+		st.nodes[node] = &globalLockerNode{
+			tag:       m.Element(4),
+			hisLocker: hisLocker,
+			lockID:    etf.Tuple{globalResourceID, etf.List{ids[0], ids[1]}},
+		}
+		if _, ok := st.preLockSet[node]; ok {
+			delete(st.preLockSet, node)
+			gl.lockIsSet(st, node)
+			return "noreply", state
+		}
+		if gl.process.Node.FullName < node {
+			// the node with the lower name sets the lock
+			gl.setLock(st, node)
+		}
+
+	case etf.Atom("lock_set"):
+		// {lock_set, HisLocker, true, HisKnown}. the lock is set by the other side
+		hisLocker, ok := m.Element(2).(etf.Pid)
+		if !ok || m.Element(3) != true {
+			return "noreply", state
+		}
+		node := string(hisLocker.Node)
+		n, ok := st.nodes[node]
+		if !ok {
+			st.preLockSet[node] = hisLocker
+			return "noreply", state
+		}
+		if n.locked {
+			// confirmation from the other side
+			return "noreply", state
+		}
+		reply := etf.Tuple{etf.Atom("lock_set"), gl.process.Self(), true, etf.List{}}
+		gl.process.Send(hisLocker, reply)
+		gl.lockIsSet(st, node)
+
+	case etf.Atom("retry"):
+		// {retry, Node}
+		node := atomToString(m.Element(2))
+		if _, ok := st.nodes[node]; ok {
+			gl.setLock(st, node)
+		}
+
+	case etf.Atom("cancel"):
+		// {cancel, Node}. synchronization is finished or node is down
+		node := atomToString(m.Element(2))
+		delete(st.preLockSet, node)
+		n, ok := st.nodes[node]
+		if !ok {
+			return "noreply", state
+		}
+		delete(st.nodes, node)
+		if n.attempt > 0 {
+			// this locker has set the lock
+			gl.delLock(n.lockID, []string{gl.process.Node.FullName, node})
+		}
+	}
+
+	return "noreply", state
+}
+
+// Terminate called when process died
+func (gl *globalLocker) Terminate(reason string, state interface{}) {
+	lib.Log("GLOBAL_LOCKER: Terminate: %#v", reason)
+}
+
+func (gl *globalLocker) setLock(st *globalLockerState, node string) {
+	n := st.nodes[node]
+	n.attempt++
+	nodes := []string{gl.process.Node.FullName, node}
+	if locked := globalSetLock(gl.process, nodes, n.lockID); len(locked) < len(nodes) {
+		gl.delLock(n.lockID, locked)
+		if n.attempt > globalLockRetries {
+			lib.Log("GLOBAL_LOCKER: can't set lock on %v. giving up", nodes)
+			return
+		}
+		gl.process.SendAfter(gl.process.Self(), etf.Tuple{etf.Atom("retry"), etf.Atom(node)}, globalRetryDelay(n.attempt))
+		return
+	}
+
+	message := etf.Tuple{etf.Atom("lock_set"), gl.process.Self(), true, etf.List{}}
+	gl.process.Send(n.hisLocker, message)
+	gl.lockIsSet(st, node)
+}
+
+func (gl *globalLocker) lockIsSet(st *globalLockerState, node string) {
+	n := st.nodes[node]
+	n.locked = true
+	message := etf.Tuple{etf.Atom("lock_is_set"), etf.Atom(node), n.tag, n.lockID}
+	gl.process.Cast(etf.Atom(globalNameServerName), message)
+}
+
+func (gl *globalLocker) delLock(lockID etf.Tuple, nodes []string) {
+	globalDelLock(gl.process, nodes, lockID)
+}
+
+// globalRegistrar is a temporary process used to make the transaction
+// across the known nodes (the_registrar in global.erl)
+type globalRegistrar struct {
+	GenServer
+}
+
+// Init initializes process state using arbitrary arguments
+func (gr *globalRegistrar) Init(p *Process, args ...interface{}) interface{} {
+	return nil
+}
+
+// HandleCast serves incoming messages sending via gen_server:cast
+func (gr *globalRegistrar) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+// HandleCall serves incoming messages sending via gen_server:call
+func (gr *globalRegistrar) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", etf.Atom("ok"), state
+}
+
+// HandleInfo serves all another incoming messages (Pid ! message)
+func (gr *globalRegistrar) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+// Terminate called when process died
+func (gr *globalRegistrar) Terminate(reason string, state interface{}) {
+}
+
+// globalTransaction sets the lock {global, Registrar} on all the known nodes
+// and invokes the given function with the list of these nodes
+func (n *Node) globalTransaction(fun func(p *Process, nodes []string) error) error {
+	p, err := n.Spawn("", ProcessOptions{}, &globalRegistrar{})
+	if err != nil {
+		return err
+	}
+	defer p.Exit(p.Self(), "normal")
+
+	known, err := p.Call(etf.Atom(globalNameServerName), etf.Atom("get_known"))
+	if err != nil {
+		return err
+	}
+	nodes := []string{n.FullName}
+	for _, node := range known.(etf.List) {
+		nodes = append(nodes, atomToString(node))
+	}
+
+	lockID := etf.Tuple{globalResourceID, p.Self()}
+	for attempt := 1; ; attempt++ {
+		locked := globalSetLock(p, nodes, lockID)
+		if len(locked) == len(nodes) {
+			break
+		}
+		globalDelLock(p, locked, lockID)
+		if attempt > globalLockRetries {
+			return ErrTimeout
+		}
+		time.Sleep(globalRetryDelay(attempt))
+	}
+	defer globalDelLock(p, nodes, lockID)
+
+	return fun(p, nodes)
+}
+
+// globalSetLock sets lock on the given nodes. Returns the list
+// of nodes the lock was set on. Unavailable nodes are treated as locked
+func globalSetLock(p *Process, nodes []string, lockID etf.Tuple) []string {
+	locked := []string{}
+	message := etf.Tuple{etf.Atom("set_lock"), lockID}
+	for _, node := range nodes {
+		if !globalIsAvailable(p, node) {
+			locked = append(locked, node)
+			continue
+		}
+		reply, err := p.Call(globalServerAt(p, node), message)
+		if err == nil && reply != true {
+			break
+		}
+		locked = append(locked, node)
+	}
+	return locked
+}
+
+func globalDelLock(p *Process, nodes []string, lockID etf.Tuple) {
+	message := etf.Tuple{etf.Atom("del_lock"), lockID}
+	for _, node := range nodes {
+		if !globalIsAvailable(p, node) {
+			continue
+		}
+		p.Call(globalServerAt(p, node), message)
+	}
+}
+
+// globalIsAvailable returns true if the given node is the local one
+// or connected to it
+func globalIsAvailable(p *Process, node string) bool {
+	if node == p.Node.FullName {
+		return true
+	}
+	for _, peer := range p.Node.GetPeerList() {
+		if peer == node {
+			return true
+		}
+	}
+	return false
+}
+
+func globalRetryDelay(attempt int) time.Duration {
+	if attempt > 5 {
+		attempt = 5
+	}
+	max := 100 * (1 << uint(attempt))
+	return time.Duration(max/2+rand.Intn(max/2)) * time.Millisecond
+}
+
+// globalMinMax orders the processes by their nodes (minmax in global.erl)
+func globalMinMax(pid1, pid2 etf.Pid) (etf.Pid, etf.Pid) {
+	if pid1.Node < pid2.Node || (pid1.Node == pid2.Node && pid1.ID < pid2.ID) {
+		return pid1, pid2
+	}
+	return pid2, pid1
+}
+
+func globalServerAt(p *Process, node string) interface{} {
+	if node == p.Node.FullName {
+		return etf.Atom(globalNameServerName)
+	}
+	return etf.Tuple{etf.Atom(globalNameServerName), etf.Atom(node)}
+}
+
+func atomToString(term etf.Term) string {
+	switch t := term.(type) {
+	case etf.Atom:
+		return string(t)
+	case string:
+		return t
+	}
+	return ""
+}
+
+func sameTag(a, b etf.Term) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	// tags are integers, but could be decoded as int, int64 or big.Int
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,233 @@
+package ergo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestGlobalNameServer(t *testing.T) {
+	fmt.Printf("\n=== Test Global Name Server\n")
+	fmt.Printf("Starting nodes: nodeGNS1@localhost, nodeGNS2@localhost: ")
+	node1, _ := CreateNode("nodeGNS1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeGNS2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	fmt.Printf("    register global name 'gs1' before the nodes are connected: ")
+	if err := node1.RegisterGlobal("gs1", node1gs1.Self()); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := node1.WhereIsGlobal("gs1"); err != nil || pid != node1gs1.Self() {
+		t.Fatal("global name is not registered", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodes and wait for synchronization: ")
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	waitForGlobalName(t, node2, "gs1", node1gs1.Self())
+
+	fmt.Printf("    register global name 'gs2' on %#v: ", node2.FullName)
+	if err := node2.RegisterGlobal("gs2", node2gs2.Self()); err != nil {
+		t.Fatal(err)
+	}
+	waitForGlobalName(t, node1, "gs2", node2gs2.Self())
+
+	fmt.Printf("    register taken name: ")
+	if err := node2.RegisterGlobal("gs1", node2gs2.Self()); err != ErrNameIsTaken {
+		t.Fatal("expected", ErrNameIsTaken, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process.Send by GlobalName('gs1') remote (gs2) -> local (gs1): ")
+	node2gs2.Send(GlobalName("gs1"), etf.Atom("hi"))
+	waitForResultWithValue(t, gs1.v, etf.Atom("hi"))
+
+	fmt.Printf("    process.Call by GlobalName('gs2') local (gs1) -> remote (gs2): ")
+	if v, err := node1gs1.Call(GlobalName("gs2"), etf.Atom("hi call")); err != nil {
+		t.Fatal(err)
+	} else if v != etf.Atom("hi call") {
+		t.Fatal("expected 'hi call', got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    unregister global name 'gs1': ")
+	if err := node2.UnregisterGlobal("gs1"); err != nil {
+		t.Fatal(err)
+	}
+	waitForGlobalName(t, node1, "gs1", etf.Pid{})
+
+	fmt.Printf("    process.Send by unknown global name: ")
+	if err := node2gs2.Send(GlobalName("gs1"), etf.Atom("hi")); err != ErrUnknown {
+		t.Fatal("expected", ErrUnknown, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    process.Send by {global, Node} to the process registered as 'global': ")
+	if err := node1.Register("global", node1gs1.Self()); err != nil {
+		t.Fatal(err)
+	}
+	node2gs2.Send(etf.Tuple{etf.Atom("global"), etf.Atom(node1.FullName)}, etf.Atom("hi global"))
+	waitForResultWithValue(t, gs1.v, etf.Atom("hi global"))
+	node1.Unregister("global")
+
+	fmt.Printf("    terminate process registered as 'gs2': ")
+	node2gs2.Exit(etf.Pid{}, "normal")
+	waitForGlobalName(t, node1, "gs2", etf.Pid{})
+}
+
+func TestGlobalNameServerConflict(t *testing.T) {
+	fmt.Printf("\n=== Test Global Name Server (name conflict)\n")
+	fmt.Printf("Starting nodes: nodeGNS3@localhost, nodeGNS4@localhost: ")
+	node3, _ := CreateNode("nodeGNS3@localhost", "cookies", NodeOptions{})
+	node4, _ := CreateNode("nodeGNS4@localhost", "cookies", NodeOptions{})
+	if node3 == nil || node4 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node3.Stop()
+	defer node4.Stop()
+	fmt.Println("OK")
+
+	gs3 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs4 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs3 on %#v: ", node3.FullName)
+	node3gs3, _ := node3.Spawn("gs3", ProcessOptions{}, gs3, nil)
+	waitForResultWithValue(t, gs3.v, node3gs3.Self())
+
+	fmt.Printf("    wait for start of gs4 on %#v: ", node4.FullName)
+	node4gs4, _ := node4.Spawn("gs4", ProcessOptions{}, gs4, nil)
+	waitForResultWithValue(t, gs4.v, node4gs4.Self())
+
+	fmt.Printf("    register the same global name on both (not connected) nodes: ")
+	if err := node3.RegisterGlobal("conflict", node3gs3.Self()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node4.RegisterGlobal("conflict", node4gs4.Self()); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodes. the name must be kept by %#v: ", node3.FullName)
+	if err := node4.connect(etf.Atom(node3.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	waitForGlobalName(t, node4, "conflict", node3gs3.Self())
+	if pid, _ := node3.WhereIsGlobal("conflict"); pid != node3gs3.Self() {
+		t.Fatal("name table is inconsistent", pid)
+	}
+
+	fmt.Printf("    loser must be notified: ")
+	waitForResultWithValue(t, gs4.v, etf.Tuple{etf.Atom("global_name_conflict"), etf.Atom("conflict")})
+}
+
+func TestGlobalNameServerResolveMethod(t *testing.T) {
+	fmt.Printf("\n=== Test Global Name Server (resolve method)\n")
+	fmt.Printf("Starting nodes: nodeGNS5@localhost, nodeGNS6@localhost: ")
+	node5, _ := CreateNode("nodeGNS5@localhost", "cookies", NodeOptions{})
+	node6, _ := CreateNode("nodeGNS6@localhost", "cookies", NodeOptions{})
+	if node5 == nil || node6 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node5.Stop()
+	defer node6.Stop()
+	fmt.Println("OK")
+
+	gs := make([]*testMonitorGenServer, 4)
+	processes := make([]*Process, 4)
+	for i := range gs {
+		node := node5
+		if i > 1 {
+			node = node6
+		}
+		gs[i] = &testMonitorGenServer{
+			v: make(chan interface{}, 2),
+		}
+		fmt.Printf("    wait for start of gs%d on %#v: ", i+1, node.FullName)
+		processes[i], _ = node.Spawn("", ProcessOptions{}, gs[i], nil)
+		waitForResultWithValue(t, gs[i].v, processes[i].Self())
+	}
+
+	fmt.Printf("    register names with the resolve methods on %#v: ", node5.FullName)
+	methods := []string{"random_exit_name", "notify_all_name"}
+	for i, method := range methods {
+		register := etf.Tuple{etf.Atom("register"), etf.Atom(method), processes[i].Self(),
+			etf.Export{Module: "global", Function: etf.Atom(method), Arity: 3}}
+		if reply, err := processes[i].Call(etf.Atom(globalNameServerName), register); err != nil || reply != etf.Atom("yes") {
+			t.Fatal(reply, err)
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    register the same names on %#v: ", node6.FullName)
+	for i, method := range methods {
+		if err := node6.RegisterGlobal(method, processes[i+2].Self()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodes. 'random_exit_name' must be kept by %#v: ", node5.FullName)
+	if err := node6.connect(etf.Atom(node5.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	waitForGlobalName(t, node6, "random_exit_name", processes[0].Self())
+
+	fmt.Printf("    loser must be killed: ")
+	if err := processes[2].WaitWithTimeout(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    'notify_all_name' must be removed on both nodes: ")
+	waitForGlobalName(t, node6, "notify_all_name", etf.Pid{})
+	if _, err := node5.WhereIsGlobal("notify_all_name"); err != ErrUnknown {
+		t.Fatal("name table is inconsistent")
+	}
+
+	fmt.Printf("    gs2 must be notified: ")
+	waitForResultWithValue(t, gs[1].v, etf.Tuple{etf.Atom("global_name_conflict"), etf.Atom("notify_all_name"), processes[3].Self()})
+	fmt.Printf("    gs4 must be notified: ")
+	waitForResultWithValue(t, gs[3].v, etf.Tuple{etf.Atom("global_name_conflict"), etf.Atom("notify_all_name"), processes[1].Self()})
+}
+
+func waitForGlobalName(t *testing.T, node *Node, name string, expected etf.Pid) {
+	timeout := time.After(3 * time.Second)
+	for {
+		pid, _ := node.WhereIsGlobal(name)
+		if pid == expected {
+			fmt.Println("OK")
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatal("global name", name, "expected", expected, "got", pid)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -87,6 +87,12 @@ func (b *Buffer) Append(v []byte) {
 	b.B = append(b.B, v...)
 }
 
+// Write implements io.Writer interface
+func (b *Buffer) Write(v []byte) (int, error) {
+	b.B = append(b.B, v...)
+	return len(v), nil
+}
+
 func (b *Buffer) String() string {
 	return string(b.B)
 }
@@ -30,6 +30,8 @@ type monitor struct {
 	nodes          map[string][]monitorItem
 	ref2node       map[string]string
 	mutexNodes     sync.Mutex
+	nodesAll       map[etf.Pid]MonitorNodesOptions
+	mutexNodesAll  sync.Mutex
 
 	node *Node
 }
@@ -39,6 +41,7 @@ func createMonitor(node *Node) *monitor {
 		processes: make(map[etf.Pid][]monitorItem),
 		links:     make(map[etf.Pid][]etf.Pid),
 		nodes:     make(map[string][]monitorItem),
+		nodesAll:  make(map[etf.Pid]MonitorNodesOptions),
 
 		ref2pid:  make(map[string]etf.Pid),
 		ref2node: make(map[string]string),
@@ -71,22 +74,11 @@ next:
 		// If Pid does not exist, the 'DOWN' message should be
 		// send immediately with Reason set to noproc.
 		if p := m.node.registrar.GetProcessByPid(t); string(t.Node) == m.node.FullName && p == nil {
-			m.notifyProcessTerminated(ref, by, t, "noproc")
+			m.notifyProcessTerminated(ref, by, t, etf.Atom("noproc"))
 			return
 		}
 
-		m.mutexProcesses.Lock()
-		l := m.processes[t]
-		key := ref.String()
-		item := monitorItem{
-			pid:     by,
-			ref:     ref,
-			key:     key,
-			process: t,
-		}
-		m.processes[t] = append(l, item)
-		m.ref2pid[key] = t
-		m.mutexProcesses.Unlock()
+		key := m.addMonitor(by, t, ref)
 
 		if isFakePid(t) {
 			// this Pid was created as a virtual. we use virtual (fake) pids for the
@@ -102,7 +94,7 @@ next:
 		// request monitoring the remote process
 		message := etf.Tuple{distProtoMONITOR, by, t, ref}
 		if err := m.node.registrar.routeRaw(t.Node, message); err != nil {
-			m.notifyProcessTerminated(ref, by, t, "noconnection")
+			m.notifyProcessTerminated(ref, by, t, etf.Atom("noconnection"))
 			m.mutexProcesses.Lock()
 			delete(m.ref2pid, key)
 			m.mutexProcesses.Unlock()
@@ -114,7 +106,7 @@ next:
 		// If Pid does not exist, the 'DOWN' message should be
 		// send immediately with Reason set to noproc.
 		if p := m.node.registrar.GetProcessByName(t); p == nil {
-			m.notifyProcessTerminated(ref, by, fakePid, "noproc")
+			m.notifyProcessTerminated(ref, by, fakePid, etf.Atom("noproc"))
 			return
 		}
 		process = fakePid
@@ -123,7 +115,7 @@ next:
 		// the same as 'string'
 		fakePid := fakeMonitorPidFromName(string(t), m.node.FullName)
 		if p := m.node.registrar.GetProcessByName(string(t)); p == nil {
-			m.notifyProcessTerminated(ref, by, fakePid, "noproc")
+			m.notifyProcessTerminated(ref, by, fakePid, etf.Atom("noproc"))
 			return
 		}
 		process = fakePid
@@ -145,7 +137,7 @@ next:
 			// If Pid does not exist, the 'DOWN' message should be
 			// send immediately with Reason set to noproc.
 			if p := m.node.registrar.GetProcessByName(name); p == nil {
-				m.notifyProcessTerminated(ref, by, fakePid, "noproc")
+				m.notifyProcessTerminated(ref, by, fakePid, etf.Atom("noproc"))
 				return
 			}
 			goto next
@@ -153,7 +145,7 @@ next:
 
 		message := etf.Tuple{distProtoMONITOR, by, name, ref}
 		if err := m.node.registrar.routeRaw(etf.Atom(nodeName), message); err != nil {
-			m.notifyProcessTerminated(ref, by, fakePid, "noconnection")
+			m.notifyProcessTerminated(ref, by, fakePid, etf.Atom("noconnection"))
 			return
 		}
 
@@ -162,6 +154,25 @@ next:
 	}
 }
 
+// addMonitor records the monitor without any checks and network
+// interactions. Returns the key of the monitor.
+func (m *monitor) addMonitor(by etf.Pid, process etf.Pid, ref etf.Ref) string {
+	m.mutexProcesses.Lock()
+	defer m.mutexProcesses.Unlock()
+
+	l := m.processes[process]
+	key := ref.String()
+	item := monitorItem{
+		pid:     by,
+		ref:     ref,
+		key:     key,
+		process: process,
+	}
+	m.processes[process] = append(l, item)
+	m.ref2pid[key] = process
+	return key
+}
+
 func (m *monitor) DemonitorProcess(ref etf.Ref) bool {
 	var pid etf.Pid
 	var ok bool
@@ -255,7 +266,7 @@ func (m *monitor) Link(pidA, pidB etf.Pid) {
 		// for the local process we should make sure if its alive
 		// otherwise send 'EXIT' message with 'noproc' as a reason
 		if p := m.node.registrar.GetProcessByPid(pidB); p == nil {
-			m.notifyProcessExit(pidA, pidB, "noproc")
+			m.notifyProcessExit(pidA, pidB, etf.Atom("noproc"))
 			if len(linksA) > 0 {
 				m.links[pidA] = linksA
 			} else {
@@ -269,7 +280,7 @@ func (m *monitor) Link(pidA, pidB etf.Pid) {
 		if err := m.node.registrar.routeRaw(pidB.Node, message); err != nil {
 			// seems we have no connection with this node. notify the sender
 			// with 'EXIT' message and 'noconnection' as a reason
-			m.notifyProcessExit(pidA, pidB, "noconnection")
+			m.notifyProcessExit(pidA, pidB, etf.Atom("noconnection"))
 			if len(linksA) > 0 {
 				m.links[pidA] = linksA
 			} else {
@@ -282,6 +293,26 @@ func (m *monitor) Link(pidA, pidB etf.Pid) {
 	m.links[pidB] = append(linksB, pidA)
 }
 
+// addLink records the link between pidA and pidB in both directions
+// without sending the LINK message. Used if the link has been
+// established by the remote side (spawn request with 'link' option)
+func (m *monitor) addLink(pidA, pidB etf.Pid) {
+	m.mutexLinks.Lock()
+	defer m.mutexLinks.Unlock()
+
+	add := func(a, b etf.Pid) {
+		links := m.links[a]
+		for i := range links {
+			if links[i] == b {
+				return
+			}
+		}
+		m.links[a] = append(links, b)
+	}
+	add(pidA, pidB)
+	add(pidB, pidA)
+}
+
 func (m *monitor) Unlink(pidA, pidB etf.Pid) {
 	m.mutexLinks.Lock()
 	defer m.mutexLinks.Unlock()
@@ -379,8 +410,30 @@ func (m *monitor) DemonitorNode(ref etf.Ref) {
 	delete(m.ref2node, key)
 }
 
-func (m *monitor) NodeDown(name string) {
-	lib.Log("[%s] MONITOR NODE  down: %v", m.node.FullName, name)
+// MonitorNodes subscribes process to the status changes of all the nodes
+func (m *monitor) MonitorNodes(by etf.Pid, opts MonitorNodesOptions) {
+	lib.Log("[%s] MONITOR NODES: %v", m.node.FullName, by)
+	m.mutexNodesAll.Lock()
+	m.nodesAll[by] = opts
+	m.mutexNodesAll.Unlock()
+}
+
+// DemonitorNodes cancels subscription made by MonitorNodes
+func (m *monitor) DemonitorNodes(by etf.Pid) {
+	lib.Log("[%s] DEMONITOR NODES: %v", m.node.FullName, by)
+	m.mutexNodesAll.Lock()
+	delete(m.nodesAll, by)
+	m.mutexNodesAll.Unlock()
+}
+
+func (m *monitor) NodeUp(name string, hidden bool) {
+	lib.Log("[%s] MONITOR NODE  up: %v", m.node.FullName, name)
+	m.notifyNodesAll(etf.Atom("nodeup"), name, hidden, "")
+}
+
+func (m *monitor) NodeDown(name string, hidden bool, reason string) {
+	lib.Log("[%s] MONITOR NODE  down: %v (%s)", m.node.FullName, name, reason)
+	m.notifyNodesAll(etf.Atom("nodedown"), name, hidden, reason)
 
 	m.mutexNodes.Lock()
 	if pids, ok := m.nodes[name]; ok {
@@ -403,7 +456,7 @@ func (m *monitor) NodeDown(name string) {
 			continue
 		}
 		for i := range ps {
-			m.notifyProcessTerminated(ps[i].ref, ps[i].pid, pid, "noconnection")
+			m.notifyProcessTerminated(ps[i].ref, ps[i].pid, pid, etf.Atom("noconnection"))
 			delete(m.ref2pid, ps[i].key)
 		}
 		delete(m.processes, pid)
@@ -418,7 +471,7 @@ func (m *monitor) NodeDown(name string) {
 		}
 
 		for i := range pids {
-			m.notifyProcessExit(pids[i], link, "noconnection")
+			m.notifyProcessExit(pids[i], link, etf.Atom("noconnection"))
 			p, ok := m.links[pids[i]]
 
 			if !ok {
@@ -449,6 +502,16 @@ func (m *monitor) NodeDown(name string) {
 }
 
 func (m *monitor) ProcessTerminated(terminated etf.Pid, name, reason string) {
+	m.processTerminated(terminated, name, etf.Atom(reason))
+}
+
+// ProcessCrashed notifies the links and monitors about the process terminated
+// by panic with the reason {panic, Value, Stack}
+func (m *monitor) ProcessCrashed(terminated etf.Pid, name string, crash ProcessPanic) {
+	m.processTerminated(terminated, name, crash.Term())
+}
+
+func (m *monitor) processTerminated(terminated etf.Pid, name string, reason etf.Term) {
 	lib.Log("[%s] MONITOR process terminated: %v", m.node.FullName, terminated)
 
 	// just wrapper for the iterating through monitors list
@@ -508,6 +571,71 @@ func (m *monitor) ProcessTerminated(terminated etf.Pid, name, reason string) {
 	}
 	m.mutexLinks.Unlock()
 
+	// remove subscription made by MonitorNodes
+	m.mutexNodesAll.Lock()
+	delete(m.nodesAll, terminated)
+	m.mutexNodesAll.Unlock()
+}
+
+// LinkExit handles the exit signal sent by the remote linked process 'from'
+// to the local process 'to'. Removes the link between them.
+func (m *monitor) LinkExit(to etf.Pid, from etf.Pid, reason etf.Term) {
+	lib.Log("[%s] LINK process exited: %v send notify to: %v", m.node.FullName, from, to)
+
+	m.mutexLinks.Lock()
+	removeLink := func(a, b etf.Pid) bool {
+		links, ok := m.links[a]
+		if !ok {
+			return false
+		}
+		for i := range links {
+			if links[i] != b {
+				continue
+			}
+			links[i] = links[0]
+			links = links[1:]
+			if len(links) > 0 {
+				m.links[a] = links
+			} else {
+				delete(m.links, a)
+			}
+			return true
+		}
+		return false
+	}
+	linked := removeLink(to, from)
+	removeLink(from, to)
+	m.mutexLinks.Unlock()
+
+	if !linked {
+		// the link has been removed already
+		return
+	}
+
+	m.notifyProcessExit(to, from, reason)
+}
+
+// ExitSignal delivers the exit signal sent by the process 'from' using
+// erlang:exit/2 to the local process 'to'. Links are not affected.
+func (m *monitor) ExitSignal(to etf.Pid, from etf.Pid, reason string) {
+	p := m.node.GetProcessByPid(to)
+	if p == nil || !p.IsAlive() {
+		return
+	}
+
+	switch reason {
+	case "kill":
+		// can't be trapped
+		p.Kill()
+	case "normal":
+		// ignored unless the process is trapping exits
+		if !p.GetTrapExit() {
+			return
+		}
+		p.Exit(from, reason)
+	default:
+		p.Exit(from, reason)
+	}
 }
 
 func (m *monitor) GetLinks(process etf.Pid) []etf.Pid {
@@ -550,12 +678,50 @@ func (m *monitor) GetMonitoredBy(process etf.Pid) []etf.Pid {
 	return monitors
 }
 
+// notifyNodesAll sends {nodeup, Node, InfoList} or {nodedown, Node, InfoList} to the
+// processes subscribed by MonitorNodes (in fashion of net_kernel:monitor_nodes/2)
+func (m *monitor) notifyNodesAll(status etf.Atom, node string, hidden bool, reason string) {
+	nodeType := etf.Atom("visible")
+	if hidden {
+		nodeType = etf.Atom("hidden")
+	}
+
+	// the messages are sent once the lock is released
+	notify := make(map[etf.Pid]etf.Tuple)
+	m.mutexNodesAll.Lock()
+	for pid, opts := range m.nodesAll {
+		info := etf.List{}
+		switch opts.NodeType {
+		case "":
+			if hidden {
+				continue
+			}
+		case "all":
+			info = append(info, etf.Tuple{etf.Atom("node_type"), nodeType})
+		default:
+			if string(nodeType) != opts.NodeType {
+				continue
+			}
+			info = append(info, etf.Tuple{etf.Atom("node_type"), nodeType})
+		}
+		if opts.NodedownReason && status == etf.Atom("nodedown") {
+			info = append(info, etf.Tuple{etf.Atom("nodedown_reason"), etf.Atom(reason)})
+		}
+		notify[pid] = etf.Tuple{status, etf.Atom(node), info}
+	}
+	m.mutexNodesAll.Unlock()
+
+	for pid, message := range notify {
+		m.node.registrar.route(etf.Pid{}, pid, message)
+	}
+}
+
 func (m *monitor) notifyNodeDown(to etf.Pid, node string) {
 	message := etf.Term(etf.Tuple{etf.Atom("nodedown"), node})
 	m.node.registrar.route(etf.Pid{}, to, message)
 }
 
-func (m *monitor) notifyProcessTerminated(ref etf.Ref, to etf.Pid, terminated etf.Pid, reason string) {
+func (m *monitor) notifyProcessTerminated(ref etf.Ref, to etf.Pid, terminated etf.Pid, reason etf.Term) {
 	// for remote {21, FromProc, ToPid, Ref, Reason}, where FromProc = monitored process
 	if to.Node != etf.Atom(m.node.FullName) {
 		if isFakePid(terminated) {
@@ -563,12 +729,12 @@ func (m *monitor) notifyProcessTerminated(ref etf.Ref, to etf.Pid, terminated et
 			// fakeMonitorPidFromName. It means this Pid has
 			// "processName|nodeName" in Node field
 			terminatedName := fakePidToName(terminated)
-			message := etf.Tuple{distProtoMONITOR_EXIT, etf.Atom(terminatedName), to, ref, etf.Atom(reason)}
+			message := etf.Tuple{distProtoMONITOR_EXIT, etf.Atom(terminatedName), to, ref, reason}
 			m.node.registrar.routeRaw(to.Node, message)
 			return
 		}
 		// terminated is a real Pid. send it as it is.
-		message := etf.Tuple{distProtoMONITOR_EXIT, terminated, to, ref, etf.Atom(reason)}
+		message := etf.Tuple{distProtoMONITOR_EXIT, terminated, to, ref, reason}
 		m.node.registrar.routeRaw(to.Node, message)
 		return
 	}
@@ -583,26 +749,26 @@ func (m *monitor) notifyProcessTerminated(ref etf.Ref, to etf.Pid, terminated et
 	if isFakePid(terminated) {
 		// it was monitored by name
 		p := fakePidToTuple(terminated)
-		message := etf.Term(etf.Tuple{etf.Atom("DOWN"), ref, etf.Atom("process"), p, etf.Atom(reason)})
+		message := etf.Term(etf.Tuple{etf.Atom("DOWN"), ref, etf.Atom("process"), p, reason})
 		m.node.registrar.route(terminated, to, message)
 		return
 	}
 
-	message := etf.Term(etf.Tuple{etf.Atom("DOWN"), ref, etf.Atom("process"), terminated, etf.Atom(reason)})
+	message := etf.Term(etf.Tuple{etf.Atom("DOWN"), ref, etf.Atom("process"), terminated, reason})
 	m.node.registrar.route(terminated, to, message)
 }
 
-func (m *monitor) notifyProcessExit(to etf.Pid, terminated etf.Pid, reason string) {
+func (m *monitor) notifyProcessExit(to etf.Pid, terminated etf.Pid, reason etf.Term) {
 	// for remote: {3, FromPid, ToPid, Reason}
 	if to.Node != etf.Atom(m.node.FullName) {
-		message := etf.Tuple{distProtoEXIT, terminated, to, etf.Atom(reason)}
+		message := etf.Tuple{distProtoEXIT, terminated, to, reason}
 		m.node.registrar.routeRaw(to.Node, message)
 		return
 	}
 
 	// check if 'to' process is still alive. otherwise ignore this event
 	if p := m.node.GetProcessByPid(to); p != nil && p.IsAlive() {
-		p.Exit(terminated, reason)
+		p.exitSignal(terminated, reason)
 	}
 }
 
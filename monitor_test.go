@@ -3,6 +3,7 @@ package ergo
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/halturin/ergo/etf"
 )
@@ -42,7 +43,7 @@ func (tgs *testMonitorGenServer) Terminate(reason string, state interface{}) {
 func TestMonitorLocalLocal(t *testing.T) {
 	fmt.Printf("\n=== Test Monitor Local-Local\n")
 	fmt.Printf("Starting node: nodeM1LocalLocal@localhost: ")
-	node1 := CreateNode("nodeM1LocalLocal@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeM1LocalLocal@localhost", "cookies", NodeOptions{})
 	if node1 == nil {
 		t.Fatal("can't start node")
 	} else {
@@ -164,8 +165,8 @@ func TestMonitorLocalLocal(t *testing.T) {
 func TestMonitorLocalRemoteByPid(t *testing.T) {
 	fmt.Printf("\n=== Test Monitor Local-Remote by Pid\n")
 	fmt.Printf("Starting nodes: nodeM1LocalRemoteByPid@localhost, nodeM2LocalRemoteByPid@localhost: ")
-	node1 := CreateNode("nodeM1LocalRemoteByPid@localhost", "cookies", NodeOptions{})
-	node2 := CreateNode("nodeM2LocalRemoteByPid@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeM1LocalRemoteByPid@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeM2LocalRemoteByPid@localhost", "cookies", NodeOptions{})
 	if node1 == nil || node2 == nil {
 		t.Fatal("can't start nodes")
 	} else {
@@ -264,8 +265,8 @@ func TestMonitorLocalRemoteByPid(t *testing.T) {
 func TestMonitorLocalRemoteByTuple(t *testing.T) {
 	fmt.Printf("\n=== Test Monitor Local-Remote by Tuple\n")
 	fmt.Printf("Starting nodes: nodeM1LocalRemoteByTuple@localhost, nodeM2LocalRemoteByTuple@localhost: ")
-	node1 := CreateNode("nodeM1LocalRemoteByTuple@localhost", "cookies", NodeOptions{})
-	node2 := CreateNode("nodeM2LocalRemoteByTuple@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeM1LocalRemoteByTuple@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeM2LocalRemoteByTuple@localhost", "cookies", NodeOptions{})
 	if node1 == nil || node2 == nil {
 		t.Fatal("can't start nodes")
 	} else {
@@ -370,7 +371,7 @@ func TestMonitorLocalRemoteByTuple(t *testing.T) {
 func TestLinkLocalLocal(t *testing.T) {
 	fmt.Printf("\n=== Test Link Local-Local\n")
 	fmt.Printf("Starting node: nodeL1LocalLocal@localhost: ")
-	node1 := CreateNode("nodeL1LocalLocal@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeL1LocalLocal@localhost", "cookies", NodeOptions{})
 	if node1 == nil {
 		t.Fatal("can't start node")
 	} else {
@@ -505,8 +506,8 @@ func TestLinkLocalLocal(t *testing.T) {
 func TestLinkLocalRemote(t *testing.T) {
 	fmt.Printf("\n=== Test Link Local-Remote by Pid\n")
 	fmt.Printf("Starting nodes: nodeL1LocalRemoteByPid@localhost, nodeL2LocalRemoteByPid@localhost: ")
-	node1 := CreateNode("nodeL1LocalRemoteByPid@localhost", "cookies", NodeOptions{})
-	node2 := CreateNode("nodeL2LocalRemoteByPid@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeL1LocalRemoteByPid@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeL2LocalRemoteByPid@localhost", "cookies", NodeOptions{})
 	if node1 == nil || node2 == nil {
 		t.Fatal("can't start nodes")
 	} else {
@@ -696,6 +697,87 @@ func TestLinkLocalRemote(t *testing.T) {
 	node1.Stop()
 }
 
+func TestMonitorNodes(t *testing.T) {
+	fmt.Printf("\n=== Test Monitor Nodes\n")
+	fmt.Printf("Starting nodes: nodeMN1@localhost, nodeMN2@localhost: ")
+	node1, _ := CreateNode("nodeMN1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeMN2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	} else {
+		fmt.Println("OK")
+	}
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node1.FullName)
+	node1gs2, _ := node1.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node1gs2.Self())
+
+	node1.MonitorNodes(node1gs1.Self(), MonitorNodesOptions{})
+	opts := MonitorNodesOptions{
+		NodeType:       "all",
+		NodedownReason: true,
+	}
+	node1.MonitorNodes(node1gs2.Self(), opts)
+
+	fmt.Printf("... monitor nodes by remote process: ")
+	if err := node1.MonitorNodes(etf.Pid{Node: etf.Atom(node2.FullName)}, opts); err != ErrProcessNotLocal {
+		t.Fatal("expected", ErrProcessNotLocal, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... connect nodes. gs1 (default options): ")
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	result := etf.Tuple{etf.Atom("nodeup"), etf.Atom(node2.FullName), etf.List{}}
+	waitForResultWithValue(t, gs1.v, result)
+
+	fmt.Printf("... connect nodes. gs2 (node_type all, nodedown_reason): ")
+	nodeType := etf.Tuple{etf.Atom("node_type"), etf.Atom("visible")}
+	result = etf.Tuple{etf.Atom("nodeup"), etf.Atom(node2.FullName), etf.List{nodeType}}
+	waitForResultWithValue(t, gs2.v, result)
+
+	fmt.Printf("... stop node. gs1 (default options): ")
+	node2.Stop()
+	result = etf.Tuple{etf.Atom("nodedown"), etf.Atom(node2.FullName), etf.List{}}
+	waitForResultWithValue(t, gs1.v, result)
+
+	fmt.Printf("... stop node. gs2 (node_type all, nodedown_reason): ")
+	reason := etf.Tuple{etf.Atom("nodedown_reason"), etf.Atom("connection_closed")}
+	result = etf.Tuple{etf.Atom("nodedown"), etf.Atom(node2.FullName), etf.List{nodeType, reason}}
+	waitForResultWithValue(t, gs2.v, result)
+
+	fmt.Printf("... demonitor nodes (gs1) and terminate gs2: ")
+	node1.DemonitorNodes(node1gs1.Self())
+	node1gs2.Exit(etf.Pid{}, "normal")
+	// global_name_server and pg are subscribed as well
+	for i := 0; ; i++ {
+		node1.monitor.mutexNodesAll.Lock()
+		n := len(node1.monitor.nodesAll)
+		node1.monitor.mutexNodesAll.Unlock()
+		if n == 2 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("subscriptions haven't been removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fmt.Println("OK")
+	node1.Stop()
+}
+
 // helpers
 func chechCleanProcessRef(node *Node, ref etf.Ref) error {
 	node.monitor.mutexProcesses.Lock()
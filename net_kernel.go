@@ -28,6 +28,11 @@ func (nks *netKernelSup) Init(args ...interface{}) SupervisorSpec {
 				Child:   &globalNameServer{},
 				Restart: SupervisorChildRestartPermanent,
 			},
+			SupervisorChildSpec{
+				Name:    "pg",
+				Child:   &pg{},
+				Restart: SupervisorChildRestartPermanent,
+			},
 			SupervisorChildSpec{
 				Name:    "rex",
 				Child:   &rex{},
@@ -14,6 +14,8 @@ import (
 	"encoding/pem"
 	"fmt"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"sync/atomic"
 
 	"github.com/halturin/ergo/dist"
@@ -22,12 +24,11 @@ import (
 
 	"math/big"
 
-	"log"
 	"net"
 
 	//	"net/http"
-	"strconv"
-	"strings"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -35,18 +36,28 @@ import (
 type Node struct {
 	epmd     *dist.EPMD
 	listener net.Listener
-	Cookie   string
+	// Cookie is the default cookie. Use SetCookie/GetCookie to change it at runtime
+	Cookie string
 
-	registrar *registrar
-	monitor   *monitor
-	context   context.Context
-	Stop      context.CancelFunc
+	cookieMutex sync.RWMutex
+	cookies     map[string]string
 
-	StartedAt time.Time
-	uniqID    int64
+	registrar   *registrar
+	monitor     *monitor
+	connections *connectionManager
+	context     context.Context
+	Stop        context.CancelFunc
 
-	tlscertServer tls.Certificate
-	tlscertClient tls.Certificate
+	StartedAt  time.Time
+	uniqID     int64
+	appStarted uint64
+	creation   uint32
+
+	closing   int32
+	tls       *nodeTLS
+	policy    *connectionPolicy
+	resolver  Resolver
+	discovery *discovery
 
 	FullName string
 
@@ -57,23 +68,81 @@ type Node struct {
 type NodeOptions struct {
 	ListenRangeBegin       uint16
 	ListenRangeEnd         uint16
+	ListenAddress          string // bind address of TCPTransport. default is the host of the node name
+	AdvertisePort          uint16 // port registered in EPMD if it differs from the listening one (NAT)
 	Hidden                 bool
 	EPMDPort               uint16
+	EPMDAddress            string // comma separated bind addresses of the embedded EPMD server (ERL_EPMD_ADDRESS)
 	DisableEPMDServer      bool
-	SendQueueLength        int
+	SendQueueLength        int                 // per writer. default value is 100
+	SendQueuePolicy        SendQueuePolicyType // what to do if the send queue is full. default is SendQueueBlock
+	SendQueueTimeout       time.Duration       // for the SendQueueBlock policy. default value is 5 seconds
 	RecvQueueLength        int
 	FragmentationUnit      int
+	CompressionThreshold   int
+	NetTickTime            int           // in seconds. default value is 60
+	ConnectTimeout         time.Duration // dial and handshake. default value is 5 seconds
+	ReconnectBackoffMin    time.Duration // default value is 500 milliseconds
+	ReconnectBackoffMax    time.Duration // default value is 30 seconds
+	ConnectionHooks        ConnectionHooks
 	DisableHeaderAtomCache bool
 	TLSmode                TLSmodeType
 	TLScrtServer           string
 	TLSkeyServer           string
 	TLScrtClient           string
 	TLSkeyClient           string
+	TLScaFile              string        // PEM bundle to verify the peer certificates (strict mode)
+	TLSconfig              *tls.Config   // base config. certificates and CAs above are applied on top of it
+	TLSmutual              bool          // require and verify the client certificates (strict mode)
+	TLSverifyNodeName      bool          // peer certificate must have the node name (URI) or its host (DNS/IP) in SAN. TLSmutual is required for incoming
+	TLSreloadInterval      time.Duration // check the certificate files and reload them if changed
+	Transport              Transport     // default is TCPTransport
+	Resolver               Resolver      // EPMD-less mode if it is set (see StaticResolver)
+	Policy                 ConnectionPolicy
+	Discovery              DiscoveryOptions // connect to the discovered nodes automatically
+}
+
+// MonitorNodesOptions defines the options for the Node.MonitorNodes (in fashion
+// of net_kernel:monitor_nodes/2)
+type MonitorNodesOptions struct {
+	// NodeType can be "visible", "hidden" or "all". The info list of the message
+	// includes {node_type, visible|hidden} if it's set. Only visible
+	// nodes are reported by default
+	NodeType string
+	// NodedownReason includes {nodedown_reason, Reason} into the info list of the
+	// nodedown message. Reason is one of connection_closed, net_tick_timeout,
+	// disconnect or send_queue_overflow
+	NodedownReason bool
 }
 
+// RemoteSpawnFactory returns a new behaviour object (GenServer, Supervisor etc)
+// for the process spawned by the remote node
+type RemoteSpawnFactory func() ProcessBehaviour
+
+// GlobalName is a name registered with Node.RegisterGlobal. Use it as a destination
+// of Send/Call to reach the process by its global name (in fashion of {global, Name})
+type GlobalName string
+
 // TLSmodeType should be one of TLSmodeDisabled (default), TLSmodeAuto or TLSmodeStrict
 type TLSmodeType string
 
+// SendQueuePolicyType should be one of SendQueueBlock (default), SendQueueDropNewest,
+// SendQueueDropOldest or SendQueueDisconnect. The drop policies are not applied
+// to the control signals (links, monitors, exits)
+type SendQueuePolicyType string
+
+// PeerStats contains the counters of the send queues of the connected node
+type PeerStats struct {
+	// SendQueueLength is the number of messages in the send queues
+	SendQueueLength int
+	// Busy is the number of sends found the queue full (busy_dist_port)
+	Busy uint64
+	// Dropped is the number of messages dropped by the overflow policy
+	Dropped uint64
+	// TimedOut is the number of sends failed by SendQueueTimeout
+	TimedOut uint64
+}
+
 const (
 	defaultListenRangeBegin uint16 = 15000
 	defaultListenRangeEnd   uint16 = 65000
@@ -82,6 +151,12 @@ const (
 	defaultSendQueueLength   int = 100
 	defaultRecvQueueLength   int = 100
 	defaultFragmentationUnit     = 65000
+	defaultNetTickTime           = 60
+
+	defaultSendQueueTimeout    = 5 * time.Second
+	defaultConnectTimeout      = 5 * time.Second
+	defaultReconnectBackoffMin = 500 * time.Millisecond
+	defaultReconnectBackoffMax = 30 * time.Second
 
 	// TLSmodeDisabled no TLS encryption
 	TLSmodeDisabled TLSmodeType = ""
@@ -89,15 +164,27 @@ const (
 	TLSmodeAuto TLSmodeType = "auto"
 	// TLSmodeStrict with validation certificate
 	TLSmodeStrict TLSmodeType = "strict"
+
+	// SendQueueBlock sender waits for the free space in the queue up to SendQueueTimeout
+	SendQueueBlock SendQueuePolicyType = ""
+	// SendQueueDropNewest the message is dropped, send returns ErrSendQueueFull
+	SendQueueDropNewest SendQueuePolicyType = "drop_newest"
+	// SendQueueDropOldest the oldest message in the queue is dropped
+	SendQueueDropOldest SendQueuePolicyType = "drop_oldest"
+	// SendQueueDisconnect the connection is closed with reason send_queue_overflow
+	SendQueueDisconnect SendQueuePolicyType = "disconnect"
 )
 
 // CreateNode create new node with name and cookie string
-func CreateNode(name string, cookie string, opts NodeOptions) *Node {
+func CreateNode(name string, cookie string, opts NodeOptions) (*Node, error) {
 	return CreateNodeWithContext(context.Background(), name, cookie, opts)
 }
 
-// CreateNodeWithContext create new node with specified context, name and cookie string
-func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts NodeOptions) *Node {
+// CreateNodeWithContext create new node with specified context, name and cookie string.
+// Returns error if the name or options are malformed, there is no free port to
+// listen on (ErrListen) or the node can't be registered in EPMD
+// (dist.ErrDuplicateName if the name is taken)
+func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts NodeOptions) (*Node, error) {
 
 	lib.Log("Start with name '%s' and cookie '%s'", name, cookie)
 	nodectx, nodestop := context.WithCancel(ctx)
@@ -105,6 +192,7 @@ func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts
 	node := &Node{
 		epmd:      &dist.EPMD{},
 		Cookie:    cookie,
+		cookies:   make(map[string]string),
 		context:   nodectx,
 		Stop:      nodestop,
 		StartedAt: time.Now(),
@@ -112,6 +200,18 @@ func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts
 
 	}
 
+	if opts.TLSverifyNodeName && opts.TLSmode == TLSmodeDisabled {
+		nodestop()
+		return nil, ErrTLSDisabled
+	}
+
+	policy, err := createConnectionPolicy(opts.Policy)
+	if err != nil {
+		nodestop()
+		return nil, err
+	}
+	node.policy = policy
+
 	// start networking if name is defined
 	if name != "" {
 		// set defaults
@@ -130,10 +230,18 @@ func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts
 			lib.Log("Using custom EPMD port: %d", opts.EPMDPort)
 		}
 
+		if opts.EPMDAddress == "" {
+			opts.EPMDAddress = os.Getenv("ERL_EPMD_ADDRESS")
+		}
+
 		if opts.SendQueueLength == 0 {
 			opts.SendQueueLength = defaultSendQueueLength
 		}
 
+		if opts.SendQueueTimeout == 0 {
+			opts.SendQueueTimeout = defaultSendQueueTimeout
+		}
+
 		if opts.RecvQueueLength == 0 {
 			opts.RecvQueueLength = defaultRecvQueueLength
 		}
@@ -142,33 +250,108 @@ func CreateNodeWithContext(ctx context.Context, name string, cookie string, opts
 			opts.FragmentationUnit = defaultFragmentationUnit
 		}
 
+		if opts.NetTickTime == 0 {
+			opts.NetTickTime = defaultNetTickTime
+		}
+
+		if opts.ConnectTimeout == 0 {
+			opts.ConnectTimeout = defaultConnectTimeout
+		}
+
+		if opts.ReconnectBackoffMin == 0 {
+			opts.ReconnectBackoffMin = defaultReconnectBackoffMin
+		}
+
+		if opts.ReconnectBackoffMax < opts.ReconnectBackoffMin {
+			opts.ReconnectBackoffMax = defaultReconnectBackoffMax
+			if opts.ReconnectBackoffMax < opts.ReconnectBackoffMin {
+				opts.ReconnectBackoffMax = opts.ReconnectBackoffMin
+			}
+		}
+
+		if opts.Transport == nil {
+			opts.Transport = &TCPTransport{ListenAddress: opts.ListenAddress}
+		}
+
 		if opts.Hidden {
 			lib.Log("Running as hidden node")
 		}
-		ns := strings.Split(name, "@")
-		if len(ns) != 2 {
-			panic("FQDN for node name is required (example: node@hostname)")
+		if _, _, err := dist.SplitNodeName(name); err != nil {
+			nodestop()
+			return nil, err
 		}
 
-		listenPort := node.listen(ns[1], opts)
-		if listenPort == 0 {
-			panic("Can't listen port")
+		if cookie == "" {
+			c, err := readCookieFile()
+			if err != nil {
+				nodestop()
+				return nil, err
+			}
+			node.Cookie = c
+		}
+
+		node.resolver = node.epmd
+		if opts.Resolver != nil {
+			node.resolver = opts.Resolver
+			// listen on the port this node is resolved to
+			if port, _, err := opts.Resolver.Resolve(name); err == nil && port > 0 {
+				opts.ListenRangeBegin = uint16(port)
+				opts.ListenRangeEnd = uint16(port)
+			}
+		} else if resolver, ok := opts.Transport.(Resolver); ok {
+			// transport resolves the nodes by itself
+			node.resolver = resolver
+		}
+
+		listenPort, err := node.listen(name, opts)
+		if err != nil {
+			nodestop()
+			return nil, err
 		}
-		// start EPMD
-		node.epmd.Init(nodectx, name, listenPort, opts.EPMDPort, opts.Hidden, opts.DisableEPMDServer)
 
 		node.FullName = name
+		if node.resolver == node.epmd {
+			// start EPMD
+			if opts.AdvertisePort > 0 {
+				listenPort = opts.AdvertisePort
+			}
+			node.epmd.ServerAddress = opts.EPMDAddress
+			err := node.epmd.Init(nodectx, name, listenPort, opts.EPMDPort, opts.Hidden, opts.DisableEPMDServer)
+			if err != nil {
+				nodestop()
+				return nil, err
+			}
+			node.creation = node.epmd.Creation
+		} else {
+			// there is no EPMD to get the creation from
+			node.creation = uint32(time.Now().Unix())
+		}
+	}
+
+	if node.creation == 0 {
+		// zero value is reserved for the local (non-distributed) usage
+		node.creation = 1
 	}
 
 	node.opts = opts
 
 	node.registrar = createRegistrar(node)
 	node.monitor = createMonitor(node)
+	node.connections = createConnectionManager(node, opts)
 
 	netKernelSup := &netKernelSup{}
 	node.Spawn("net_kernel_sup", ProcessOptions{}, netKernelSup)
 
-	return node
+	if node.listener != nil {
+		// the node is initialized. start accepting the incoming connections
+		node.accept(opts)
+	}
+
+	// the system processes (global, pg) are up. the discovered nodes
+	// can be connected
+	node.discovery = startDiscovery(node, opts.Discovery)
+
+	return node, nil
 }
 
 // Spawn create new process
@@ -178,18 +361,34 @@ func (n *Node) Spawn(name string, opts ProcessOptions, object interface{}, args
 	if err != nil {
 		return nil, err
 	}
+	if opts.spawned != nil {
+		opts.spawned(process)
+	}
 
 	go func() {
 		pid := process.Self()
 
 		defer func() {
 			if r := recover(); r != nil {
-				fmt.Printf("Warning: recovered process(name: %s)%v %#v\n", name, process.self, r)
+				crash, ok := r.(ProcessPanic)
+				if !ok {
+					crash = ProcessPanic{
+						Value: r,
+						Stack: string(debug.Stack()),
+					}
+				}
+				fmt.Printf("Warning: recovered process(name: %s)%v %#v\n%s", name, process.self, crash.Value, crash.Stack)
 				n.registrar.UnregisterProcess(pid)
-				n.monitor.ProcessTerminated(pid, name, "panic")
+				n.monitor.ProcessCrashed(pid, name, crash)
 				process.Kill()
 
-				process.ready <- fmt.Errorf("Can't start process: %s\n", r)
+				// 'ready' is buffered so it doesn't block if the process
+				// has been crashed after initialization
+				select {
+				case process.ready <- fmt.Errorf("Can't start process: %s\n", crash.Value):
+				default:
+				}
+				close(process.stopped)
 			}
 
 			// we should close this channel otherwise if we try
@@ -246,6 +445,83 @@ func (n *Node) IsProcessAlive(pid etf.Pid) bool {
 	return p.IsAlive()
 }
 
+// Shutdown stops the node gracefully. The running applications are stopped
+// in reverse start order, then the top-level supervisors spawned by Node.Spawn
+// (supervisors terminate their children according to the shutdown specs).
+// The queued messages are sent to the connected nodes,
+// the node is unregistered in EPMD and the listener is closed. Then the node
+// is stopped (see Stop) along with the rest of the processes. If the context
+// is done before the sequence is completed the node is stopped right away
+// and the context error is returned
+func (n *Node) Shutdown(ctx context.Context) error {
+	defer n.Stop()
+
+	apps := []*ApplicationSpec{}
+	for _, spec := range n.registrar.ApplicationList() {
+		if spec.process != nil {
+			apps = append(apps, spec)
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].started > apps[j].started
+	})
+	for _, spec := range apps {
+		p := spec.process
+		if p == nil {
+			continue
+		}
+		lib.Log("[%s] shutdown: stopping application %s", n.FullName, spec.Name)
+		p.Exit(p.Self(), "shutdown")
+		select {
+		case <-p.stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	supervisors := []*Process{}
+	for _, p := range n.registrar.ProcessList() {
+		if p.parent != nil || p.Name() == "net_kernel_sup" {
+			continue
+		}
+		if _, ok := p.object.(SupervisorBehaviour); ok {
+			supervisors = append(supervisors, p)
+		}
+	}
+	sort.Slice(supervisors, func(i, j int) bool {
+		return supervisors[i].self.ID > supervisors[j].self.ID
+	})
+	for _, p := range supervisors {
+		lib.Log("[%s] shutdown: stopping supervisor %v", n.FullName, p.Self())
+		p.Exit(p.Self(), "shutdown")
+		select {
+		case <-p.stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, name := range n.registrar.PeerList() {
+		p := n.registrar.GetPeer(name)
+		if p == nil {
+			continue
+		}
+		if err := p.drain(ctx); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	if n.resolver == n.epmd {
+		n.epmd.Unregister()
+	}
+
+	atomic.StoreInt32(&n.closing, 1)
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	return nil
+}
+
 // IsAlive returns true if node is running
 func (n *Node) IsAlive() bool {
 	return n.context.Err() == nil
@@ -293,32 +569,36 @@ func (n *Node) RemoveStaticRoute(name string) {
 
 // ResolvePort resolves port number for the given name. Returns -1 if not found
 func (n *Node) ResolvePort(name string) int {
-	if port, err := n.epmd.ResolvePort(name); err == nil {
+	if port, _, err := n.resolve(name); err == nil {
 		return port
 	}
 
 	return -1
 }
 
+// resolve returns the port and the handshake version of the given node.
+// Static routes take precedence over the resolver
+func (n *Node) resolve(name string) (int, uint16, error) {
+	if port, ok := n.epmd.StaticRoute(name); ok {
+		return int(port), dist.ProtoHandshake5, nil
+	}
+	return n.resolver.Resolve(name)
+}
+
 func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 	// define the total number of reader/writer goroutines
 	numHandlers := runtime.GOMAXPROCS(-1)
 
 	// do not use shared channels within intencive code parts, impacts on a performance
 	receivers := struct {
-		recv []chan *lib.Buffer
+		recv []chan *dist.Packet
 		n    int
-		i    int
 	}{
-		recv: make([]chan *lib.Buffer, opts.RecvQueueLength),
+		recv: make([]chan *dist.Packet, numHandlers),
 		n:    numHandlers,
 	}
 
-	p := &peer{
-		name: link.GetRemoteName(),
-		send: make([]chan []etf.Term, numHandlers),
-		n:    numHandlers,
-	}
+	p := createPeer(link, numHandlers, opts)
 
 	if err := n.registrar.RegisterPeer(p); err != nil {
 		// duplicate link?
@@ -328,7 +608,7 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 	// run readers for incoming messages
 	for i := 0; i < numHandlers; i++ {
 		// run packet reader/handler routines (decoder)
-		recv := make(chan *lib.Buffer, opts.RecvQueueLength)
+		recv := make(chan *dist.Packet, opts.RecvQueueLength)
 		receivers.recv[i] = recv
 		go link.ReadHandlePacket(n.context, recv, n.handleMessage)
 	}
@@ -339,7 +619,7 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 	go func() {
 		var err error
 		var packetLength int
-		var recv chan *lib.Buffer
+		var packet *dist.Packet
 
 		ctx, cancel := context.WithCancel(n.context)
 		defer cancel()
@@ -352,6 +632,14 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 			}
 		}()
 
+		// check whether the peer is alive
+		go func() {
+			netTickTime := time.Duration(opts.NetTickTime) * time.Second
+			if err := link.Ticker(ctx, netTickTime); err != nil {
+				p.close("net_tick_timeout")
+			}
+		}()
+
 		// initializing atom cache if its enabled
 		if !opts.DisableHeaderAtomCache {
 			link.SetAtomCache(etf.NewAtomCache(ctx))
@@ -360,13 +648,11 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 
 		defer func() {
 			link.Close()
-			n.registrar.UnregisterPeer(link.GetRemoteName())
+			n.registrar.UnregisterPeer(link.GetRemoteName(), p.downReason())
 
 			// close handlers channel
+			p.closeSend()
 			for i := 0; i < numHandlers; i++ {
-				if p.send[i] != nil {
-					close(p.send[i])
-				}
 				if receivers.recv[i] != nil {
 					close(receivers.recv[i])
 				}
@@ -388,39 +674,46 @@ func (n *Node) serve(link *dist.Link, opts NodeOptions) error {
 			// take new buffer for the next reading and append the tail (part of the next packet)
 			b1 := lib.TakeBuffer()
 			b1.Set(b.B[packetLength:])
-			// cut the tail and send it further for handling.
-			// buffer b has to be released by the reader of
-			// recv channel (link.ReadHandlePacket)
+			// cut the tail and decode the control message. Packets are
+			// decoded here sequentially (atom cache, fragments) and the
+			// payload is decoded by the reader of the sender in order to
+			// keep the order of the messages. buffer b has to be released
+			// by link.ReadHandlePacket
 			b.B = b.B[:packetLength]
-			recv = receivers.recv[receivers.i]
-			recv <- b
+			packet, err = link.ReadControl(b)
 
 			// set new buffer as a current for the next reading
 			b = b1
 
-			// round-robin switch to the next receiver
-			receivers.i++
-			if receivers.i < receivers.n {
+			if err != nil {
+				fmt.Println("Malformed Dist proto at link with", link.GetPeerName(), err)
+				lib.ReleaseBuffer(b)
+				return
+			}
+			if packet == nil {
+				// fragment
 				continue
 			}
-			receivers.i = 0
 
+			receivers.recv[distShard(packet.Control, link.IsSendSender(), receivers.n)] <- packet
 		}
 	}()
 
 	// we should make sure if the cache is ready before we start writers
 	<-cacheIsReady
 
-	// run readers/writers for incoming/outgoing messages
+	// run writer routines (encoder). messages are queued
+	// since the peer has been registered
 	for i := 0; i < numHandlers; i++ {
-		// run writer routines (encoder)
-		send := make(chan []etf.Term, opts.SendQueueLength)
-		p.mutex.Lock()
-		p.send[i] = send
-		p.mutex.Unlock()
-		go link.Writer(send, opts.FragmentationUnit)
+		p.writers.Add(1)
+		go func(send chan []etf.Term) {
+			defer p.writers.Done()
+			link.Writer(send, opts.FragmentationUnit, opts.CompressionThreshold)
+		}(p.send[i])
 	}
 
+	n.monitor.NodeUp(p.name, p.hidden)
+	n.connections.connected(p.name)
 	return nil
 }
 
@@ -566,6 +859,7 @@ func (n *Node) applicationStart(startType, appName string, args ...interface{})
 	}
 
 	spec.process = appProcess
+	spec.started = atomic.AddUint64(&n.appStarted, 1)
 	return appProcess, nil
 }
 
@@ -588,6 +882,56 @@ func (n *Node) ApplicationStop(name string) error {
 	return nil
 }
 
+// distControlSender returns the sender of the given control message. It's
+// used as a key for the sharding of the dist messages between the
+// readers/writers of the link. Signals without the sender pid are keyed
+// by the receiver.
+func distControlSender(control etf.Term) etf.Term {
+	t, ok := control.(etf.Tuple)
+	if !ok || len(t) < 3 {
+		return nil
+	}
+
+	switch t.Element(1) {
+	case distProtoSEND, distProtoSEND_TT:
+		// {2, Unused, ToPid}
+		return t.Element(3)
+	case distProtoSPAWN_REQUEST, distProtoSPAWN_REQUEST_TT:
+		// {29, ReqId, From, GroupLeader, {Module, Function, Arity}, OptList}
+		return t.Element(3)
+	case distProtoSPAWN_REPLY, distProtoSPAWN_REPLY_TT:
+		// {31, ReqId, To, Flags, Result}. Result is the pid of the
+		// spawned process which sends the signals after this reply
+		if len(t) > 4 {
+			if pid, ok := t.Element(5).(etf.Pid); ok {
+				return pid
+			}
+		}
+		return t.Element(3)
+	}
+
+	// {Op, From, To, ...}
+	return t.Element(2)
+}
+
+// distIsControlSignal returns false if the given control message is a message
+// sending (SEND, REG_SEND, ALIAS_SEND etc.). Overflow policies can drop them only
+func distIsControlSignal(control etf.Term) bool {
+	t, ok := control.(etf.Tuple)
+	if !ok || len(t) == 0 {
+		return false
+	}
+
+	switch t.Element(1) {
+	case distProtoSEND, distProtoSEND_TT,
+		distProtoREG_SEND, distProtoREG_SEND_TT,
+		distProtoSEND_SENDER, distProtoSEND_SENDER_TT,
+		distProtoALIAS_SEND, distProtoALIAS_SEND_TT:
+		return false
+	}
+	return true
+}
+
 func (n *Node) handleMessage(fromNode string, control, message etf.Term) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -606,11 +950,27 @@ func (n *Node) handleMessage(fromNode string, control, message etf.Term) {
 				// {6, FromPid, Unused, ToName}
 				n.registrar.route(t.Element(2).(etf.Pid), t.Element(4), message)
 
+			case distProtoREG_SEND_TT:
+				// {16, FromPid, Unused, ToName, TraceToken}
+				n.registrar.route(t.Element(2).(etf.Pid), t.Element(4), message)
+
 			case distProtoSEND:
 				// {2, Unused, ToPid}
 				// SEND has no sender pid
 				n.registrar.route(etf.Pid{}, t.Element(3), message)
 
+			case distProtoSEND_TT:
+				// {12, Unused, ToPid, TraceToken}
+				n.registrar.route(etf.Pid{}, t.Element(3), message)
+
+			case distProtoSEND_SENDER:
+				// {22, FromPid, ToPid}
+				n.registrar.route(t.Element(2).(etf.Pid), t.Element(3), message)
+
+			case distProtoSEND_SENDER_TT:
+				// {23, FromPid, ToPid, TraceToken}
+				n.registrar.route(t.Element(2).(etf.Pid), t.Element(3), message)
+
 			case distProtoLINK:
 				// {1, FromPid, ToPid}
 				lib.Log("LINK message (act %d): %#v", act, t)
@@ -628,11 +988,50 @@ func (n *Node) handleMessage(fromNode string, control, message etf.Term) {
 				// {3, FromPid, ToPid, Reason}
 				lib.Log("EXIT message (act %d): %#v", act, t)
 				terminated := t.Element(2).(etf.Pid)
-				reason := fmt.Sprint(t.Element(4))
-				n.monitor.ProcessTerminated(terminated, "", string(reason))
+				to := t.Element(3).(etf.Pid)
+				n.monitor.LinkExit(to, terminated, t.Element(4))
+
+			case distProtoEXIT_TT:
+				// {13, FromPid, ToPid, TraceToken, Reason}
+				lib.Log("EXIT_TT message (act %d): %#v", act, t)
+				terminated := t.Element(2).(etf.Pid)
+				to := t.Element(3).(etf.Pid)
+				n.monitor.LinkExit(to, terminated, t.Element(5))
+
+			case distProtoPAYLOAD_EXIT, distProtoPAYLOAD_EXIT_TT:
+				// {24, FromPid, ToPid}
+				// {25, FromPid, ToPid, TraceToken}
+				// the reason is delivered as a message
+				lib.Log("PAYLOAD_EXIT message (act %d): %#v", act, t)
+				terminated := t.Element(2).(etf.Pid)
+				to := t.Element(3).(etf.Pid)
+				n.monitor.LinkExit(to, terminated, message)
 
 			case distProtoEXIT2:
+				// {8, FromPid, ToPid, Reason}
 				lib.Log("EXIT2 message (act %d): %#v", act, t)
+				from := t.Element(2).(etf.Pid)
+				to := t.Element(3).(etf.Pid)
+				reason := fmt.Sprint(t.Element(4))
+				n.monitor.ExitSignal(to, from, reason)
+
+			case distProtoEXIT2_TT:
+				// {18, FromPid, ToPid, TraceToken, Reason}
+				lib.Log("EXIT2_TT message (act %d): %#v", act, t)
+				from := t.Element(2).(etf.Pid)
+				to := t.Element(3).(etf.Pid)
+				reason := fmt.Sprint(t.Element(5))
+				n.monitor.ExitSignal(to, from, reason)
+
+			case distProtoPAYLOAD_EXIT2, distProtoPAYLOAD_EXIT2_TT:
+				// {26, FromPid, ToPid}
+				// {27, FromPid, ToPid, TraceToken}
+				// the reason is delivered as a message
+				lib.Log("PAYLOAD_EXIT2 message (act %d): %#v", act, t)
+				from := t.Element(2).(etf.Pid)
+				to := t.Element(3).(etf.Pid)
+				reason := fmt.Sprint(message)
+				n.monitor.ExitSignal(to, from, reason)
 
 			case distProtoMONITOR:
 				// {19, FromPid, ToProc, Ref}, where FromPid = monitoring process
@@ -650,24 +1049,44 @@ func (n *Node) handleMessage(fromNode string, control, message etf.Term) {
 				// {21, FromProc, ToPid, Ref, Reason}, where FromProc = monitored process
 				// pid or name (atom), ToPid = monitoring process, and Reason = exit reason for the monitored process
 				lib.Log("MONITOR_EXIT message (act %d): %#v", act, t)
-				reason := fmt.Sprint(t.Element(5))
-				switch terminated := t.Element(2).(type) {
-				case etf.Pid:
-					n.monitor.ProcessTerminated(terminated, "", string(reason))
-				case etf.Atom:
-					pid := fakeMonitorPidFromName(string(terminated), fromNode)
-					n.monitor.ProcessTerminated(pid, "", string(reason))
-				}
+				n.monitorExit(fromNode, t.Element(2), t.Element(5))
 
-			// Not implemented yet, just stubs. TODO.
-			case distProtoSEND_SENDER:
-				lib.Log("SEND_SENDER message (act %d): %#v", act, t)
-			case distProtoPAYLOAD_EXIT:
-				lib.Log("PAYLOAD_EXIT message (act %d): %#v", act, t)
-			case distProtoPAYLOAD_EXIT2:
-				lib.Log("PAYLOAD_EXIT2 message (act %d): %#v", act, t)
 			case distProtoPAYLOAD_MONITOR_P_EXIT:
+				// {28, FromProc, ToPid, Ref}, the same as MONITOR_EXIT but
+				// the reason is delivered as a message
 				lib.Log("PAYLOAD_MONITOR_P_EXIT message (act %d): %#v", act, t)
+				n.monitorExit(fromNode, t.Element(2), message)
+
+			case distProtoSPAWN_REQUEST:
+				// {29, ReqId, From, GroupLeader, {Module, Function, Arity}, OptList}
+				// the list of arguments is delivered as a message
+				lib.Log("SPAWN_REQUEST message (act %d): %#v", act, t)
+				n.handleSpawnRequest(t, message)
+
+			case distProtoSPAWN_REQUEST_TT:
+				// {30, ReqId, From, GroupLeader, {Module, Function, Arity}, OptList, Token}
+				lib.Log("SPAWN_REQUEST_TT message (act %d): %#v", act, t)
+				n.handleSpawnRequest(t, message)
+
+			case distProtoSPAWN_REPLY:
+				// {31, ReqId, To, Flags, Result}
+				lib.Log("SPAWN_REPLY message (act %d): %#v", act, t)
+				n.handleSpawnReply(t)
+
+			case distProtoSPAWN_REPLY_TT:
+				// {32, ReqId, To, Flags, Result, Token}
+				lib.Log("SPAWN_REPLY_TT message (act %d): %#v", act, t)
+				n.handleSpawnReply(t)
+
+			case distProtoALIAS_SEND:
+				// {33, FromPid, Alias}
+				lib.Log("ALIAS_SEND message (act %d): %#v", act, t)
+				n.registrar.route(t.Element(2).(etf.Pid), t.Element(3).(etf.Ref), message)
+
+			case distProtoALIAS_SEND_TT:
+				// {34, FromPid, Alias, Token}
+				lib.Log("ALIAS_SEND_TT message (act %d): %#v", act, t)
+				n.registrar.route(t.Element(2).(etf.Pid), t.Element(3).(etf.Ref), message)
 
 			default:
 				lib.Log("Unhandled node message (act %d): %#v", act, t)
@@ -678,6 +1097,135 @@ func (n *Node) handleMessage(fromNode string, control, message etf.Term) {
 	}
 }
 
+func (n *Node) monitorExit(fromNode string, from etf.Term, reason etf.Term) {
+	switch terminated := from.(type) {
+	case etf.Pid:
+		n.monitor.processTerminated(terminated, "", reason)
+	case etf.Atom:
+		pid := fakeMonitorPidFromName(string(terminated), fromNode)
+		n.monitor.processTerminated(pid, "", reason)
+	}
+}
+
+func (n *Node) handleSpawnRequest(t etf.Tuple, message etf.Term) {
+	ref := t.Element(2).(etf.Ref)
+	from := t.Element(3).(etf.Pid)
+	mfa := t.Element(5).(etf.Tuple)
+	module := mfa.Element(1).(etf.Atom)
+	function := mfa.Element(2).(etf.Atom)
+	arity := mfa.Element(3).(int)
+
+	reply := func(flags int, result etf.Term) {
+		message := etf.Tuple{distProtoSPAWN_REPLY, ref, from, flags, result}
+		n.registrar.routeRaw(from.Node, message)
+	}
+
+	args, ok := message.(etf.List)
+	if !ok || len(args) != arity {
+		reply(0, etf.Atom("badarg"))
+		return
+	}
+
+	factory := n.registrar.GetSpawnFactory(spawnFactoryName(string(module), string(function)))
+	if factory == nil {
+		reply(0, etf.Atom("not_loaded"))
+		return
+	}
+
+	spawnArgs := make([]interface{}, len(args))
+	for i := range args {
+		spawnArgs[i] = args[i]
+	}
+
+	flags := 0
+	opts, _ := t.Element(6).(etf.List)
+	for i := range opts {
+		switch opt := opts[i].(type) {
+		case etf.Atom:
+			switch opt {
+			case etf.Atom("link"):
+				flags |= spawnFlagLink
+			case etf.Atom("monitor"):
+				flags |= spawnFlagMonitor
+			}
+		case etf.Tuple:
+			// {monitor, MonitorOpts}
+			if len(opt) == 2 && opt.Element(1) == etf.Atom("monitor") {
+				flags |= spawnFlagMonitor
+			}
+		}
+	}
+
+	replied := false
+	processOptions := ProcessOptions{}
+	processOptions.spawned = func(process *Process) {
+		// the link and monitor must be established and the reply must be sent
+		// before the process is started. otherwise its exit could be lost
+		if flags&spawnFlagLink > 0 {
+			n.monitor.addLink(from, process.Self())
+		}
+		if flags&spawnFlagMonitor > 0 {
+			n.monitor.addMonitor(from, process.Self(), ref)
+		}
+		reply(flags, process.Self())
+		replied = true
+	}
+
+	if _, err := n.Spawn("", processOptions, factory(), spawnArgs...); err != nil {
+		lib.Log("[%s] can't spawn %s:%s: %s", n.FullName, module, function, err)
+		if !replied {
+			reply(0, etf.Atom("system_limit"))
+		}
+	}
+}
+
+func (n *Node) handleSpawnReply(t etf.Tuple) {
+	ref := t.Element(2).(etf.Ref)
+	to := t.Element(3).(etf.Pid)
+	flags := t.Element(4).(int)
+	result := t.Element(5)
+
+	if pid, ok := result.(etf.Pid); ok {
+		// the link and monitor have been established by the remote side already
+		if flags&spawnFlagLink > 0 {
+			n.monitor.addLink(to, pid)
+		}
+		if flags&spawnFlagMonitor > 0 {
+			n.monitor.addMonitor(to, pid, ref)
+		}
+	}
+
+	p := n.registrar.GetProcessByPid(to)
+	if p == nil {
+		return
+	}
+
+	select {
+	case p.reply <- etf.Tuple{ref, result}:
+	default:
+		lib.Log("[%s] spawn reply %v is dropped: reply queue is full", n.FullName, ref)
+	}
+}
+
+// ProvideRemoteSpawn register given factory under the module/function name.
+// Remote node could spawn a new process using this factory via
+// erlang:spawn(Node, Module, Function, Args) or Process.RemoteSpawn.
+// The list of arguments is passed to the Init callback of the spawned process
+func (n *Node) ProvideRemoteSpawn(module string, function string, factory RemoteSpawnFactory) error {
+	lib.Log("Remote spawn provide: %s:%s", module, function)
+	return n.registrar.RegisterSpawnFactory(spawnFactoryName(module, function), factory)
+}
+
+// RevokeRemoteSpawn unregister factory for the given module/function
+func (n *Node) RevokeRemoteSpawn(module string, function string) error {
+	lib.Log("Remote spawn revoke: %s:%s", module, function)
+	return n.registrar.UnregisterSpawnFactory(spawnFactoryName(module, function))
+}
+
+func spawnFactoryName(module, function string) string {
+	return module + ":" + function
+}
+
 // ProvideRPC register given module/function as RPC method
 func (n *Node) ProvideRPC(module string, function string, fun rpcFunction) error {
 	lib.Log("RPC provide: %s:%s %#v", module, function, fun)
@@ -721,6 +1269,225 @@ func (n *Node) RevokeRPC(module, function string) error {
 	return nil
 }
 
+// MonitorNodes subscribes the local process to the status changes of all the
+// nodes. It receives {nodeup, Node, InfoList} and {nodedown, Node, InfoList} messages.
+// Returns ErrProcessNotLocal if the given process is not a local one
+func (n *Node) MonitorNodes(process etf.Pid, opts MonitorNodesOptions) error {
+	if string(process.Node) != n.FullName {
+		return ErrProcessNotLocal
+	}
+	n.monitor.MonitorNodes(process, opts)
+	return nil
+}
+
+// DemonitorNodes cancels the subscription made by MonitorNodes
+func (n *Node) DemonitorNodes(process etf.Pid) {
+	n.monitor.DemonitorNodes(process)
+}
+
+// Disconnect closes the connection with the given node (in fashion of
+// erlang:disconnect_node/1). Returns ErrUnknown if the node is not connected
+func (n *Node) Disconnect(name string) error {
+	p := n.registrar.GetPeer(name)
+	if p == nil {
+		return ErrUnknown
+	}
+	p.close("disconnect")
+	return nil
+}
+
+// KeepConnected makes the node to keep the connection with the given node.
+// It connects to this node in background and reconnects (with exponential
+// backoff) if the connection has been lost
+func (n *Node) KeepConnected(name string) {
+	n.connections.keepConnected(name, false)
+}
+
+// StopKeepConnected cancels KeepConnected for the given node. The current
+// connection is not closed. The node is kept connected if it has been discovered
+func (n *Node) StopKeepConnected(name string) {
+	n.connections.stopKeepConnected(name, false)
+}
+
+// Registered returns true if the node is registered in EPMD. Otherwise, it
+// returns the reason of the last failed registration attempt. The lost
+// registration is restored in background. It's always false in EPMD-less mode
+func (n *Node) Registered() (bool, error) {
+	if n.resolver != n.epmd {
+		return false, nil
+	}
+	return n.epmd.Registered()
+}
+
+// DiscoveredNodes returns the list of the nodes found by the discovery
+// backends (see NodeOptions.Discovery)
+func (n *Node) DiscoveredNodes() []string {
+	return n.discovery.list()
+}
+
+// PeerStats returns the counters of the send queues of the connected node.
+// Returns ErrUnknown if the node is not connected
+func (n *Node) PeerStats(name string) (PeerStats, error) {
+	p := n.registrar.GetPeer(name)
+	if p == nil {
+		return PeerStats{}, ErrUnknown
+	}
+	return p.stats(), nil
+}
+
+// RejectedConnections returns the number of the connection attempts (incoming
+// and outgoing) refused by the ConnectionPolicy
+func (n *Node) RejectedConnections() uint64 {
+	return atomic.LoadUint64(&n.policy.rejected)
+}
+
+// ReloadTLS reloads the certificates and the CA bundle from the files (TLSmodeStrict).
+// The new connections are using them, established ones are kept. The current
+// certificates are kept if the loading has failed.
+func (n *Node) ReloadTLS() error {
+	if n.tls == nil {
+		return ErrTLSDisabled
+	}
+	return n.tls.load()
+}
+
+// RegisterGlobal associates the name with pid across the connected nodes
+// (in fashion of global:register_name). Returns ErrNameIsTaken if the name is
+// in use or given process is already registered with another global name
+func (n *Node) RegisterGlobal(name string, pid etf.Pid) error {
+	lib.Log("Global register: %s %v", name, pid)
+	return n.globalTransaction(func(p *Process, nodes []string) error {
+		for registered, registeredPid := range n.registrar.GlobalNames() {
+			if registered == name || registeredPid == pid {
+				return ErrNameIsTaken
+			}
+		}
+		message := etf.Tuple{etf.Atom("register"), etf.Atom(name), pid, globalNameMethod}
+		for i, node := range nodes {
+			if !globalIsAvailable(p, node) {
+				continue
+			}
+			reply, err := p.Call(globalServerAt(p, node), message)
+			if err == nil && reply != etf.Atom("yes") {
+				err = fmt.Errorf("malformed request")
+			}
+			if err != nil {
+				// roll back the registration on the nodes it has been made on
+				unregister := etf.Tuple{etf.Atom("unregister"), etf.Atom(name)}
+				for _, node := range nodes[:i] {
+					if globalIsAvailable(p, node) {
+						p.Call(globalServerAt(p, node), unregister)
+					}
+				}
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UnregisterGlobal removes the global name across the connected nodes
+func (n *Node) UnregisterGlobal(name string) error {
+	lib.Log("Global unregister: %s", name)
+	return n.globalTransaction(func(p *Process, nodes []string) error {
+		if _, ok := n.registrar.GetGlobalName(name); !ok {
+			return nil
+		}
+		message := etf.Tuple{etf.Atom("unregister"), etf.Atom(name)}
+		for _, node := range nodes {
+			if !globalIsAvailable(p, node) {
+				continue
+			}
+			reply, err := p.Call(globalServerAt(p, node), message)
+			if err != nil {
+				return err
+			}
+			if reply != etf.Atom("ok") {
+				return fmt.Errorf("malformed request")
+			}
+		}
+		return nil
+	})
+}
+
+// WhereIsGlobal returns pid registered with the given global name.
+// Returns ErrUnknown if the name is not registered
+func (n *Node) WhereIsGlobal(name string) (etf.Pid, error) {
+	if pid, ok := n.registrar.GetGlobalName(name); ok {
+		return pid, nil
+	}
+	return etf.Pid{}, ErrUnknown
+}
+
+// JoinGroup joins the local processes to the group (in fashion of pg:join).
+// Process could join the same group multiple times
+func (n *Node) JoinGroup(group string, pids ...etf.Pid) error {
+	lib.Log("Join group: %s %v", group, pids)
+	for i := range pids {
+		if string(pids[i].Node) != n.FullName {
+			return ErrProcessNotLocal
+		}
+	}
+	scope := n.registrar.GetProcessByName(pgScopeName)
+	if scope == nil {
+		return fmt.Errorf("pg is not running")
+	}
+	message := etf.Tuple{etf.Atom("join_local"), etf.Atom(group), pgList(pids)}
+	v, err := pgCall(n, scope.Self(), message)
+	if err != nil {
+		return err
+	}
+	if v != etf.Atom("ok") {
+		return fmt.Errorf("malformed request")
+	}
+	return nil
+}
+
+// LeaveGroup makes the local processes leave the group (in fashion of pg:leave).
+// Returns ErrGroupNotJoined if none of the given processes joined the group
+func (n *Node) LeaveGroup(group string, pids ...etf.Pid) error {
+	lib.Log("Leave group: %s %v", group, pids)
+	for i := range pids {
+		if string(pids[i].Node) != n.FullName {
+			return ErrProcessNotLocal
+		}
+	}
+	scope := n.registrar.GetProcessByName(pgScopeName)
+	if scope == nil {
+		return fmt.Errorf("pg is not running")
+	}
+	message := etf.Tuple{etf.Atom("leave_local"), etf.Atom(group), pgList(pids)}
+	v, err := pgCall(n, scope.Self(), message)
+	if err != nil {
+		return err
+	}
+	switch v {
+	case etf.Atom("ok"):
+		return nil
+	case etf.Atom("not_joined"):
+		return ErrGroupNotJoined
+	}
+	return fmt.Errorf("malformed request")
+}
+
+// GetGroupMembers returns all the members of the group across the connected nodes
+func (n *Node) GetGroupMembers(group string) []etf.Pid {
+	scope := n.registrar.GetProcessByName(pgScopeName)
+	if scope == nil {
+		return []etf.Pid{}
+	}
+	return scope.object.(*pg).members(group, false)
+}
+
+// GetGroupLocalMembers returns members of the group running on this node
+func (n *Node) GetGroupLocalMembers(group string) []etf.Pid {
+	scope := n.registrar.GetProcessByName(pgScopeName)
+	if scope == nil {
+		return []etf.Pid{}
+	}
+	return scope.object.(*pg).members(group, true)
+}
+
 // GetProcessByName returns Process associated with given name
 func (n *Node) GetProcessByName(name string) *Process {
 	return n.registrar.GetProcessByName(name)
@@ -741,10 +1508,23 @@ func (n *Node) GetPeerList() []string {
 	return n.registrar.PeerList()
 }
 
+// Nodes returns list of connected nodes of the given type (in fashion of
+// erlang:nodes/1). nodeType can be "visible" (default), "hidden" or "all"
+func (n *Node) Nodes(nodeType string) []string {
+	switch nodeType {
+	case "all":
+		return n.registrar.PeerList()
+	case "hidden":
+		return n.registrar.PeerListByType(true)
+	default:
+		return n.registrar.PeerListByType(false)
+	}
+}
+
 // MakeRef returns atomic reference etf.Ref within this node
 func (n *Node) MakeRef() (ref etf.Ref) {
 	ref.Node = etf.Atom(n.FullName)
-	ref.Creation = 1
+	ref.Creation = n.creation
 	nt := atomic.AddInt64(&n.uniqID, 1)
 	id1 := uint32(uint64(nt) & ((2 << 17) - 1))
 	id2 := uint32(uint64(nt) >> 46)
@@ -762,50 +1542,69 @@ func (n *Node) VersionOTP() int {
 }
 
 func (n *Node) connect(to etf.Atom) error {
-	var port int
-	var err error
-	var c net.Conn
-	if port, err = n.epmd.ResolvePort(string(to)); port < 0 {
+	return n.connections.connect(string(to))
+}
+
+func (n *Node) dial(to string) error {
+	if err := n.policy.checkName(to); err != nil {
+		return err
+	}
+
+	port, version, err := n.resolve(to)
+	if port < 0 {
 		return fmt.Errorf("Can't resolve port for %s: %s", to, err)
 	}
-	ns := strings.Split(string(to), "@")
 
-	TLSenabled := false
+	ctx, cancel := context.WithTimeout(n.context, n.opts.ConnectTimeout)
+	defer cancel()
 
-	switch n.opts.TLSmode {
-	case TLSmodeAuto:
-		tlsdialer := tls.Dialer{
-			Config: &tls.Config{
-				Certificates:       []tls.Certificate{n.tlscertClient},
-				InsecureSkipVerify: true,
-			},
-		}
-		c, err = tlsdialer.DialContext(n.context, "tcp", net.JoinHostPort(ns[1], strconv.Itoa(port)))
-		TLSenabled = true
+	c, err := n.opts.Transport.Dial(ctx, to, uint16(port))
+	if err != nil {
+		lib.Log("Error calling Transport.Dial : %s", err.Error())
+		return err
+	}
+	if err := n.policy.checkAddr(to, c.RemoteAddr()); err != nil {
+		c.Close()
+		return err
+	}
 
-	case TLSmodeStrict:
-		tlsdialer := tls.Dialer{
-			Config: &tls.Config{
-				Certificates: []tls.Certificate{n.tlscertClient},
-			},
-		}
-		c, err = tlsdialer.DialContext(n.context, "tcp", net.JoinHostPort(ns[1], strconv.Itoa(port)))
-		TLSenabled = true
+	// the handshake must be done within the connect timeout as well
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
 
-	default:
-		dialer := net.Dialer{}
-		c, err = dialer.DialContext(n.context, "tcp", net.JoinHostPort(ns[1], strconv.Itoa(port)))
+	TLSenabled := false
+	if n.tls != nil {
+		c = n.tls.dial(c, to)
+		TLSenabled = true
 	}
 
-	if err != nil {
-		lib.Log("Error calling net.Dialer.DialerContext : %s", err.Error())
-		return err
+	handshakeOptions := dist.HandshakeOptions{
+		Name:     n.FullName,
+		Cookie:   n.GetNodeCookie(to),
+		TLS:      TLSenabled,
+		Hidden:   n.opts.Hidden,
+		Creation: n.creation,
+		Version:  version,
 	}
-
-	link, e := dist.Handshake(c, TLSenabled, n.FullName, n.Cookie, false)
+	handshakeOptions.Accept = func(name string, hidden bool) error {
+		if n.opts.TLSverifyNodeName {
+			if err := verifyNodeName(c, to); err != nil {
+				return err
+			}
+		}
+		info := ConnectionInfo{
+			Name:   name,
+			Addr:   c.RemoteAddr(),
+			Hidden: hidden,
+		}
+		return n.policy.check(info)
+	}
+	link, e := dist.Handshake(c, handshakeOptions)
 	if e != nil {
+		c.Close()
 		return e
 	}
+	c.SetDeadline(time.Time{})
 
 	if err := n.serve(link, n.opts); err != nil {
 		c.Close()
@@ -814,92 +1613,106 @@ func (n *Node) connect(to etf.Atom) error {
 	return nil
 }
 
-func (n *Node) listen(name string, opts NodeOptions) uint16 {
-	var TLSenabled bool = true
+// listen binds the listener. The incoming connections are accepted
+// once the node is initialized (see accept)
+func (n *Node) listen(name string, opts NodeOptions) (uint16, error) {
+	if opts.TLSmode != TLSmodeDisabled {
+		t, err := createNodeTLS(opts)
+		if err != nil {
+			return 0, err
+		}
+		n.tls = t
+
+		if opts.TLSmode == TLSmodeStrict && opts.TLSreloadInterval > 0 {
+			go t.watch(n.context, opts.TLSreloadInterval)
+		}
+	}
 
-	lc := net.ListenConfig{}
 	for p := opts.ListenRangeBegin; p <= opts.ListenRangeEnd; p++ {
-		l, err := lc.Listen(n.context, "tcp", net.JoinHostPort(name, strconv.Itoa(int(p))))
+		l, err := opts.Transport.Listen(n.context, name, p)
 		if err != nil {
 			continue
 		}
+		if n.tls != nil {
+			l = n.tls.listener(l)
+		}
 
-		switch opts.TLSmode {
-		case TLSmodeAuto:
-			cert, err := generateSelfSignedCert()
-			if err != nil {
-				log.Fatalf("Can't generate certificate: %s\n", err)
-			}
+		n.listener = l
+		go func() {
+			<-n.context.Done()
+			l.Close()
+		}()
 
-			n.tlscertServer = cert
-			n.tlscertClient = cert
+		// return port number this node listenig on for the incoming connections
+		return p, nil
+	}
 
-			TLSconfig := &tls.Config{
-				Certificates:       []tls.Certificate{cert},
-				InsecureSkipVerify: true,
+	// all the ports within a given range are taken
+	return 0, ErrListen
+}
+
+// accept serves the incoming connections. It must be started when the
+// name and the creation of the node are set
+func (n *Node) accept(opts NodeOptions) {
+	l := n.listener
+	TLSenabled := n.tls != nil
+	go func() {
+		for {
+			c, err := l.Accept()
+			if n.IsAlive() == false || atomic.LoadInt32(&n.closing) == 1 {
+				if c != nil {
+					c.Close()
+				}
+				return
 			}
-			l = tls.NewListener(l, TLSconfig)
 
-		case TLSmodeStrict:
-			certServer, err := tls.LoadX509KeyPair(opts.TLScrtServer, opts.TLSkeyServer)
 			if err != nil {
-				log.Fatalf("Can't load server certificate: %s\n", err)
+				lib.Log(err.Error())
+				continue
 			}
-			certClient, err := tls.LoadX509KeyPair(opts.TLScrtServer, opts.TLSkeyServer)
-			if err != nil {
-				log.Fatalf("Can't load client certificate: %s\n", err)
+			lib.Log("Accepted new connection from %s", c.RemoteAddr().String())
+			if err := n.policy.checkAddr(c.RemoteAddr().String(), c.RemoteAddr()); err != nil {
+				c.Close()
+				continue
 			}
 
-			n.tlscertServer = certServer
-			n.tlscertClient = certClient
-
-			TLSconfig := &tls.Config{
-				Certificates: []tls.Certificate{certServer},
-				ServerName:   "localhost",
+			handshakeOptions := dist.HandshakeOptions{
+				Name:       n.FullName,
+				Cookie:     n.GetCookie(),
+				PeerCookie: n.GetNodeCookie,
+				TLS:        TLSenabled,
+				Hidden:     opts.Hidden,
+				Creation:   n.creation,
 			}
-			l = tls.NewListener(l, TLSconfig)
-
-		default:
-			TLSenabled = false
-		}
-
-		go func() {
-			for {
-				c, err := l.Accept()
-				lib.Log("Accepted new connection from %s", c.RemoteAddr().String())
-
-				if n.IsAlive() == false {
-					c.Close()
-					return
-				}
-
-				if err != nil {
-					lib.Log(err.Error())
-					continue
+			handshakeOptions.Accept = func(name string, hidden bool) error {
+				if opts.TLSverifyNodeName {
+					if err := verifyNodeName(c, name); err != nil {
+						return err
+					}
 				}
-
-				link, e := dist.HandshakeAccept(c, TLSenabled, n.FullName, n.Cookie, opts.Hidden)
-				if e != nil {
-					lib.Log("Can't handshake with %s: %s", c.RemoteAddr().String(), e)
-					c.Close()
-					continue
-				}
-
-				// start serving this link
-				if err := n.serve(link, opts); err != nil {
-					lib.Log("Can't serve connection link due to: %s", err)
-					c.Close()
+				info := ConnectionInfo{
+					Name:     name,
+					Addr:     c.RemoteAddr(),
+					Hidden:   hidden,
+					Incoming: true,
 				}
-
+				return n.policy.check(info)
+			}
+			link, e := dist.HandshakeAccept(c, handshakeOptions)
+			if e != nil {
+				lib.Log("Can't handshake with %s: %s", c.RemoteAddr().String(), e)
+				c.Close()
+				continue
 			}
-		}()
 
-		// return port number this node listenig on for the incoming connections
-		return p
-	}
+			// start serving this link
+			if err := n.serve(link, opts); err != nil {
+				lib.Log("Can't serve connection link due to: %s", err)
+				c.Close()
+			}
 
-	// all the ports within a given range are taken
-	return 0
+		}
+	}()
 }
 
 func generateSelfSignedCert() (tls.Certificate, error) {
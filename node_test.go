@@ -1,6 +1,8 @@
 package ergo
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"fmt"
 	"math/rand"
@@ -9,7 +11,9 @@ import (
 	"runtime"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/halturin/ergo/dist"
 	"github.com/halturin/ergo/etf"
 )
 
@@ -25,7 +29,7 @@ func TestNode(t *testing.T) {
 		EPMDPort:         24999,
 	}
 
-	node := CreateNode("node@localhost", "cookies", opts)
+	node, _ := CreateNode("node@localhost", "cookies", opts)
 
 	if conn, err := net.Dial("tcp", ":25001"); err != nil {
 		fmt.Println("Connect to the node' listening port FAILED")
@@ -109,8 +113,8 @@ func TestNodeFragmentation(t *testing.T) {
 	md5 := fmt.Sprint(md5.Sum(blob))
 	message := etf.Tuple{md5, blob}
 
-	node1 := CreateNode("nodeT1Fragmentation@localhost", "secret", NodeOptions{})
-	node2 := CreateNode("nodeT2Fragmentation@localhost", "secret", NodeOptions{})
+	node1, _ := CreateNode("nodeT1Fragmentation@localhost", "secret", NodeOptions{})
+	node2, _ := CreateNode("nodeT2Fragmentation@localhost", "secret", NodeOptions{})
 
 	tgs := &testFragmentationGS{}
 	p1, e1 := node1.Spawn("", ProcessOptions{}, tgs)
@@ -153,10 +157,371 @@ func TestNodeFragmentation(t *testing.T) {
 	wg.Wait()
 }
 
+func TestNodeCompression(t *testing.T) {
+	// compressible data
+	blob := bytes.Repeat([]byte("compression"), 100000)
+	md5 := fmt.Sprint(md5.Sum(blob))
+	message := etf.Tuple{md5, blob}
+
+	opts := NodeOptions{
+		CompressionThreshold: 1024,
+	}
+	node1, _ := CreateNode("nodeT1Compression@localhost", "secret", opts)
+	node2, _ := CreateNode("nodeT2Compression@localhost", "secret", opts)
+
+	tgs := &testFragmentationGS{}
+	p1, e1 := node1.Spawn("", ProcessOptions{}, tgs)
+	p2, e2 := node2.Spawn("", ProcessOptions{}, tgs)
+
+	if e1 != nil {
+		t.Fatal(e1)
+	}
+	if e2 != nil {
+		t.Fatal(e2)
+	}
+
+	for i := 0; i < 10; i++ {
+		check, e := p1.Call(p2.Self(), message)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if check != etf.Atom("ok") {
+			t.Fatal("md5sum mismatch")
+		}
+	}
+
+	node1.Stop()
+	node2.Stop()
+}
+
+func TestNodeDistProtocol(t *testing.T) {
+	fmt.Printf("\n=== Test Node distribution protocol (Erlang/OTP 23 frames)\n")
+	fmt.Printf("Starting node: nodeT1DistProto@localhost: ")
+	node, _ := CreateNode("nodeT1DistProto@localhost", "secret", NodeOptions{})
+	if node == nil {
+		t.Fatal("can't start node")
+	}
+	defer node.Stop()
+	fmt.Println("OK")
+
+	gs := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of distProtoGS on %#v: ", node.FullName)
+	p, _ := node.Spawn("distProtoGS", ProcessOptions{}, gs, nil)
+	waitForResultWithValue(t, gs.v, p.Self())
+
+	remote := etf.Pid{Node: "erl-demo@127.0.0.1", ID: 94, Serial: 0, Creation: 1601632100}
+	link := &dist.Link{}
+	handle := func(frame []byte) {
+		control, message, err := link.ReadDist(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// captured frames have the local pid <0.1000.0>. replace it by the real one
+		c := control.(etf.Tuple)
+		if pid, ok := c.Element(3).(etf.Pid); ok && string(pid.Node) == node.FullName {
+			c[2] = p.Self()
+		}
+		node.handleMessage(string(remote.Node), control, message)
+	}
+
+	// {22, FromPid, ToPid}, hello
+	frameSendSender := []byte{
+		68, 3, 136, 8, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49,
+		50, 55, 46, 48, 46, 48, 46, 49, 11, 25, 110, 111, 100, 101, 84, 49,
+		68, 105, 115, 116, 80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104,
+		111, 115, 116, 12, 5, 104, 101, 108, 108, 111, 104, 3, 97, 22, 88, 82,
+		0, 0, 0, 0, 94, 0, 0, 0, 0, 95, 118, 247, 100, 88, 82, 1,
+		0, 0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 82, 2,
+	}
+	fmt.Printf("... SEND_SENDER: ")
+	handle(frameSendSender)
+	waitForResultWithValue(t, gs.v, etf.Atom("hello"))
+
+	// {23, FromPid, ToPid, TraceToken}, hello
+	frameSendSenderTT := []byte{
+		68, 3, 136, 8, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49,
+		50, 55, 46, 48, 46, 48, 46, 49, 11, 25, 110, 111, 100, 101, 84, 49,
+		68, 105, 115, 116, 80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104,
+		111, 115, 116, 12, 5, 104, 101, 108, 108, 111, 104, 4, 97, 23, 88, 82,
+		0, 0, 0, 0, 94, 0, 0, 0, 0, 95, 118, 247, 100, 88, 82, 1,
+		0, 0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 104, 5, 97, 0,
+		97, 1, 97, 2, 97, 3, 97, 4, 82, 2,
+	}
+	fmt.Printf("... SEND_SENDER_TT: ")
+	handle(frameSendSenderTT)
+	waitForResultWithValue(t, gs.v, etf.Atom("hello"))
+
+	// {12, '', ToPid, TraceToken}, hello
+	frameSendTT := []byte{
+		68, 3, 136, 8, 11, 25, 110, 111, 100, 101, 84, 49, 68, 105, 115, 116,
+		80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104, 111, 115, 116, 13,
+		0, 12, 5, 104, 101, 108, 108, 111, 104, 4, 97, 12, 82, 1, 88, 82,
+		0, 0, 0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 104, 5, 97,
+		0, 97, 1, 97, 2, 97, 3, 97, 4, 82, 2,
+	}
+	fmt.Printf("... SEND_TT: ")
+	handle(frameSendTT)
+	waitForResultWithValue(t, gs.v, etf.Atom("hello"))
+
+	// {16, FromPid, '', distProtoGS, TraceToken}, hello
+	frameRegSendTT := []byte{
+		68, 4, 136, 136, 0, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64,
+		49, 50, 55, 46, 48, 46, 48, 46, 49, 13, 0, 14, 11, 100, 105, 115,
+		116, 80, 114, 111, 116, 111, 71, 83, 12, 5, 104, 101, 108, 108, 111, 104,
+		5, 97, 16, 88, 82, 0, 0, 0, 0, 94, 0, 0, 0, 0, 95, 118,
+		247, 100, 82, 1, 82, 2, 104, 5, 97, 0, 97, 1, 97, 2, 97, 3,
+		97, 4, 82, 3,
+	}
+	fmt.Printf("... REG_SEND_TT: ")
+	handle(frameRegSendTT)
+	waitForResultWithValue(t, gs.v, etf.Atom("hello"))
+
+	p.SetTrapExit(true)
+
+	// {18, FromPid, ToPid, TraceToken, oops}
+	frameExit2TT := []byte{
+		68, 3, 136, 8, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49,
+		50, 55, 46, 48, 46, 48, 46, 49, 11, 25, 110, 111, 100, 101, 84, 49,
+		68, 105, 115, 116, 80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104,
+		111, 115, 116, 15, 4, 111, 111, 112, 115, 104, 5, 97, 18, 88, 82, 0,
+		0, 0, 0, 94, 0, 0, 0, 0, 95, 118, 247, 100, 88, 82, 1, 0,
+		0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 104, 5, 97, 0, 97,
+		1, 97, 2, 97, 3, 97, 4, 82, 2,
+	}
+	fmt.Printf("... EXIT2_TT (trap exit): ")
+	handle(frameExit2TT)
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("EXIT"), remote, etf.Atom("oops")})
+
+	// {26, FromPid, ToPid}, oops
+	framePayloadExit2 := []byte{
+		68, 3, 136, 8, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49,
+		50, 55, 46, 48, 46, 48, 46, 49, 11, 25, 110, 111, 100, 101, 84, 49,
+		68, 105, 115, 116, 80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104,
+		111, 115, 116, 15, 4, 111, 111, 112, 115, 104, 3, 97, 26, 88, 82, 0,
+		0, 0, 0, 94, 0, 0, 0, 0, 95, 118, 247, 100, 88, 82, 1, 0,
+		0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 82, 2,
+	}
+	fmt.Printf("... PAYLOAD_EXIT2 (trap exit): ")
+	handle(framePayloadExit2)
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("EXIT"), remote, etf.Atom("oops")})
+
+	// {24, FromPid, ToPid}, oops
+	framePayloadExit := []byte{
+		68, 3, 136, 8, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49,
+		50, 55, 46, 48, 46, 48, 46, 49, 11, 25, 110, 111, 100, 101, 84, 49,
+		68, 105, 115, 116, 80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104,
+		111, 115, 116, 15, 4, 111, 111, 112, 115, 104, 3, 97, 24, 88, 82, 0,
+		0, 0, 0, 94, 0, 0, 0, 0, 95, 118, 247, 100, 88, 82, 1, 0,
+		0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 82, 2,
+	}
+	fmt.Printf("... PAYLOAD_EXIT (not linked): ")
+	handle(framePayloadExit)
+	waitForTimeout(t, gs.v)
+	fmt.Println("OK")
+
+	fmt.Printf("... PAYLOAD_EXIT (linked, trap exit): ")
+	node.handleMessage(string(remote.Node), etf.Tuple{distProtoLINK, remote, p.Self()}, nil)
+	if links := node.monitor.GetLinks(p.Self()); len(links) != 1 || links[0] != remote {
+		t.Fatal("link hasn't been created", links)
+	}
+	handle(framePayloadExit)
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("EXIT"), remote, etf.Atom("oops")})
+	if links := node.monitor.GetLinks(p.Self()); len(links) != 0 {
+		t.Fatal("link hasn't been removed", links)
+	}
+
+	// {28, FromPid, ToPid, Ref}, oops
+	framePayloadMonitorPExit := []byte{
+		68, 3, 136, 8, 10, 18, 101, 114, 108, 45, 100, 101, 109, 111, 64, 49,
+		50, 55, 46, 48, 46, 48, 46, 49, 11, 25, 110, 111, 100, 101, 84, 49,
+		68, 105, 115, 116, 80, 114, 111, 116, 111, 64, 108, 111, 99, 97, 108, 104,
+		111, 115, 116, 15, 4, 111, 111, 112, 115, 104, 4, 97, 28, 88, 82, 0,
+		0, 0, 0, 94, 0, 0, 0, 0, 95, 118, 247, 100, 88, 82, 1, 0,
+		0, 3, 232, 0, 0, 0, 0, 0, 0, 0, 0, 90, 0, 3, 82, 1,
+		0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3,
+		82, 2,
+	}
+	fmt.Printf("... PAYLOAD_MONITOR_P_EXIT: ")
+	// there is no connection with the remote node, so we create
+	// the monitor manually
+	ref := node.MakeRef()
+	node.monitor.mutexProcesses.Lock()
+	node.monitor.processes[remote] = []monitorItem{
+		monitorItem{pid: p.Self(), process: remote, ref: ref, key: ref.String()},
+	}
+	node.monitor.ref2pid[ref.String()] = remote
+	node.monitor.mutexProcesses.Unlock()
+	handle(framePayloadMonitorPExit)
+	waitForResultWithValue(t, gs.v, etf.Tuple{etf.Atom("DOWN"), ref, etf.Atom("process"), remote, etf.Atom("oops")})
+
+	p.SetTrapExit(false)
+
+	fmt.Printf("... EXIT2 with reason 'normal' (should be ignored): ")
+	node.handleMessage(string(remote.Node), etf.Tuple{distProtoEXIT2, remote, p.Self(), etf.Atom("normal")}, nil)
+	if e := p.WaitWithTimeout(300 * time.Millisecond); e != ErrTimeout {
+		t.Fatal("process shouldn't be stopped")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("... EXIT2 with reason 'kill': ")
+	node.handleMessage(string(remote.Node), etf.Tuple{distProtoEXIT2, remote, p.Self(), etf.Atom("kill")}, nil)
+	if e := p.WaitWithTimeout(300 * time.Millisecond); e != nil {
+		t.Fatal("process should be stopped")
+	}
+	fmt.Println("OK")
+}
+
+type testRemoteSpawnGS struct {
+	GenServer
+	args chan interface{}
+}
+
+func (r *testRemoteSpawnGS) Init(p *Process, args ...interface{}) interface{} {
+	r.args <- args
+	if len(args) > 0 && args[0] == etf.Atom("crash") {
+		panic("crash on init")
+	}
+	return nil
+}
+
+func (r *testRemoteSpawnGS) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+
+func (r *testRemoteSpawnGS) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+func (r *testRemoteSpawnGS) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+func (r *testRemoteSpawnGS) Terminate(reason string, state interface{}) {
+}
+
+func TestNodeRemoteSpawn(t *testing.T) {
+	fmt.Printf("\n=== Test Node remote spawn\n")
+	node1, _ := CreateNode("nodeT1RemoteSpawn@localhost", "secret", NodeOptions{})
+	node2, _ := CreateNode("nodeT2RemoteSpawn@localhost", "secret", NodeOptions{})
+	defer node1.Stop()
+	defer node2.Stop()
+
+	spawnArgs := make(chan interface{}, 2)
+	factory := func() ProcessBehaviour {
+		return &testRemoteSpawnGS{args: spawnArgs}
+	}
+
+	fmt.Printf("    provide remote spawn 'remote:spawn' on %#v: ", node2.FullName)
+	if err := node2.ProvideRemoteSpawn("remote", "spawn", factory); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.ProvideRemoteSpawn("remote", "spawn", factory); err != ErrNameIsTaken {
+		t.Fatal("duplicate registration must fail")
+	}
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	p1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, p1.Self())
+	p1.SetTrapExit(true)
+
+	fmt.Printf("    spawn process on %#v with link and monitor: ", node2.FullName)
+	opts := RemoteSpawnOptions{
+		Link:    true,
+		Monitor: true,
+	}
+	pid, err := p1.RemoteSpawn(node2.FullName, "remote", "spawn", opts, etf.Atom("arg1"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pid.Node) != node2.FullName {
+		t.Fatal("spawned on the wrong node", pid)
+	}
+	waitForResultWithValue(t, spawnArgs, []interface{}{etf.Atom("arg1"), 2})
+
+	fmt.Printf("    check link and monitor: ")
+	if links := node2.monitor.GetLinks(pid); !reflect.DeepEqual(links, []etf.Pid{p1.Self()}) {
+		t.Fatal("link is not established on the remote side", links)
+	}
+	if links := node1.monitor.GetLinks(p1.Self()); !reflect.DeepEqual(links, []etf.Pid{pid}) {
+		t.Fatal("link is not established on the local side", links)
+	}
+	if monitors := node1.monitor.GetMonitors(p1.Self()); !reflect.DeepEqual(monitors, []etf.Pid{pid}) {
+		t.Fatal("monitor is not established", monitors)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    spawn unknown module/function: ")
+	if _, err := p1.RemoteSpawn(node2.FullName, "remote", "unknown", RemoteSpawnOptions{}); err == nil || err.Error() != "not_loaded" {
+		t.Fatal("expected 'not_loaded', got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    spawn process crashing on init. expecting 'EXIT' message: ")
+	crashed, err := p1.RemoteSpawn(node2.FullName, "remote", "spawn", RemoteSpawnOptions{Link: true}, etf.Atom("crash"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-spawnArgs
+	select {
+	case v := <-gs1.v:
+		m, ok := v.(etf.Tuple)
+		if !ok || len(m) != 3 || m.Element(1) != etf.Atom("EXIT") || m.Element(2) != crashed {
+			t.Fatal("unexpected message", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("result timeout")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    stop spawned process. expecting 'DOWN' and 'EXIT' messages: ")
+	node2.GetProcessByPid(pid).Exit(etf.Pid{}, "normal")
+	expected := []etf.Term{
+		etf.Tuple{etf.Atom("DOWN"), etf.Atom("process"), pid, etf.Atom("normal")},
+		etf.Tuple{etf.Atom("EXIT"), pid, etf.Atom("normal")},
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-gs1.v:
+			m := v.(etf.Tuple)
+			if m.Element(1) == etf.Atom("DOWN") {
+				// drop the monitor reference
+				m = etf.Tuple{m[0], m[2], m[3], m[4]}
+			}
+			found := false
+			for k := range expected {
+				if reflect.DeepEqual(m, expected[k]) {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatal("unexpected message", v)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("result timeout")
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    revoke remote spawn 'remote:spawn': ")
+	if err := node2.RevokeRemoteSpawn("remote", "spawn"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p1.RemoteSpawn(node2.FullName, "remote", "spawn", RemoteSpawnOptions{}); err == nil {
+		t.Fatal("expected 'not_loaded' error")
+	}
+	fmt.Println("OK")
+}
+
 func TestNodeAtomCache(t *testing.T) {
 
-	node1 := CreateNode("nodeT1AtomCache@localhost", "secret", NodeOptions{})
-	node2 := CreateNode("nodeT2AtomCache@localhost", "secret", NodeOptions{})
+	node1, _ := CreateNode("nodeT1AtomCache@localhost", "secret", NodeOptions{})
+	node2, _ := CreateNode("nodeT2AtomCache@localhost", "secret", NodeOptions{})
 
 	tgs := &benchGS{}
 	p1, e1 := node1.Spawn("", ProcessOptions{}, tgs)
@@ -187,7 +552,7 @@ func TestNodeStaticRoute(t *testing.T) {
 	nodeName := "nodeT1StaticRoute@localhost"
 	nodeStaticPort := 9876
 
-	node1 := CreateNode(nodeName, "secret", NodeOptions{})
+	node1, _ := CreateNode(nodeName, "secret", NodeOptions{})
 	port1 := node1.ResolvePort(nodeName)
 	if port1 == -1 {
 		t.Fatal("Can't resolve port number for ", nodeName)
@@ -211,6 +576,587 @@ func TestNodeStaticRoute(t *testing.T) {
 	}
 }
 
+func TestNodeDisconnect(t *testing.T) {
+	fmt.Printf("\n=== Test Node Disconnect\n")
+	fmt.Printf("Starting nodes: nodeDisconnect1@localhost, nodeDisconnect2@localhost: ")
+	node1, _ := CreateNode("nodeDisconnect1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeDisconnect2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+	node1.MonitorNodes(node1gs1.Self(), MonitorNodesOptions{NodedownReason: true})
+
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("    wait for nodeup: ")
+	waitForResultWithValue(t, gs1.v, etf.Tuple{etf.Atom("nodeup"), etf.Atom(node2.FullName), etf.List{}})
+
+	fmt.Printf("    disconnect %#v: ", node2.FullName)
+	if err := node1.Disconnect(node2.FullName); err != nil {
+		t.Fatal(err)
+	}
+	reason := etf.Tuple{etf.Atom("nodedown_reason"), etf.Atom("disconnect")}
+	waitForResultWithValue(t, gs1.v, etf.Tuple{etf.Atom("nodedown"), etf.Atom(node2.FullName), etf.List{reason}})
+
+	fmt.Printf("    disconnect unknown node: ")
+	if err := node1.Disconnect(node2.FullName); err != ErrUnknown {
+		t.Fatal("expected", ErrUnknown, "got", err)
+	}
+	fmt.Println("OK")
+}
+
+func TestNodeHidden(t *testing.T) {
+	fmt.Printf("\n=== Test Node Hidden\n")
+	fmt.Printf("Starting nodes: nodeHidden1@localhost, nodeHidden2@localhost, nodeHiddenH@localhost (hidden): ")
+	node1, _ := CreateNode("nodeHidden1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeHidden2@localhost", "cookies", NodeOptions{})
+	nodeH, _ := CreateNode("nodeHiddenH@localhost", "cookies", NodeOptions{Hidden: true})
+	if node1 == nil || node2 == nil || nodeH == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	defer nodeH.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    hidden node connects to %#v: ", node1.FullName)
+	if err := nodeH.connect(etf.Atom(node1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := node1.Nodes("hidden"); len(nodes) != 1 || nodes[0] != nodeH.FullName {
+		t.Fatal("expected hidden node", nodeH.FullName, "got", nodes)
+	}
+	if nodes := node1.Nodes("visible"); len(nodes) != 0 {
+		t.Fatal("expected no visible nodes, got", nodes)
+	}
+	if nodes := nodeH.Nodes("hidden"); len(nodes) != 1 || nodes[0] != node1.FullName {
+		t.Fatal("connection must be hidden on both sides", nodes)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    visible node connects to %#v: ", node1.FullName)
+	if err := node2.connect(etf.Atom(node1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := node1.Nodes(""); len(nodes) != 1 || nodes[0] != node2.FullName {
+		t.Fatal("expected visible node", node2.FullName, "got", nodes)
+	}
+	if nodes := node1.Nodes("all"); len(nodes) != 2 {
+		t.Fatal("expected 2 nodes, got", nodes)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    no transitive connection with the hidden node: ")
+	time.Sleep(200 * time.Millisecond)
+	if nodes := node2.Nodes("all"); len(nodes) != 1 || nodes[0] != node1.FullName {
+		t.Fatal("expected", node1.FullName, "got", nodes)
+	}
+	if nodes := nodeH.Nodes("all"); len(nodes) != 1 {
+		t.Fatal("expected", node1.FullName, "got", nodes)
+	}
+	fmt.Println("OK")
+}
+
+func TestNodeSendQueue(t *testing.T) {
+	fmt.Printf("\n=== Test Node Send Queue\n")
+	testPeer := func(policy SendQueuePolicyType) *peer {
+		return &peer{
+			name:      "nodeSendQueue@localhost",
+			link:      &dist.Link{},
+			send:      []chan []etf.Term{make(chan []etf.Term, 2)},
+			n:         1,
+			policy:    policy,
+			timeout:   50 * time.Millisecond,
+			done:      make(chan struct{}),
+			sendLocks: make([]sync.Mutex, 1),
+		}
+	}
+	fill := func(p *peer) {
+		for i := 0; i < 2; i++ {
+			if err := p.Send([]etf.Term{i}, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fmt.Printf("    block with timeout: ")
+	p := testPeer(SendQueueBlock)
+	fill(p)
+	if err := p.Send([]etf.Term{2}, false); err != ErrSendQueueFull {
+		t.Fatal("expected", ErrSendQueueFull, "got", err)
+	}
+	if stats := p.stats(); stats.Busy != 1 || stats.TimedOut != 1 || stats.SendQueueLength != 2 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    blocked sender is released if the connection is closed: ")
+	errCh := make(chan error)
+	p.timeout = time.Second
+	go func() {
+		errCh <- p.Send([]etf.Term{2}, false)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.closeSend()
+	if err := <-errCh; err != ErrNodeUnreachable {
+		t.Fatal("expected", ErrNodeUnreachable, "got", err)
+	}
+	if err := p.Send([]etf.Term{3}, false); err != ErrNodeUnreachable {
+		t.Fatal("expected", ErrNodeUnreachable, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    drop newest: ")
+	p = testPeer(SendQueueDropNewest)
+	fill(p)
+	if err := p.Send([]etf.Term{2}, false); err != ErrSendQueueFull {
+		t.Fatal("expected", ErrSendQueueFull, "got", err)
+	}
+	if m := <-p.send[0]; m[0] != 0 {
+		t.Fatal("wrong message", m)
+	}
+	if stats := p.stats(); stats.Dropped != 1 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    drop oldest: ")
+	p = testPeer(SendQueueDropOldest)
+	fill(p)
+	if err := p.Send([]etf.Term{2}, false); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-p.send[0]; m[0] != 1 {
+		t.Fatal("wrong message", m)
+	}
+	if stats := p.stats(); stats.Dropped != 1 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    disconnect: ")
+	p = testPeer(SendQueueDisconnect)
+	fill(p)
+	if err := p.Send([]etf.Term{2}, false); err != ErrSendQueueFull {
+		t.Fatal("expected", ErrSendQueueFull, "got", err)
+	}
+	if p.downReason() != "send_queue_overflow" {
+		t.Fatal("wrong reason", p.downReason())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    nosuspend: ")
+	p = testPeer(SendQueueBlock)
+	fill(p)
+	if err := p.Send([]etf.Term{2}, true); err != ErrSendQueueFull {
+		t.Fatal("expected", ErrSendQueueFull, "got", err)
+	}
+	if stats := p.stats(); stats.Busy != 1 || stats.TimedOut != 0 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    control signal is not dropped: ")
+	p = testPeer(SendQueueDropNewest)
+	fill(p)
+	go func() {
+		errCh <- p.sendControl([]etf.Term{2})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	<-p.send[0]
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if m := <-p.send[0]; m[0] != 1 {
+		t.Fatal("wrong message", m)
+	}
+	if m := <-p.send[0]; m[0] != 2 {
+		t.Fatal("wrong message", m)
+	}
+	if stats := p.stats(); stats.Dropped != 0 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    drop oldest keeps control signals: ")
+	p = testPeer(SendQueueDropOldest)
+	link := etf.Tuple{distProtoLINK, etf.Pid{ID: 1}, etf.Pid{ID: 2}}
+	if err := p.sendControl([]etf.Term{link}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Send([]etf.Term{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Send([]etf.Term{2}, false); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-p.send[0]; !reflect.DeepEqual(m[0], link) {
+		t.Fatal("wrong message", m)
+	}
+	if m := <-p.send[0]; m[0] != 2 {
+		t.Fatal("wrong message", m)
+	}
+	if stats := p.stats(); stats.Dropped != 1 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    drop oldest makes room for control signal: ")
+	p = testPeer(SendQueueDropOldest)
+	fill(p)
+	if err := p.sendControl([]etf.Term{link}); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-p.send[0]; m[0] != 1 {
+		t.Fatal("wrong message", m)
+	}
+	if m := <-p.send[0]; !reflect.DeepEqual(m[0], link) {
+		t.Fatal("wrong message", m)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    disconnect if control signal can't be sent: ")
+	p = testPeer(SendQueueBlock)
+	fill(p)
+	if err := p.sendControl([]etf.Term{2}); err != ErrSendQueueFull {
+		t.Fatal("expected", ErrSendQueueFull, "got", err)
+	}
+	if p.downReason() != "send_queue_overflow" {
+		t.Fatal("wrong reason", p.downReason())
+	}
+	if stats := p.stats(); stats.Dropped != 0 || stats.TimedOut != 1 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("Starting nodes: nodeSendQueue1@localhost, nodeSendQueue2@localhost: ")
+	node1, _ := CreateNode("nodeSendQueue1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeSendQueue2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	fmt.Printf("    process.SendNoSuspend (by Pid) local (gs1) -> remote (gs2): ")
+	if err := node1gs1.SendNoSuspend(node2gs2.Self(), etf.Atom("hi")); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gs2.v, etf.Atom("hi"))
+
+	fmt.Printf("    peer stats: ")
+	stats, err := node1.PeerStats(node2.FullName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Busy != 0 || stats.Dropped != 0 || stats.TimedOut != 0 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	if _, err := node1.PeerStats("nodeSendQueueUnknown@localhost"); err != ErrUnknown {
+		t.Fatal("expected", ErrUnknown, "got", err)
+	}
+	fmt.Println("OK")
+}
+
+func TestNodeListenAddress(t *testing.T) {
+	fmt.Printf("\n=== Test Node Listen Address\n")
+	fmt.Printf("Starting node: nodeListenAddress@localhost (listen on 127.0.0.1, advertise port 15999): ")
+	opts := NodeOptions{
+		ListenAddress:    "127.0.0.1",
+		ListenRangeBegin: 25001,
+		AdvertisePort:    15999,
+	}
+	node1, _ := CreateNode("nodeListenAddress@localhost", "cookies", opts)
+	if node1 == nil {
+		t.Fatal("can't start node")
+	}
+	defer node1.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    listener is bound to the given address: ")
+	addr := node1.listener.Addr().(*net.TCPAddr)
+	if !addr.IP.Equal(net.ParseIP("127.0.0.1")) || addr.Port < 25001 {
+		t.Fatal("wrong listen address", addr)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    advertised port is registered in EPMD: ")
+	if port := node1.ResolvePort(node1.FullName); port != 15999 {
+		t.Fatal("expected 15999, got", port)
+	}
+	fmt.Println("OK")
+
+	if l, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		fmt.Println("    IPv6 is not available. skipped")
+		return
+	} else {
+		l.Close()
+	}
+
+	fmt.Printf("Starting nodes: nodeIPv6a@::1, nodeIPv6b@[::1]: ")
+	node2, _ := CreateNode("nodeIPv6a@::1", "cookies", NodeOptions{})
+	node3, _ := CreateNode("nodeIPv6b@[::1]", "cookies", NodeOptions{})
+	if node2 == nil || node3 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node2.Stop()
+	defer node3.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodeIPv6a@::1 -> nodeIPv6b@[::1]: ")
+	if err := node2.connect(etf.Atom(node3.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+}
+
+func TestNodeNetTickTime(t *testing.T) {
+	fmt.Printf("\n=== Test Node Net Tick Time\n")
+	fmt.Printf("Starting nodes: nodeTick1@localhost, nodeTick2@localhost (net tick time 1 second): ")
+	opts := NodeOptions{
+		NetTickTime: 1,
+	}
+	node1, _ := CreateNode("nodeTick1@localhost", "cookies", opts)
+	node2, _ := CreateNode("nodeTick2@localhost", "cookies", opts)
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+	node1.MonitorNodes(node1gs1.Self(), MonitorNodesOptions{NodedownReason: true})
+
+	fmt.Printf("    idle link must be kept alive by ticks: ")
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gs1.v, etf.Tuple{etf.Atom("nodeup"), etf.Atom(node2.FullName), etf.List{}})
+	fmt.Printf("    wait for 2 seconds: ")
+	time.Sleep(2 * time.Second)
+	if node1.registrar.GetPeer(node2.FullName) == nil {
+		t.Fatal("link is closed")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect silent peer: ")
+	port := node1.ResolvePort(node1.FullName)
+	c, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	handshakeOptions := dist.HandshakeOptions{
+		Name:     "nodeTickSilent@localhost",
+		Cookie:   "cookies",
+		Creation: 1,
+		Version:  dist.ProtoHandshake6,
+	}
+	if _, err := dist.Handshake(c, handshakeOptions); err != nil {
+		t.Fatal(err)
+	}
+	// doing nothing with this connection
+	waitForResultWithValue(t, gs1.v, etf.Tuple{etf.Atom("nodeup"), etf.Atom("nodeTickSilent@localhost"), etf.List{}})
+	fmt.Printf("    wait for nodedown: ")
+	reason := etf.Tuple{etf.Atom("nodedown_reason"), etf.Atom("net_tick_timeout")}
+	result := etf.Tuple{etf.Atom("nodedown"), etf.Atom("nodeTickSilent@localhost"), etf.List{reason}}
+	waitForResultWithValue(t, gs1.v, result)
+}
+
+// testOrderingProcess reads the mailbox directly, since GenServer doesn't
+// guarantee the order of handling messages
+type testOrderingProcess struct {
+	v     chan interface{}
+	total int
+}
+
+func (o *testOrderingProcess) Loop(p *Process, args ...interface{}) string {
+	next := make(map[etf.Pid]int)
+	received := 0
+	p.ready <- nil
+
+	for {
+		var message etf.Term
+		select {
+		case ex := <-p.gracefulExit:
+			return ex.reason
+		case <-p.Context.Done():
+			return "kill"
+		case msg := <-p.mailBox:
+			message = msg.Element(2)
+		}
+
+		// {Sender, Seq, Payload}
+		m := message.(etf.Tuple)
+		sender := m.Element(1).(etf.Pid)
+		seq := 0
+		switch s := m.Element(2).(type) {
+		case int:
+			seq = s
+		case int64:
+			seq = int(s)
+		}
+		if next[sender] != seq {
+			o.v <- fmt.Errorf("sender %v: expected seq %d, got %d", sender, next[sender], seq)
+			return "normal"
+		}
+		next[sender]++
+		received++
+		if received == o.total {
+			o.v <- received
+		}
+	}
+}
+
+func TestNodeMessageOrdering(t *testing.T) {
+	fmt.Printf("\n=== Test Node Message Ordering\n")
+	// number of the readers/writers of the link depends on GOMAXPROCS
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	fmt.Printf("Starting nodes: nodeOrder1@localhost, nodeOrder2@localhost: ")
+	node1, _ := CreateNode("nodeOrder1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodeOrder2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	senders := 32
+	messages := 500
+	// every 100th message is fragmented
+	large := make([]byte, 100000)
+
+	receivers := []*testOrderingProcess{}
+	receiverPids := []etf.Pid{}
+	for i := 0; i < 2; i++ {
+		gs := &testOrderingProcess{
+			v:     make(chan interface{}, 2),
+			total: senders * messages,
+		}
+		name := fmt.Sprintf("gsOrder%d", i)
+		p, err := node2.Spawn(name, ProcessOptions{MailboxSize: 65000}, gs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receivers = append(receivers, gs)
+		receiverPids = append(receiverPids, p.Self())
+	}
+
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    %d senders send %d messages to each of 2 remote receivers (by pid and by name): ", senders, messages)
+	wg := sync.WaitGroup{}
+	for i := 0; i < senders; i++ {
+		gs := &testMonitorGenServer{
+			v: make(chan interface{}, 2),
+		}
+		p, err := node1.Spawn("", ProcessOptions{}, gs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(p *Process) {
+			defer wg.Done()
+			for seq := 0; seq < messages; seq++ {
+				payload := etf.Term(etf.Atom("ok"))
+				if seq%100 == 99 {
+					payload = large
+				}
+				message := etf.Tuple{p.Self(), seq, payload}
+				for r := range receiverPids {
+					if seq%2 == 0 {
+						p.Send(receiverPids[r], message)
+						continue
+					}
+					p.Send(etf.Tuple{fmt.Sprintf("gsOrder%d", r), node2.FullName}, message)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	for _, gs := range receivers {
+		select {
+		case v := <-gs.v:
+			if err, ok := v.(error); ok {
+				t.Fatal(err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("result timeout")
+		}
+	}
+	fmt.Println("OK")
+}
+
+func TestNodeDistShard(t *testing.T) {
+	fmt.Printf("\n=== Test Node Dist Shard\n")
+	from := etf.Pid{Node: "nodeShard1@localhost", ID: 1001}
+	to := etf.Pid{Node: "nodeShard2@localhost", ID: 2002}
+	signals := []etf.Term{
+		etf.Tuple{distProtoSEND_SENDER, from, to},
+		etf.Tuple{distProtoREG_SEND, from, etf.Atom(""), etf.Atom("name")},
+		etf.Tuple{distProtoALIAS_SEND, from, etf.Ref{}},
+		etf.Tuple{distProtoLINK, from, to},
+		etf.Tuple{distProtoEXIT, from, to, etf.Atom("normal")},
+		etf.Tuple{distProtoMONITOR, from, to, etf.Ref{}},
+		etf.Tuple{distProtoSPAWN_REPLY, etf.Ref{}, to, 0, from},
+	}
+
+	fmt.Printf("    messages and signals of the same sender use the same reader/writer: ")
+	expected := distShard(signals[0], true, 8)
+	for _, signal := range signals {
+		if i := distShard(signal, true, 8); i != expected {
+			t.Fatal("expected", expected, "got", i, "for", signal)
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    the only reader/writer if the peer doesn't support SEND_SENDER: ")
+	signals = append(signals, etf.Tuple{distProtoSEND, etf.Atom(""), to})
+	for _, signal := range signals {
+		if i := distShard(signal, false, 8); i != 0 {
+			t.Fatal("expected 0, got", i, "for", signal)
+		}
+	}
+	fmt.Println("OK")
+}
+
 type benchGS struct {
 	GenServer
 }
@@ -239,8 +1185,8 @@ func BenchmarkNodeSequential(b *testing.B) {
 
 	node1name := fmt.Sprintf("nodeB1_%d@localhost", b.N)
 	node2name := fmt.Sprintf("nodeB2_%d@localhost", b.N)
-	node1 := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: false})
-	node2 := CreateNode(node2name, "bench", NodeOptions{})
+	node1, _ := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: false})
+	node2, _ := CreateNode(node2name, "bench", NodeOptions{})
 
 	bgs := &benchGS{}
 
@@ -274,7 +1220,7 @@ func BenchmarkNodeSequential(b *testing.B) {
 func BenchmarkNodeSequentialSingleNode(b *testing.B) {
 
 	node1name := fmt.Sprintf("nodeB1Local_%d@localhost", b.N)
-	node1 := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: true})
+	node1, _ := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: true})
 
 	bgs := &benchGS{}
 
@@ -309,8 +1255,8 @@ func BenchmarkNodeParallel(b *testing.B) {
 
 	node1name := fmt.Sprintf("nodeB1Parallel_%d@localhost", b.N)
 	node2name := fmt.Sprintf("nodeB2Parallel_%d@localhost", b.N)
-	node1 := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: false})
-	node2 := CreateNode(node2name, "bench", NodeOptions{})
+	node1, _ := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: false})
+	node2, _ := CreateNode(node2name, "bench", NodeOptions{})
 
 	bgs := &benchGS{}
 
@@ -351,7 +1297,7 @@ func BenchmarkNodeParallel(b *testing.B) {
 func BenchmarkNodeParallelSingleNode(b *testing.B) {
 
 	node1name := fmt.Sprintf("nodeB1ParallelLocal_%d@localhost", b.N)
-	node1 := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: true})
+	node1, _ := CreateNode(node1name, "bench", NodeOptions{DisableHeaderAtomCache: true})
 
 	bgs := &benchGS{}
 
@@ -391,7 +1337,348 @@ func benchCases() []benchCase {
 	return []benchCase{
 		benchCase{"number", 12345},
 		benchCase{"string", "hello world"},
-		benchCase{"tuple (PID)", etf.Pid{Node: "node@localhost", ID: 1, Serial: 1000, Creation: byte(0)}},
+		benchCase{"tuple (PID)", etf.Pid{Node: "node@localhost", ID: 1, Serial: 1000, Creation: 0}},
 		benchCase{"binary 1MB", make([]byte, 1024*1024)},
 	}
 }
+
+func TestNodeEPMDRegistration(t *testing.T) {
+	fmt.Printf("\n=== Test Node EPMD Registration\n")
+	freePort := func() uint16 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		return uint16(l.Addr().(*net.TCPAddr).Port)
+	}
+	port := freePort()
+	serverOptions := dist.ServerOptions{
+		Address:             "127.0.0.1",
+		Port:                port,
+		RelaxedCommandCheck: true,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	killed, err := dist.ServerWithOptions(ctx, serverOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("    no EPMD: ")
+	opts := NodeOptions{
+		EPMDPort:          freePort(),
+		DisableEPMDServer: true,
+	}
+	if node, err := CreateNode("nodeEPMD@localhost", "cookies", opts); err == nil || node != nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    registered: ")
+	opts.EPMDPort = port
+	node1, err := CreateNode("nodeEPMD@localhost", "cookies", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node1.Stop()
+	if registered, err := node1.Registered(); !registered || err != nil {
+		t.Fatal("expected registered, got", registered, err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    duplicate name: ")
+	if _, err := CreateNode("nodeEPMD@localhost", "cookies", opts); err != dist.ErrDuplicateName {
+		t.Fatal("expected", dist.ErrDuplicateName, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    EPMD is killed: ")
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte{0, 1, dist.EPMD_KILL_REQ})
+	conn.Close()
+	<-killed
+	for i := 0; ; i++ {
+		if registered, err := node1.Registered(); !registered && err != nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal("registration must be lost")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    registered again once EPMD is restarted: ")
+	if _, err := dist.ServerWithOptions(ctx, serverOptions); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if registered, _ := node1.Registered(); registered {
+			break
+		}
+		if i == 100 {
+			t.Fatal("node is not registered again")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	epmd := dist.EPMD{PortEMPD: port}
+	if _, _, err := epmd.Resolve(node1.FullName); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    unregistered on stop: ")
+	node1.Stop()
+	for i := 0; ; i++ {
+		if _, _, err := epmd.Resolve(node1.FullName); err != nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal("node is still registered")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	fmt.Println("OK")
+}
+
+type testShutdownApplication struct {
+	Application
+	name     string
+	children []SupervisorChildSpec
+}
+
+func (a *testShutdownApplication) Load(args ...interface{}) (ApplicationSpec, error) {
+	return ApplicationSpec{
+		Name: a.name,
+		Children: []ApplicationChildSpec{
+			ApplicationChildSpec{
+				Child: &testShutdownSupervisor{children: a.children},
+				Name:  a.name + "Sup",
+			},
+		},
+	}, nil
+}
+
+func (a *testShutdownApplication) Start(p *Process, args ...interface{}) {}
+
+type testShutdownSupervisor struct {
+	Supervisor
+	children []SupervisorChildSpec
+}
+
+func (ts *testShutdownSupervisor) Init(args ...interface{}) SupervisorSpec {
+	return SupervisorSpec{
+		Children: ts.children,
+		Strategy: SupervisorStrategy{
+			Type:      SupervisorStrategyOneForOne,
+			Intensity: 10,
+			Period:    5,
+		},
+	}
+}
+
+type testShutdownGenServer struct {
+	GenServer
+	v     chan interface{}
+	block chan struct{}
+}
+
+func (tgs *testShutdownGenServer) Init(p *Process, args ...interface{}) interface{} {
+	return p.Name()
+}
+func (tgs *testShutdownGenServer) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testShutdownGenServer) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", message, state
+}
+func (tgs *testShutdownGenServer) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+func (tgs *testShutdownGenServer) Terminate(reason string, state interface{}) {
+	if tgs.block != nil {
+		<-tgs.block
+	}
+	tgs.v <- fmt.Sprintf("%s:%s", state, reason)
+}
+
+func TestNodeShutdown(t *testing.T) {
+	fmt.Printf("\n=== Test Node Shutdown\n")
+	fmt.Printf("    bad name: ")
+	if node, err := CreateNode("nodeShutdown", "cookies", NodeOptions{}); err == nil || node != nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    no port to listen: ")
+	opts := NodeOptions{
+		ListenRangeBegin: 25501,
+		ListenRangeEnd:   25501,
+	}
+	l, err := net.Listen("tcp", ":25501")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateNode("nodeShutdown@localhost", "cookies", opts); err != ErrListen {
+		t.Fatal("expected", ErrListen, "got", err)
+	}
+	l.Close()
+	fmt.Println("OK")
+
+	fmt.Printf("Starting nodes: nodeShutdown1@localhost, nodeShutdown2@localhost: ")
+	node1, err := CreateNode("nodeShutdown1@localhost", "cookies", NodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node2, err := CreateNode("nodeShutdown2@localhost", "cookies", NodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	terminated := make(chan interface{}, 10)
+	child := func(name string) SupervisorChildSpec {
+		return SupervisorChildSpec{
+			Name:    name,
+			Child:   &testShutdownGenServer{v: terminated},
+			Restart: SupervisorChildRestartPermanent,
+		}
+	}
+	apps := []*testShutdownApplication{
+		&testShutdownApplication{
+			name:     "testShutdownApp1",
+			children: []SupervisorChildSpec{child("testShutdownA1"), child("testShutdownA2")},
+		},
+		&testShutdownApplication{
+			name:     "testShutdownApp2",
+			children: []SupervisorChildSpec{child("testShutdownB1"), child("testShutdownB2")},
+		},
+	}
+	for _, app := range apps {
+		if err := node1.ApplicationLoad(app); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := node1.ApplicationStart(app.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sup := &testShutdownSupervisor{
+		children: []SupervisorChildSpec{child("testShutdownS1"), child("testShutdownS2")},
+	}
+	if _, err := node1.Spawn("testShutdownSup", ProcessOptions{}, sup); err != nil {
+		t.Fatal(err)
+	}
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 1000),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{MailboxSize: 1000}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	fmt.Printf("    shutdown with the queued messages: ")
+	for i := 0; i < 1000; i++ {
+		if err := node1gs1.Send(node2gs2.Self(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := node1.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if node1.IsAlive() {
+		t.Fatal("node must be stopped")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    applications, then top-level supervisors are stopped. children in reverse start order: ")
+	expected := []string{
+		"testShutdownB2:shutdown",
+		"testShutdownB1:shutdown",
+		"testShutdownA2:shutdown",
+		"testShutdownA1:shutdown",
+		"testShutdownS2:shutdown",
+		"testShutdownS1:shutdown",
+	}
+	for _, e := range expected {
+		select {
+		case v := <-terminated:
+			if v != e {
+				t.Fatal("expected", e, "got", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("result timeout")
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    queued messages are delivered: ")
+	received := make(map[interface{}]bool)
+	for len(received) < 1000 {
+		select {
+		case v := <-gs2.v:
+			received[v] = true
+		case <-time.After(time.Second):
+			t.Fatal("result timeout (got", len(received), "messages)")
+		}
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node is unregistered in EPMD: ")
+	epmd := dist.EPMD{PortEMPD: defaultEPMDPort}
+	for i := 0; ; i++ {
+		if _, _, err := epmd.Resolve(node1.FullName); err != nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal("node is still registered")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    shutdown is interrupted by the context: ")
+	node3, err := CreateNode("nodeShutdown3@localhost", "cookies", NodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node3.Stop()
+	block := make(chan struct{})
+	defer close(block)
+	app := &testShutdownApplication{
+		name: "testShutdownApp3",
+		children: []SupervisorChildSpec{
+			SupervisorChildSpec{
+				Name:  "testShutdownC1",
+				Child: &testShutdownGenServer{v: terminated, block: block},
+			},
+		},
+	}
+	if err := node3.ApplicationLoad(app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node3.ApplicationStart(app.name); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := node3.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected", context.DeadlineExceeded, "got", err)
+	}
+	if node3.IsAlive() {
+		t.Fatal("node must be stopped")
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,460 @@
+package ergo
+
+// https://github.com/erlang/otp/blob/master/lib/kernel/src/pg.erl
+
+import (
+	"sync"
+
+	"github.com/halturin/ergo/etf"
+	"github.com/halturin/ergo/lib"
+)
+
+const (
+	pgScopeName = "pg"
+)
+
+// pg implements the default scope of the process groups (OTP 23+). It
+// keeps the group members in the table (ETS table in pg.erl) which is
+// used for reading without making a call to the scope process.
+type pg struct {
+	GenServer
+	process *Process
+
+	mutex  sync.RWMutex
+	groups map[string]*pgGroup
+}
+
+type pgGroup struct {
+	all   []etf.Pid
+	local []etf.Pid
+}
+
+type pgState struct {
+	// local members. process could join the same group
+	// multiple times, so the groups might have duplicates
+	local map[etf.Pid]*pgLocal
+	// scope processes on the remote nodes
+	remote map[etf.Pid]*pgRemote
+}
+
+type pgLocal struct {
+	ref    etf.Ref
+	groups []string
+}
+
+type pgRemote struct {
+	ref    etf.Ref
+	groups map[string][]etf.Pid
+}
+
+// Init initializes process state using arbitrary arguments
+// Init -> state
+func (pgs *pg) Init(p *Process, args ...interface{}) interface{} {
+	lib.Log("PG: Init: %#v", args)
+	pgs.process = p
+
+	pgs.mutex.Lock()
+	pgs.groups = make(map[string]*pgGroup)
+	pgs.mutex.Unlock()
+
+	p.Node.MonitorNodes(p.Self(), MonitorNodesOptions{})
+
+	return &pgState{
+		local:  make(map[etf.Pid]*pgLocal),
+		remote: make(map[etf.Pid]*pgRemote),
+	}
+}
+
+// HandleCast -> ("noreply", state) - noreply
+//		         ("stop", reason) - stop with reason
+func (pgs *pg) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	lib.Log("PG: HandleCast: %#v", message)
+	st := state.(*pgState)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) != 3 || m.Element(1) != etf.Atom("sync") {
+		return "noreply", state
+	}
+
+	// {sync, Peer, [{Group, Pids}]}
+	peer, ok := m.Element(2).(etf.Pid)
+	if !ok {
+		return "noreply", state
+	}
+	groups := make(map[string][]etf.Pid)
+	list, _ := m.Element(3).(etf.List)
+	for i := range list {
+		item, ok := list[i].(etf.Tuple)
+		if !ok || len(item) != 2 {
+			continue
+		}
+		group := atomToString(item.Element(1))
+		if group == "" {
+			continue
+		}
+		groups[group] = append(groups[group], pgPids(item.Element(2))...)
+	}
+	pgs.sync(st, peer, groups)
+
+	return "noreply", state
+}
+
+// HandleCall serves incoming messages sending via gen_server:call
+// HandleCall -> ("reply", message, state) - reply
+//				 ("noreply", _, state) - noreply
+//		         ("stop", reason, _) - normal stop
+func (pgs *pg) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	lib.Log("PG: HandleCall: %#v, From: %#v", message, from)
+	st := state.(*pgState)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) != 3 {
+		return "reply", etf.Atom("badarg"), state
+	}
+
+	group := atomToString(m.Element(2))
+	pids := pgPids(m.Element(3))
+	if group == "" || len(pids) == 0 {
+		return "reply", etf.Atom("badarg"), state
+	}
+	for i := range pids {
+		if string(pids[i].Node) != pgs.process.Node.FullName {
+			return "reply", etf.Atom("badarg"), state
+		}
+	}
+
+	switch m.Element(1) {
+	case etf.Atom("join_local"):
+		// {join_local, Group, PidOrPids}
+		pgs.joinLocal(st, group, pids)
+		return "reply", etf.Atom("ok"), state
+
+	case etf.Atom("leave_local"):
+		// {leave_local, Group, PidOrPids}
+		if !pgs.leaveLocal(st, group, pids) {
+			return "reply", etf.Atom("not_joined"), state
+		}
+		return "reply", etf.Atom("ok"), state
+	}
+
+	return "reply", etf.Atom("badarg"), state
+}
+
+// HandleInfo serves all another incoming messages (Pid ! message)
+// HandleInfo -> ("noreply", state) - noreply
+//		         ("stop", reason) - normal stop
+func (pgs *pg) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	lib.Log("PG: HandleInfo: %#v", message)
+	st := state.(*pgState)
+	m, ok := message.(etf.Tuple)
+	if !ok || len(m) == 0 {
+		return "noreply", state
+	}
+
+	switch m.Element(1) {
+	case etf.Atom("nodeup"):
+		// {nodeup, Node, InfoList}
+		node := atomToString(m.Element(2))
+		discover := etf.Tuple{etf.Atom("discover"), pgs.process.Self()}
+		pgs.process.Send(etf.Tuple{etf.Atom(pgScopeName), etf.Atom(node)}, discover)
+
+	case etf.Atom("discover"):
+		// {discover, Peer}
+		peer, ok := m.Element(2).(etf.Pid)
+		if !ok {
+			return "noreply", state
+		}
+		pgs.process.Cast(peer, etf.Tuple{etf.Atom("sync"), pgs.process.Self(), pgs.localGroups(st)})
+		if _, ok := st.remote[peer]; ok {
+			return "noreply", state
+		}
+		st.remote[peer] = &pgRemote{
+			ref:    pgs.process.MonitorProcess(peer),
+			groups: make(map[string][]etf.Pid),
+		}
+		pgs.process.Send(peer, etf.Tuple{etf.Atom("discover"), pgs.process.Self()})
+
+	case etf.Atom("join"):
+		// {join, Peer, Group, PidOrPids}
+		peer, _ := m.Element(2).(etf.Pid)
+		remote, ok := st.remote[peer]
+		if !ok {
+			return "noreply", state
+		}
+		group := atomToString(m.Element(3))
+		if group == "" {
+			return "noreply", state
+		}
+		pids := pgPids(m.Element(4))
+		remote.groups[group] = append(remote.groups[group], pids...)
+		pgs.add(group, pids, false)
+
+	case etf.Atom("leave"):
+		// {leave, Peer, PidOrPids, Groups}
+		peer, _ := m.Element(2).(etf.Pid)
+		remote, ok := st.remote[peer]
+		if !ok {
+			return "noreply", state
+		}
+		pids := pgPids(m.Element(3))
+		groups, _ := m.Element(4).(etf.List)
+		for i := range groups {
+			group := atomToString(groups[i])
+			if _, ok := remote.groups[group]; !ok {
+				continue
+			}
+			remote.groups[group] = pgRemove(remote.groups[group], pids)
+			if len(remote.groups[group]) == 0 {
+				delete(remote.groups, group)
+			}
+			pgs.remove(group, pids, false)
+		}
+
+	case etf.Atom("DOWN"):
+		// {'DOWN', Ref, process, Pid, Reason}
+		pid, ok := m.Element(4).(etf.Pid)
+		if !ok {
+			return "noreply", state
+		}
+		if local, ok := st.local[pid]; ok {
+			delete(st.local, pid)
+			groups := etf.List{}
+			for _, group := range local.groups {
+				pgs.remove(group, []etf.Pid{pid}, true)
+				groups = append(groups, etf.Atom(group))
+			}
+			pgs.broadcast(st, etf.Tuple{etf.Atom("leave"), pgs.process.Self(), pid, groups})
+			return "noreply", state
+		}
+		if remote, ok := st.remote[pid]; ok {
+			delete(st.remote, pid)
+			for group, pids := range remote.groups {
+				pgs.remove(group, pids, false)
+			}
+		}
+	}
+
+	return "noreply", state
+}
+
+// Terminate called when process died
+func (pgs *pg) Terminate(reason string, state interface{}) {
+	lib.Log("PG: Terminate: %#v", reason)
+}
+
+func (pgs *pg) joinLocal(st *pgState, group string, pids []etf.Pid) {
+	for _, pid := range pids {
+		local, ok := st.local[pid]
+		if !ok {
+			local = &pgLocal{
+				ref: pgs.process.MonitorProcess(pid),
+			}
+			st.local[pid] = local
+		}
+		local.groups = append(local.groups, group)
+	}
+	pgs.add(group, pids, true)
+	pgs.broadcast(st, etf.Tuple{etf.Atom("join"), pgs.process.Self(), etf.Atom(group), pgList(pids)})
+}
+
+func (pgs *pg) leaveLocal(st *pgState, group string, pids []etf.Pid) bool {
+	left := []etf.Pid{}
+	for _, pid := range pids {
+		local, ok := st.local[pid]
+		if !ok {
+			continue
+		}
+		groups := pgRemoveGroup(local.groups, group)
+		if len(groups) == len(local.groups) {
+			// hasn't joined this group
+			continue
+		}
+		left = append(left, pid)
+		if len(groups) == 0 {
+			pgs.process.DemonitorProcess(local.ref)
+			delete(st.local, pid)
+			continue
+		}
+		local.groups = groups
+	}
+
+	if len(left) == 0 {
+		return false
+	}
+
+	pgs.remove(group, left, true)
+	pgs.broadcast(st, etf.Tuple{etf.Atom("leave"), pgs.process.Self(), pgList(left), etf.List{etf.Atom(group)}})
+	return true
+}
+
+// sync replaces the membership of the remote scope process
+func (pgs *pg) sync(st *pgState, peer etf.Pid, groups map[string][]etf.Pid) {
+	remote, ok := st.remote[peer]
+	if !ok {
+		remote = &pgRemote{
+			ref: pgs.process.MonitorProcess(peer),
+		}
+		st.remote[peer] = remote
+	}
+	for group, pids := range remote.groups {
+		pgs.remove(group, pids, false)
+	}
+	for group, pids := range groups {
+		pgs.add(group, pids, false)
+	}
+	remote.groups = groups
+}
+
+func (pgs *pg) localGroups(st *pgState) etf.List {
+	groups := make(map[string][]etf.Pid)
+	for pid, local := range st.local {
+		for _, group := range local.groups {
+			groups[group] = append(groups[group], pid)
+		}
+	}
+	list := etf.List{}
+	for group, pids := range groups {
+		list = append(list, etf.Tuple{etf.Atom(group), pgList(pids)})
+	}
+	return list
+}
+
+func (pgs *pg) broadcast(st *pgState, message etf.Tuple) {
+	for peer := range st.remote {
+		pgs.process.Send(peer, message)
+	}
+}
+
+func (pgs *pg) add(group string, pids []etf.Pid, local bool) {
+	pgs.mutex.Lock()
+	defer pgs.mutex.Unlock()
+
+	g, ok := pgs.groups[group]
+	if !ok {
+		g = &pgGroup{}
+		pgs.groups[group] = g
+	}
+	g.all = append(g.all, pids...)
+	if local {
+		g.local = append(g.local, pids...)
+	}
+}
+
+func (pgs *pg) remove(group string, pids []etf.Pid, local bool) {
+	pgs.mutex.Lock()
+	defer pgs.mutex.Unlock()
+
+	g, ok := pgs.groups[group]
+	if !ok {
+		return
+	}
+	g.all = pgRemove(g.all, pids)
+	if local {
+		g.local = pgRemove(g.local, pids)
+	}
+	if len(g.all) == 0 {
+		delete(pgs.groups, group)
+	}
+}
+
+func (pgs *pg) members(group string, local bool) []etf.Pid {
+	pgs.mutex.RLock()
+	defer pgs.mutex.RUnlock()
+
+	g, ok := pgs.groups[group]
+	if !ok {
+		return []etf.Pid{}
+	}
+	members := g.all
+	if local {
+		members = g.local
+	}
+	return append([]etf.Pid{}, members...)
+}
+
+// pgPids converts Pid or list of Pids into the slice
+func pgPids(term etf.Term) []etf.Pid {
+	switch t := term.(type) {
+	case etf.Pid:
+		return []etf.Pid{t}
+	case etf.List:
+		pids := []etf.Pid{}
+		for i := range t {
+			if pid, ok := t[i].(etf.Pid); ok {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
+	}
+	return nil
+}
+
+func pgList(pids []etf.Pid) etf.List {
+	list := etf.List{}
+	for i := range pids {
+		list = append(list, pids[i])
+	}
+	return list
+}
+
+// pgRemove removes one occurrence of every given pid
+func pgRemove(members []etf.Pid, pids []etf.Pid) []etf.Pid {
+	result := append([]etf.Pid{}, members...)
+	for _, pid := range pids {
+		for i := range result {
+			if result[i] == pid {
+				result = append(result[:i], result[i+1:]...)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func pgRemoveGroup(groups []string, group string) []string {
+	for i := range groups {
+		if groups[i] == group {
+			result := append([]string{}, groups[:i]...)
+			return append(result, groups[i+1:]...)
+		}
+	}
+	return groups
+}
+
+// pgCaller is a temporary process used to make the request to the scope, so
+// the concurrent callers don't share the reply channel
+type pgCaller struct {
+	GenServer
+}
+
+// Init initializes process state using arbitrary arguments
+func (pc *pgCaller) Init(p *Process, args ...interface{}) interface{} {
+	return nil
+}
+
+// HandleCast serves incoming messages sending via gen_server:cast
+func (pc *pgCaller) HandleCast(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+// HandleCall serves incoming messages sending via gen_server:call
+func (pc *pgCaller) HandleCall(from etf.Tuple, message etf.Term, state interface{}) (string, etf.Term, interface{}) {
+	return "reply", etf.Atom("ok"), state
+}
+
+// HandleInfo serves all another incoming messages (Pid ! message)
+func (pc *pgCaller) HandleInfo(message etf.Term, state interface{}) (string, interface{}) {
+	return "noreply", state
+}
+
+// Terminate called when process died
+func (pc *pgCaller) Terminate(reason string, state interface{}) {
+}
+
+// pgCall makes the request to the scope from the temporary process
+func pgCall(n *Node, scope etf.Pid, message etf.Term) (etf.Term, error) {
+	p, err := n.Spawn("", ProcessOptions{}, &pgCaller{})
+	if err != nil {
+		return nil, err
+	}
+	defer p.Exit(p.Self(), "normal")
+	return p.Call(scope, message)
+}
@@ -0,0 +1,163 @@
+package ergo
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestProcessGroups(t *testing.T) {
+	fmt.Printf("\n=== Test Process Groups (pg)\n")
+	fmt.Printf("Starting nodes: nodePG1@localhost, nodePG2@localhost: ")
+	node1, _ := CreateNode("nodePG1@localhost", "cookies", NodeOptions{})
+	node2, _ := CreateNode("nodePG2@localhost", "cookies", NodeOptions{})
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs3 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node1.FullName)
+	node1gs2, _ := node1.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node1gs2.Self())
+
+	fmt.Printf("    wait for start of gs3 on %#v: ", node2.FullName)
+	node2gs3, _ := node2.Spawn("gs3", ProcessOptions{}, gs3, nil)
+	waitForResultWithValue(t, gs3.v, node2gs3.Self())
+
+	fmt.Printf("    join gs1 and gs2 to the group before the nodes are connected: ")
+	if err := node1.JoinGroup("group", node1gs1.Self(), node1gs2.Self()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []etf.Pid{node1gs1.Self(), node1gs2.Self()}
+	if members := node1.GetGroupLocalMembers("group"); !reflect.DeepEqual(members, expected) {
+		t.Fatal("expected", expected, "got", members)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    join remote process: ")
+	if err := node1.JoinGroup("group", node2gs3.Self()); err != ErrProcessNotLocal {
+		t.Fatal("expected", ErrProcessNotLocal, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    concurrent join/leave before the nodes are connected: ")
+	// keep the number of the concurrent requests below the mailbox size
+	errors := make(chan error, 50)
+	for i := 0; i < cap(errors); i++ {
+		go func() {
+			for k := 0; k < 20; k++ {
+				if err := node1.JoinGroup("concurrent", node1gs2.Self()); err != nil {
+					errors <- err
+					return
+				}
+				if err := node1.LeaveGroup("concurrent", node1gs2.Self()); err != nil {
+					errors <- err
+					return
+				}
+			}
+			errors <- nil
+		}()
+	}
+	for i := 0; i < cap(errors); i++ {
+		select {
+		case err := <-errors:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("result timeout")
+		}
+	}
+	if members := node1.GetGroupLocalMembers("concurrent"); len(members) != 0 {
+		t.Fatal("expected empty group, got", members)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    connect nodes and wait for synchronization: ")
+	if err := node1.connect(etf.Atom(node2.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	waitForGroupMembers(t, node2, "group", node1gs1.Self(), node1gs2.Self())
+
+	fmt.Printf("    join gs3 to the group on %#v: ", node2.FullName)
+	if err := node2.JoinGroup("group", node2gs3.Self()); err != nil {
+		t.Fatal(err)
+	}
+	waitForGroupMembers(t, node1, "group", node1gs1.Self(), node1gs2.Self(), node2gs3.Self())
+	expected = []etf.Pid{node2gs3.Self()}
+	if members := node2.GetGroupLocalMembers("group"); !reflect.DeepEqual(members, expected) {
+		t.Fatal("expected", expected, "got", members)
+	}
+
+	fmt.Printf("    leave the group (gs2): ")
+	if err := node1.LeaveGroup("group", node1gs2.Self()); err != nil {
+		t.Fatal(err)
+	}
+	waitForGroupMembers(t, node2, "group", node1gs1.Self(), node2gs3.Self())
+
+	fmt.Printf("    leave the group once again (gs2): ")
+	if err := node1.LeaveGroup("group", node1gs2.Self()); err != ErrGroupNotJoined {
+		t.Fatal("expected", ErrGroupNotJoined, "got", err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    terminate member of the group (gs1): ")
+	node1gs1.Exit(etf.Pid{}, "normal")
+	waitForGroupMembers(t, node2, "group", node2gs3.Self())
+
+	fmt.Printf("    stop node %#v: ", node2.FullName)
+	node2.Stop()
+	waitForGroupMembers(t, node1, "group")
+}
+
+func waitForGroupMembers(t *testing.T, node *Node, group string, expected ...etf.Pid) {
+	timeout := time.After(3 * time.Second)
+	for {
+		members := node.GetGroupMembers(group)
+		if groupMembersEqual(members, expected) {
+			fmt.Println("OK")
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatal("group", group, "expected", expected, "got", members)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func groupMembersEqual(members, expected []etf.Pid) bool {
+	if len(members) != len(expected) {
+		return false
+	}
+	for _, pid := range expected {
+		found := false
+		for i := range members {
+			if members[i] == pid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package ergo
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sync/atomic"
+
+	"github.com/halturin/ergo/lib"
+)
+
+// ConnectionPolicy defines which nodes are allowed to be connected with. It's
+// applied to the incoming (accept) and outgoing (dial) connections. The deny
+// rules are checked first, then the allow ones and the Check callback at last.
+type ConnectionPolicy struct {
+	// AllowNames is a list of the node name patterns (path.Match syntax, for
+	// example "prod*@*"). Any name is allowed if it's empty
+	AllowNames []string
+	// DenyNames is a list of the node name patterns to refuse
+	DenyNames []string
+	// AllowNetworks is a list of IP addresses or CIDRs (like "10.0.0.0/8").
+	// Any address is allowed if it's empty. Connections without IP address
+	// (unix socket, in-memory pipe) are refused if it's not empty
+	AllowNetworks []string
+	// DenyNetworks is a list of IP addresses or CIDRs to refuse
+	DenyNetworks []string
+	// DenyHidden refuses the hidden nodes
+	DenyHidden bool
+	// DenyVisible refuses the visible (not hidden) nodes
+	DenyVisible bool
+	// Check is called for the custom decision. Connection is refused
+	// if it returns an error
+	Check func(info ConnectionInfo) error
+}
+
+// ConnectionInfo describes the peer for the ConnectionPolicy
+type ConnectionInfo struct {
+	Name     string
+	Addr     net.Addr
+	Hidden   bool
+	Incoming bool
+}
+
+type connectionPolicy struct {
+	policy        ConnectionPolicy
+	allowNetworks []*net.IPNet
+	denyNetworks  []*net.IPNet
+	rejected      uint64
+}
+
+func createConnectionPolicy(policy ConnectionPolicy) (*connectionPolicy, error) {
+	var err error
+
+	p := &connectionPolicy{
+		policy: policy,
+	}
+	for _, patterns := range [][]string{policy.AllowNames, policy.DenyNames} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Wrong node name pattern %q: %s", pattern, err)
+			}
+		}
+	}
+	if p.allowNetworks, err = parseNetworks(policy.AllowNetworks); err != nil {
+		return nil, err
+	}
+	if p.denyNetworks, err = parseNetworks(policy.DenyNetworks); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkName is used before dialing the node. The rest of the rules are
+// checked once the name and the flags of the peer are received (handshake)
+func (p *connectionPolicy) checkName(name string) error {
+	if matchName(p.policy.DenyNames, name) {
+		return p.reject(name, "name is denied")
+	}
+	if len(p.policy.AllowNames) > 0 && !matchName(p.policy.AllowNames, name) {
+		return p.reject(name, "name is not allowed")
+	}
+	return nil
+}
+
+// checkAddr is used before the handshake is started
+func (p *connectionPolicy) checkAddr(name string, addr net.Addr) error {
+	var ip net.IP
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+	}
+	if ip != nil && matchNetwork(p.denyNetworks, ip) {
+		return p.reject(name, fmt.Sprintf("address %s is denied", ip))
+	}
+	if len(p.allowNetworks) > 0 && (ip == nil || !matchNetwork(p.allowNetworks, ip)) {
+		return p.reject(name, fmt.Sprintf("address %s is not allowed", addr))
+	}
+	return nil
+}
+
+func (p *connectionPolicy) check(info ConnectionInfo) error {
+	if err := p.checkName(info.Name); err != nil {
+		return err
+	}
+	if err := p.checkAddr(info.Name, info.Addr); err != nil {
+		return err
+	}
+
+	if info.Hidden && p.policy.DenyHidden {
+		return p.reject(info.Name, "hidden nodes are denied")
+	}
+	if !info.Hidden && p.policy.DenyVisible {
+		return p.reject(info.Name, "visible nodes are denied")
+	}
+
+	if p.policy.Check != nil {
+		if err := p.policy.Check(info); err != nil {
+			atomic.AddUint64(&p.rejected, 1)
+			lib.Log("Connection with %s is refused: %s", info.Name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *connectionPolicy) reject(name string, reason string) error {
+	atomic.AddUint64(&p.rejected, 1)
+	lib.Log("Connection with %s is refused: %s", name, reason)
+	return ErrConnectionRefused
+}
+
+func matchName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchNetwork(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	var parsed []*net.IPNet
+	for _, network := range networks {
+		if ip := net.ParseIP(network); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			parsed = append(parsed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("Wrong network %q: %s", network, err)
+		}
+		parsed = append(parsed, ipnet)
+	}
+	return parsed, nil
+}
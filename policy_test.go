@@ -0,0 +1,147 @@
+package ergo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestConnectionPolicy(t *testing.T) {
+	fmt.Printf("\n=== Test Connection Policy\n")
+	errCustom := fmt.Errorf("custom")
+	fmt.Printf("Starting nodes: nodePolicyProd1@localhost, nodePolicyProd2@localhost, nodePolicyDev@localhost: ")
+	opts := NodeOptions{
+		Policy: ConnectionPolicy{
+			DenyNames:  []string{"nodePolicyDev*@*"},
+			DenyHidden: true,
+			Check: func(info ConnectionInfo) error {
+				if info.Name == "nodePolicyCustom@localhost" && info.Incoming {
+					return errCustom
+				}
+				return nil
+			},
+		},
+	}
+	prod1, _ := CreateNode("nodePolicyProd1@localhost", "cookies", opts)
+	prod2, _ := CreateNode("nodePolicyProd2@localhost", "cookies", NodeOptions{})
+	dev, _ := CreateNode("nodePolicyDev@localhost", "cookies", NodeOptions{})
+	if prod1 == nil || prod2 == nil || dev == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer prod1.Stop()
+	defer prod2.Stop()
+	defer dev.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    allowed node connects: ")
+	if err := prod2.connect(etf.Atom(prod1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    denied by name (incoming): ")
+	if err := dev.connect(etf.Atom(prod1.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if prod1.RejectedConnections() != 1 {
+		t.Fatal("expected 1 rejected, got", prod1.RejectedConnections())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    denied by name (outgoing): ")
+	if err := prod1.connect(etf.Atom(dev.FullName)); err != ErrConnectionRefused {
+		t.Fatal("expected", ErrConnectionRefused, "got", err)
+	}
+	if prod1.RejectedConnections() != 2 {
+		t.Fatal("expected 2 rejected, got", prod1.RejectedConnections())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    visible node is denied: ")
+	hiddenOnly, _ := CreateNode("nodePolicyHiddenOnly@localhost", "cookies",
+		NodeOptions{Policy: ConnectionPolicy{DenyVisible: true}})
+	if hiddenOnly == nil {
+		t.Fatal("can't start node")
+	}
+	defer hiddenOnly.Stop()
+	if err := prod2.connect(etf.Atom(hiddenOnly.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if hiddenOnly.RejectedConnections() != 1 {
+		t.Fatal("expected 1 rejected, got", hiddenOnly.RejectedConnections())
+	}
+	if err := hiddenOnly.connect(etf.Atom(prod2.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if hiddenOnly.RejectedConnections() != 2 {
+		t.Fatal("expected 2 rejected, got", hiddenOnly.RejectedConnections())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    hidden node is denied: ")
+	hidden, _ := CreateNode("nodePolicyHidden@localhost", "cookies", NodeOptions{Hidden: true})
+	if hidden == nil {
+		t.Fatal("can't start node")
+	}
+	defer hidden.Stop()
+	if err := hidden.connect(etf.Atom(prod1.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if prod1.RejectedConnections() != 3 {
+		t.Fatal("expected 3 rejected, got", prod1.RejectedConnections())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    denied by the custom check: ")
+	custom, _ := CreateNode("nodePolicyCustom@localhost", "cookies", NodeOptions{})
+	if custom == nil {
+		t.Fatal("can't start node")
+	}
+	defer custom.Stop()
+	if err := custom.connect(etf.Atom(prod1.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if prod1.RejectedConnections() != 4 {
+		t.Fatal("expected 4 rejected, got", prod1.RejectedConnections())
+	}
+	if err := prod1.connect(etf.Atom(custom.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    denied by the network: ")
+	opts = NodeOptions{
+		Policy: ConnectionPolicy{
+			AllowNetworks: []string{"10.0.0.0/8", "::1"},
+			DenyNetworks:  []string{"127.0.0.2"},
+		},
+	}
+	network, _ := CreateNode("nodePolicyNetwork@localhost", "cookies", opts)
+	if network == nil {
+		t.Fatal("can't start node")
+	}
+	defer network.Stop()
+	if err := prod2.connect(etf.Atom(network.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if network.RejectedConnections() != 1 {
+		t.Fatal("expected 1 rejected, got", network.RejectedConnections())
+	}
+	if err := network.connect(etf.Atom(prod2.FullName)); err == nil {
+		t.Fatal("connection must be refused")
+	}
+	if network.RejectedConnections() != 2 {
+		t.Fatal("expected 2 rejected, got", network.RejectedConnections())
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    wrong policy: ")
+	if _, err := createConnectionPolicy(ConnectionPolicy{AllowNetworks: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := createConnectionPolicy(ConnectionPolicy{DenyNames: []string{"node["}}); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+}
@@ -42,7 +42,11 @@ type Process struct {
 	reductions      uint64 // we use this term to count total number of processed messages from mailBox
 	currentFunction string
 
-	trapExit bool
+	trapExit    bool
+	strictOrder bool
+
+	// exitSignal delivers the exit signal with the reason term
+	exitSignal func(from etf.Pid, reason etf.Term)
 }
 
 type directMessage struct {
@@ -76,7 +80,34 @@ type ProcessInfo struct {
 type ProcessOptions struct {
 	MailboxSize uint16
 	GroupLeader *Process
+	// StrictOrder makes GenServer handle the messages one by one in the
+	// mailbox order within the process goroutine
+	StrictOrder bool
 	parent      *Process
+	// spawned is called once the process is registered, before it's started
+	spawned func(p *Process)
+}
+
+// RemoteSpawnOptions defines options for the Process.RemoteSpawn
+type RemoteSpawnOptions struct {
+	// Link creates a link between the calling process and the spawned one
+	Link bool
+	// Monitor creates a monitor of the spawned process
+	Monitor bool
+	// Timeout in seconds. DefaultCallTimeout is used if it's zero
+	Timeout int
+}
+
+// ProcessPanic is the exit reason of the process terminated by panic within
+// its callback. Links and monitors get it as {panic, Value, Stack}
+type ProcessPanic struct {
+	Value interface{}
+	Stack string
+}
+
+// Term returns the exit reason term {panic, Value, Stack}
+func (pp ProcessPanic) Term() etf.Term {
+	return etf.Tuple{etf.Atom("panic"), fmt.Sprint(pp.Value), pp.Stack}
 }
 
 // ProcessExitFunc initiate a graceful stopping process
@@ -109,14 +140,14 @@ func (p *Process) Info() ProcessInfo {
 	return ProcessInfo{
 		PID:             p.self,
 		Name:            p.name,
-		CurrentFunction: p.currentFunction,
+		CurrentFunction: p.getCurrentFunction(),
 		GroupLeader:     gl,
 		Links:           links,
 		Monitors:        monitors,
 		MonitoredBy:     monitoredBy,
 		Status:          "running",
 		MessageQueueLen: len(p.mailBox),
-		TrapExit:        p.trapExit,
+		TrapExit:        p.GetTrapExit(),
 		Reductions:      p.reductions,
 	}
 }
@@ -131,10 +162,19 @@ func (p *Process) Call(to interface{}, message etf.Term) (etf.Term, error) {
 func (p *Process) CallWithTimeout(to interface{}, message etf.Term, timeout int) (etf.Term, error) {
 	var timer *time.Timer
 
-	ref := p.Node.MakeRef()
-	from := etf.Tuple{p.self, ref}
+	// the reply is expected to be sent to the alias. it is
+	// deactivated on return so the late reply is dropped
+	ref, err := p.CreateAlias()
+	if err != nil {
+		return nil, err
+	}
+	defer p.DeleteAlias(ref)
+
+	from := etf.Tuple{p.self, etf.ListImproper{etf.Atom("alias"), ref}}
 	msg := etf.Term(etf.Tuple{etf.Atom("$gen_call"), from, message})
-	p.Send(to, msg)
+	if err := p.Send(to, msg); err != nil {
+		return nil, err
+	}
 
 	timer = lib.TakeTimer()
 	defer lib.ReleaseTimer(timer)
@@ -143,10 +183,10 @@ func (p *Process) CallWithTimeout(to interface{}, message etf.Term, timeout int)
 	for {
 		select {
 		case m := <-p.reply:
-			ref1 := m[0].(etf.Ref)
+			ref1, _ := replyRef(m[0])
 			val := m[1].(etf.Term)
 			// check message Ref
-			if len(ref.ID) == 3 && ref.ID[0] == ref1.ID[0] && ref.ID[1] == ref1.ID[1] && ref.ID[2] == ref1.ID[2] {
+			if len(ref.ID) == 3 && len(ref1.ID) == 3 && ref.ID[0] == ref1.ID[0] && ref.ID[1] == ref1.ID[1] && ref.ID[2] == ref1.ID[2] {
 				return val, nil
 			}
 			// ignore this message. waiting for the next one
@@ -190,10 +230,90 @@ func (p *Process) CastRPC(node, module, function string, args ...etf.Term) {
 	p.Cast(to, message)
 }
 
+// RemoteSpawn makes request to the remote node to spawn a new process using
+// the factory registered there under the module/function name
+// (see Node.ProvideRemoteSpawn). With opts.Monitor enabled the 'DOWN'
+// message of this monitor comes with the reference of the spawn request
+func (p *Process) RemoteSpawn(node, module, function string, opts RemoteSpawnOptions, args ...etf.Term) (etf.Pid, error) {
+	lib.Log("[%s] Remote spawn: %s:%s:%s", p.Node.FullName, node, module, function)
+	var optlist etf.List
+
+	if opts.Link {
+		optlist = append(optlist, etf.Atom("link"))
+	}
+	if opts.Monitor {
+		optlist = append(optlist, etf.Atom("monitor"))
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultCallTimeout
+	}
+
+	ref := p.Node.MakeRef()
+	mfa := etf.Tuple{etf.Atom(module), etf.Atom(function), len(args)}
+	control := etf.Tuple{distProtoSPAWN_REQUEST, ref, p.self, p.self, mfa, optlist}
+	if err := p.Node.registrar.routeRaw(etf.Atom(node), control, etf.List(args)); err != nil {
+		return etf.Pid{}, err
+	}
+
+	timer := lib.TakeTimer()
+	defer lib.ReleaseTimer(timer)
+	timer.Reset(time.Second * time.Duration(timeout))
+
+	for {
+		select {
+		case m := <-p.reply:
+			ref1, _ := replyRef(m[0])
+			val := m[1].(etf.Term)
+			// check message Ref
+			if len(ref.ID) == 3 && len(ref1.ID) == 3 && ref.ID[0] == ref1.ID[0] && ref.ID[1] == ref1.ID[1] && ref.ID[2] == ref1.ID[2] {
+				if pid, ok := val.(etf.Pid); ok {
+					return pid, nil
+				}
+				return etf.Pid{}, fmt.Errorf("%s", val)
+			}
+			// ignore this message. waiting for the next one
+		case <-timer.C:
+			return etf.Pid{}, fmt.Errorf("timeout")
+		case <-p.Context.Done():
+			return etf.Pid{}, fmt.Errorf("stopped")
+		}
+	}
+}
+
+// CreateAlias creates a new alias for the process. Messages sent to the alias
+// are delivered to this process as long as the alias is active
+func (p *Process) CreateAlias() (etf.Ref, error) {
+	if !p.IsAlive() {
+		return etf.Ref{}, ErrProcessTerminated
+	}
+	alias := p.Node.MakeRef()
+	if err := p.Node.registrar.RegisterAlias(alias, p); err != nil {
+		return etf.Ref{}, err
+	}
+	return alias, nil
+}
+
+// DeleteAlias deactivates the alias. Messages sent to this alias are dropped
+// after that. Only the process created this alias is able to deactivate it
+func (p *Process) DeleteAlias(alias etf.Ref) error {
+	return p.Node.registrar.UnregisterAlias(alias, p)
+}
+
 // Send sends a message. 'to' can be a Pid, registered local name
-// or a tuple {RegisteredName, NodeName}
-func (p *Process) Send(to interface{}, message etf.Term) {
-	p.Node.registrar.route(p.self, to, message)
+// or a tuple {RegisteredName, NodeName}. Returns ErrNodeUnreachable
+// if the remote node can't be connected or ErrSendQueueFull if the send
+// queue of the remote node is full (see SendQueuePolicy option)
+func (p *Process) Send(to interface{}, message etf.Term) error {
+	return p.Node.registrar.route(p.self, to, message)
+}
+
+// SendNoSuspend sends a message like Send does (in fashion of erlang:send/3 with
+// nosuspend option), but returns ErrSendQueueFull at once if the send queue
+// of the remote node is full. The overflow policy is not applied
+func (p *Process) SendNoSuspend(to interface{}, message etf.Term) error {
+	return p.Node.registrar.routeNoSuspend(p.self, to, message)
 }
 
 // SendAfter starts a timer. When the timer expires, the message sends to the process identified by 'to'.
@@ -227,9 +347,9 @@ func (p *Process) CastAfter(to interface{}, message etf.Term, after time.Duratio
 // Cast sends a message in fashion of 'gen_cast'.
 // 'to' can be a Pid, registered local name
 // or a tuple {RegisteredName, NodeName}
-func (p *Process) Cast(to interface{}, message etf.Term) {
+func (p *Process) Cast(to interface{}, message etf.Term) error {
 	msg := etf.Term(etf.Tuple{etf.Atom("$gen_cast"), message})
-	p.Node.registrar.route(p.self, to, msg)
+	return p.Node.registrar.route(p.self, to, msg)
 }
 
 // MonitorProcess creates monitor between the processes.
@@ -363,14 +483,36 @@ func (p *Process) GetState() string {
 
 // SetTrapExit enables/disables the trap on terminate process
 func (p *Process) SetTrapExit(trap bool) {
+	p.Lock()
+	defer p.Unlock()
 	p.trapExit = trap
 }
 
 // GetTrapExit returns whether the trap was enabled on this process
 func (p *Process) GetTrapExit() bool {
+	p.RLock()
+	defer p.RUnlock()
 	return p.trapExit
 }
 
+func (p *Process) setState(state interface{}) {
+	p.Lock()
+	defer p.Unlock()
+	p.state = state
+}
+
+func (p *Process) setCurrentFunction(name string) {
+	p.Lock()
+	defer p.Unlock()
+	p.currentFunction = name
+}
+
+func (p *Process) getCurrentFunction() string {
+	p.RLock()
+	defer p.RUnlock()
+	return p.currentFunction
+}
+
 func (p *Process) directRequest(id string, request interface{}) (interface{}, error) {
 	reply := make(chan directMessage)
 	t := time.Second * time.Duration(5)
@@ -401,3 +543,35 @@ func (p *Process) directRequest(id string, request interface{}) (interface{}, er
 		return nil, ErrTimeout
 	}
 }
+
+// replyRef returns the reference of the reply tag. The tag could be
+// a reference or an alias tagged reference [alias|Ref] (OTP 24)
+func replyRef(tag etf.Term) (etf.Ref, bool) {
+	switch t := tag.(type) {
+	case etf.Ref:
+		return t, true
+	case etf.ListImproper:
+		if len(t) != 2 || t[0] != etf.Atom("alias") {
+			return etf.Ref{}, false
+		}
+		ref, ok := t[1].(etf.Ref)
+		return ref, ok
+	}
+	return etf.Ref{}, false
+}
+
+// exitReason returns the exit reason as a string. The reason {panic, Value, Stack}
+// is "panic"
+func exitReason(reason etf.Term) string {
+	switch r := reason.(type) {
+	case etf.Atom:
+		return string(r)
+	case string:
+		return r
+	case etf.Tuple:
+		if len(r) == 3 && r[0] == etf.Atom("panic") {
+			return "panic"
+		}
+	}
+	return fmt.Sprint(reason)
+}
@@ -17,7 +17,7 @@ const (
 type registrar struct {
 	nextPID  uint32
 	nodeName string
-	creation byte
+	creation uint32
 
 	node *Node
 
@@ -29,18 +29,27 @@ type registrar struct {
 	mutexPeers     sync.Mutex
 	apps           map[string]*ApplicationSpec
 	mutexApps      sync.Mutex
+	spawn          map[string]RemoteSpawnFactory
+	mutexSpawn     sync.Mutex
+	aliases        map[string]*Process
+	mutexAliases   sync.Mutex
+	global         map[string]etf.Pid
+	mutexGlobal    sync.Mutex
 }
 
 func createRegistrar(node *Node) *registrar {
 	r := registrar{
 		nextPID:   startPID,
 		nodeName:  node.FullName,
-		creation:  byte(1),
+		creation:  node.creation,
 		node:      node,
 		names:     make(map[string]etf.Pid),
 		processes: make(map[uint32]*Process),
 		peers:     make(map[string]*peer),
 		apps:      make(map[string]*ApplicationSpec),
+		spawn:     make(map[string]RemoteSpawnFactory),
+		aliases:   make(map[string]*Process),
+		global:    make(map[string]etf.Pid),
 	}
 	return &r
 }
@@ -53,7 +62,7 @@ func (r *registrar) createNewPID() etf.Pid {
 		Node:     etf.Atom(r.nodeName),
 		ID:       i,
 		Serial:   1,
-		Creation: byte(r.creation),
+		Creation: r.creation,
 	}
 
 }
@@ -84,7 +93,7 @@ func (r *registrar) RegisterProcessExt(name string, object interface{}, opts Pro
 
 	process := &Process{
 		mailBox:      make(chan etf.Tuple, mailboxSize),
-		ready:        make(chan error),
+		ready:        make(chan error, 1),
 		stopped:      make(chan bool),
 		gracefulExit: exitChannel,
 		direct:       make(chan directMessage),
@@ -96,23 +105,24 @@ func (r *registrar) RegisterProcessExt(name string, object interface{}, opts Pro
 		Node:         r.node,
 		reply:        make(chan etf.Tuple, 2),
 		object:       object,
+		strictOrder:  opts.StrictOrder,
 	}
 
-	exit := func(from etf.Pid, reason string) {
-		lib.Log("[%s] EXIT: %#v with reason: %s", r.node.FullName, pid, reason)
+	exit := func(from etf.Pid, reason etf.Term) {
+		lib.Log("[%s] EXIT: %#v with reason: %s", r.node.FullName, pid, exitReason(reason))
 		ex := gracefulExitRequest{
 			from:   from,
-			reason: reason,
+			reason: exitReason(reason),
 		}
 		if ctx.Err() != nil {
 			// process is already died
 			return
 		}
-		if process.trapExit {
+		if process.GetTrapExit() {
 			message := etf.Tuple{from, etf.Tuple{
 				etf.Atom("EXIT"),
 				from,
-				etf.Atom(reason),
+				reason,
 			}}
 			process.mailBox <- message
 			return
@@ -126,7 +136,10 @@ func (r *registrar) RegisterProcessExt(name string, object interface{}, opts Pro
 		default:
 		}
 	}
-	process.Exit = exit
+	process.exitSignal = exit
+	process.Exit = func(from etf.Pid, reason string) {
+		exit(from, etf.Atom(reason))
+	}
 
 	if name != "" {
 		r.mutexNames.Lock()
@@ -167,6 +180,15 @@ func (r *registrar) UnregisterProcess(pid etf.Pid) {
 		}
 		r.mutexNames.Unlock()
 
+		// delete aliases created by this process
+		r.mutexAliases.Lock()
+		for alias, owner := range r.aliases {
+			if owner == p {
+				delete(r.aliases, alias)
+			}
+		}
+		r.mutexAliases.Unlock()
+
 		// delete associated process with this app
 		r.mutexProcesses.Lock()
 		for _, spec := range r.apps {
@@ -217,14 +239,30 @@ func (r *registrar) RegisterPeer(p *peer) error {
 	return nil
 }
 
-func (r *registrar) UnregisterPeer(name string) {
+// GetPeer returns peer with the given name or nil
+func (r *registrar) GetPeer(name string) *peer {
+	r.mutexPeers.Lock()
+	defer r.mutexPeers.Unlock()
+	return r.peers[name]
+}
+
+// UnregisterPeer removes peer and notifies the monitors about the node down
+// with the given reason (connection_closed, net_tick_timeout, disconnect)
+func (r *registrar) UnregisterPeer(name string, reason string) {
 	lib.Log("[%s] unregistering peer %v", r.node.FullName, name)
 	r.mutexPeers.Lock()
-	if _, ok := r.peers[name]; ok {
+	p, ok := r.peers[name]
+	if ok {
 		delete(r.peers, name)
-		r.node.monitor.NodeDown(name)
 	}
 	r.mutexPeers.Unlock()
+
+	if ok {
+		// the notifications could be routed to the remote processes
+		// (getPeer), so they are sent once the lock is released
+		r.node.monitor.NodeDown(name, p.hidden, reason)
+		r.node.connections.disconnected(name, reason)
+	}
 }
 
 func (r *registrar) RegisterApp(name string, spec *ApplicationSpec) error {
@@ -256,6 +294,108 @@ func (r *registrar) GetApplicationSpecByName(name string) *ApplicationSpec {
 	return nil
 }
 
+// RegisterGlobalName associates the global name with pid. Replaces the previous value
+func (r *registrar) RegisterGlobalName(name string, pid etf.Pid) {
+	lib.Log("[%s] registering global name %v", r.node.FullName, name)
+	r.mutexGlobal.Lock()
+	r.global[name] = pid
+	r.mutexGlobal.Unlock()
+}
+
+// UnregisterGlobalName removes the global name
+func (r *registrar) UnregisterGlobalName(name string) {
+	lib.Log("[%s] unregistering global name %v", r.node.FullName, name)
+	r.mutexGlobal.Lock()
+	delete(r.global, name)
+	r.mutexGlobal.Unlock()
+}
+
+// GetGlobalName returns pid associated with the global name
+func (r *registrar) GetGlobalName(name string) (etf.Pid, bool) {
+	r.mutexGlobal.Lock()
+	defer r.mutexGlobal.Unlock()
+	pid, ok := r.global[name]
+	return pid, ok
+}
+
+// GlobalNames returns a copy of the global name table
+func (r *registrar) GlobalNames() map[string]etf.Pid {
+	names := make(map[string]etf.Pid)
+	r.mutexGlobal.Lock()
+	for name, pid := range r.global {
+		names[name] = pid
+	}
+	r.mutexGlobal.Unlock()
+	return names
+}
+
+// RegisterAlias makes the given reference an alias of the process
+func (r *registrar) RegisterAlias(alias etf.Ref, p *Process) error {
+	lib.Log("[%s] registering alias %v for %v", r.node.FullName, alias, p.self)
+	r.mutexAliases.Lock()
+	defer r.mutexAliases.Unlock()
+	key := alias.String()
+	if _, ok := r.aliases[key]; ok {
+		return ErrAliasTaken
+	}
+	r.aliases[key] = p
+	return nil
+}
+
+// UnregisterAlias deactivates the alias. Only the process created this alias
+// is allowed to deactivate it
+func (r *registrar) UnregisterAlias(alias etf.Ref, p *Process) error {
+	lib.Log("[%s] unregistering alias %v", r.node.FullName, alias)
+	r.mutexAliases.Lock()
+	defer r.mutexAliases.Unlock()
+	key := alias.String()
+	owner, ok := r.aliases[key]
+	if !ok {
+		return ErrAliasUnknown
+	}
+	if owner != p {
+		return ErrAliasOwner
+	}
+	delete(r.aliases, key)
+	return nil
+}
+
+// GetProcessByAlias returns Process struct for the given alias. Returns nil
+// if this alias is not active
+func (r *registrar) GetProcessByAlias(alias etf.Ref) *Process {
+	r.mutexAliases.Lock()
+	defer r.mutexAliases.Unlock()
+	return r.aliases[alias.String()]
+}
+
+func (r *registrar) RegisterSpawnFactory(name string, factory RemoteSpawnFactory) error {
+	lib.Log("[%s] registering remote spawn %v", r.node.FullName, name)
+	r.mutexSpawn.Lock()
+	defer r.mutexSpawn.Unlock()
+	if _, ok := r.spawn[name]; ok {
+		return ErrNameIsTaken
+	}
+	r.spawn[name] = factory
+	return nil
+}
+
+func (r *registrar) UnregisterSpawnFactory(name string) error {
+	lib.Log("[%s] unregistering remote spawn %v", r.node.FullName, name)
+	r.mutexSpawn.Lock()
+	defer r.mutexSpawn.Unlock()
+	if _, ok := r.spawn[name]; !ok {
+		return ErrUnknown
+	}
+	delete(r.spawn, name)
+	return nil
+}
+
+func (r *registrar) GetSpawnFactory(name string) RemoteSpawnFactory {
+	r.mutexSpawn.Lock()
+	defer r.mutexSpawn.Unlock()
+	return r.spawn[name]
+}
+
 // GetProcessByPid returns Process struct for the given Pid. Returns nil if it doesn't exist (not found)
 func (r *registrar) GetProcessByPid(pid etf.Pid) *Process {
 	r.mutexProcesses.Lock()
@@ -296,6 +436,8 @@ func (r *registrar) ProcessList() []*Process {
 }
 
 func (r *registrar) PeerList() []string {
+	r.mutexPeers.Lock()
+	defer r.mutexPeers.Unlock()
 	list := []string{}
 	for n, _ := range r.peers {
 		list = append(list, n)
@@ -303,6 +445,19 @@ func (r *registrar) PeerList() []string {
 	return list
 }
 
+// PeerListByType returns the list of hidden or visible connected nodes
+func (r *registrar) PeerListByType(hidden bool) []string {
+	r.mutexPeers.Lock()
+	defer r.mutexPeers.Unlock()
+	list := []string{}
+	for n, p := range r.peers {
+		if p.hidden == hidden {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
 func (r *registrar) ApplicationList() []*ApplicationSpec {
 	list := []*ApplicationSpec{}
 	r.mutexApps.Lock()
@@ -313,8 +468,48 @@ func (r *registrar) ApplicationList() []*ApplicationSpec {
 	return list
 }
 
-// route routes message to a local/remote process
-func (r *registrar) route(from etf.Pid, to etf.Term, message etf.Term) {
+// route routes message to a local/remote process. Returns ErrNodeUnreachable
+// if the connection with the remote node can't be established or
+// ErrSendQueueFull if the send queue overflow policy has been applied
+func (r *registrar) route(from etf.Pid, to etf.Term, message etf.Term) error {
+	return r.routeMessage(from, to, message, false)
+}
+
+// routeNoSuspend routes message like route does but returns ErrSendQueueFull
+// instead of applying the overflow policy if the send queue is full
+func (r *registrar) routeNoSuspend(from etf.Pid, to etf.Term, message etf.Term) error {
+	return r.routeMessage(from, to, message, true)
+}
+
+// deliver puts the message into the mailbox of the given process. The process
+// handling the messages in strict order (see ProcessOptions.StrictOrder) gets
+// the replies to its calls into the reply channel, since it can't read the
+// mailbox while waiting for the reply within the callback.
+func (r *registrar) deliver(p *Process, from etf.Pid, message etf.Term) {
+	if p.strictOrder {
+		if m, ok := message.(etf.Tuple); ok && len(m) == 2 {
+			if tag, ok := m[0].(etf.ListImproper); ok {
+				if alias, ok := replyRef(tag); ok && r.GetProcessByAlias(alias) == p {
+					select {
+					case p.reply <- m:
+					default:
+						lib.Log("[%s] reply %#v is dropped: reply queue is full", r.node.FullName, alias)
+					}
+					return
+				}
+			}
+		}
+	}
+
+	select {
+	case p.mailBox <- etf.Tuple{from, message}:
+
+	default:
+		fmt.Println("WARNING! mailbox of", p.Self(), "is full. dropped message from", from)
+	}
+}
+
+func (r *registrar) routeMessage(from etf.Pid, to etf.Term, message etf.Term, nosuspend bool) error {
 next:
 	switch tto := to.(type) {
 	case etf.Pid:
@@ -323,33 +518,51 @@ next:
 			// local route
 			r.mutexProcesses.Lock()
 			if p, ok := r.processes[tto.ID]; ok {
-				select {
-				case p.mailBox <- etf.Tuple{from, message}:
-
-				default:
-					fmt.Println("WARNING! mailbox of", p.Self(), "is full. dropped message from", from)
-				}
+				r.deliver(p, from, message)
 			}
 			r.mutexProcesses.Unlock()
-			return
+			return nil
 		}
 
-		r.mutexPeers.Lock()
-		peer, ok := r.peers[string(tto.Node)]
-		r.mutexPeers.Unlock()
-		if !ok {
-			if err := r.node.connect(tto.Node); err != nil {
-				lib.Log("[%s] can't connect to %v: %s", r.node.FullName, tto.Node, err)
-				return
+		peer, err := r.getPeer(tto.Node)
+		if err != nil {
+			return err
+		}
+
+		// SEND_SENDER lets the remote side keep the order of
+		// the messages and signals from the same sender
+		control := etf.Tuple{distProtoSEND, etf.Atom(""), tto}
+		if from != (etf.Pid{}) && peer.link.IsSendSender() {
+			control = etf.Tuple{distProtoSEND_SENDER, from, tto}
+		}
+		return peer.Send([]etf.Term{control, message}, nosuspend)
+
+	case etf.Ref:
+		lib.Log("[%s] sending message by alias %v", r.node.FullName, tto)
+		if string(tto.Node) == r.nodeName {
+			// local route. the message is dropped if this alias
+			// is not active anymore
+			if p := r.GetProcessByAlias(tto); p != nil {
+				r.deliver(p, from, message)
 			}
+			return nil
+		}
 
-			r.mutexPeers.Lock()
-			peer, _ = r.peers[string(tto.Node)]
-			r.mutexPeers.Unlock()
+		peer, err := r.getPeer(tto.Node)
+		if err != nil {
+			return err
 		}
 
-		send := peer.GetChannel()
-		send <- []etf.Term{etf.Tuple{distProtoSEND, etf.Atom(""), tto}, message}
+		return peer.Send([]etf.Term{etf.Tuple{distProtoALIAS_SEND, from, tto}, message}, nosuspend)
+
+	case GlobalName:
+		lib.Log("[%s] sending message by global name %v", r.node.FullName, tto)
+		pid, ok := r.GetGlobalName(string(tto))
+		if !ok {
+			return ErrUnknown
+		}
+		to = pid
+		goto next
 
 	case etf.Tuple:
 		lib.Log("[%s] sending message by tuple %v", r.node.FullName, tto)
@@ -380,27 +593,15 @@ next:
 
 		if toNode == etf.Atom(r.nodeName) {
 			// local route
-			r.route(from, toProcessName, message)
-			return
+			return r.routeMessage(from, toProcessName, message, nosuspend)
 		}
 
-		r.mutexPeers.Lock()
-		peer, ok := r.peers[string(toNode)]
-		r.mutexPeers.Unlock()
-		if !ok {
-			// initiate connection and make yet another attempt to deliver this message
-			if err := r.node.connect(toNode); err != nil {
-				lib.Log("[%s] can't connect to %v: %s", r.node.FullName, toNode, err)
-				return
-			}
-
-			r.mutexPeers.Lock()
-			peer, _ = r.peers[string(toNode)]
-			r.mutexPeers.Unlock()
+		peer, err := r.getPeer(toNode)
+		if err != nil {
+			return err
 		}
 
-		send := peer.GetChannel()
-		send <- []etf.Term{etf.Tuple{distProtoREG_SEND, from, etf.Atom(""), toProcessName}, message}
+		return peer.Send([]etf.Term{etf.Tuple{distProtoREG_SEND, from, etf.Atom(""), toProcessName}, message}, nosuspend)
 
 	case string:
 		lib.Log("[%s] sending message by name %v", r.node.FullName, tto)
@@ -424,25 +625,33 @@ next:
 	default:
 		lib.Log("[%s] unknow sender type %#v", r.node.FullName, tto)
 	}
+	return nil
 }
 
-func (r *registrar) routeRaw(nodename etf.Atom, message etf.Term) error {
-	r.mutexPeers.Lock()
-	peer, ok := r.peers[string(nodename)]
-	r.mutexPeers.Unlock()
-	if !ok {
-		// initiate connection and make yet another attempt to deliver this message
-		if err := r.node.connect(nodename); err != nil {
-			lib.Log("[%s] can't connect to %v: %s", r.node.FullName, nodename, err)
-			return err
-		}
+func (r *registrar) routeRaw(nodename etf.Atom, messages ...etf.Term) error {
+	peer, err := r.getPeer(nodename)
+	if err != nil {
+		return err
+	}
 
-		r.mutexPeers.Lock()
-		peer, _ = r.peers[string(nodename)]
-		r.mutexPeers.Unlock()
+	return peer.sendControl(messages)
+}
+
+// getPeer returns the peer with the given name. Initiates connection
+// if this node is not connected yet
+func (r *registrar) getPeer(name etf.Atom) (*peer, error) {
+	if peer := r.GetPeer(string(name)); peer != nil {
+		return peer, nil
 	}
 
-	send := peer.GetChannel()
-	send <- []etf.Term{message}
-	return nil
+	if err := r.node.connect(name); err != nil {
+		lib.Log("[%s] can't connect to %v: %s", r.node.FullName, name, err)
+		return nil, ErrNodeUnreachable
+	}
+
+	if peer := r.GetPeer(string(name)); peer != nil {
+		return peer, nil
+	}
+	// connection has been lost right after the handshake
+	return nil, ErrNodeUnreachable
 }
@@ -0,0 +1,59 @@
+package ergo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/halturin/ergo/dist"
+)
+
+// Resolver resolves the listening port and the version of the handshake of
+// the node with the given name. Node doesn't use EPMD if the Resolver option
+// is set or its transport implements this interface. dist.EPMD is the
+// default one.
+type Resolver interface {
+	Resolve(name string) (port int, version uint16, err error)
+}
+
+// StaticResolver maps the node names to the ports without EPMD (in fashion of
+// "-start_epmd false -erl_epmd_port Port"). The node listens on the port
+// it resolves for itself. The port is taken from (in order):
+//   - Ports by the full node name (name@host) or by the name part
+//   - PortBase plus the number at the end of the name part ("node3@host" -> PortBase+3)
+//   - Port
+type StaticResolver struct {
+	Ports    map[string]uint16
+	PortBase uint16
+	Port     uint16
+}
+
+// Resolve implements Resolver interface. Nodes are resolved with the
+// handshake version 5 (version 6 is negotiated if the peer supports it)
+func (r *StaticResolver) Resolve(name string) (int, uint16, error) {
+	alive, _, err := dist.SplitNodeName(name)
+	if err != nil {
+		return -1, 0, err
+	}
+
+	if port, ok := r.Ports[name]; ok {
+		return int(port), dist.ProtoHandshake5, nil
+	}
+	if port, ok := r.Ports[alive]; ok {
+		return int(port), dist.ProtoHandshake5, nil
+	}
+
+	if r.PortBase > 0 {
+		digits := strings.TrimRightFunc(alive, func(c rune) bool {
+			return c >= '0' && c <= '9'
+		})
+		if n, err := strconv.Atoi(alive[len(digits):]); err == nil && n+int(r.PortBase) <= 65535 {
+			return int(r.PortBase) + n, dist.ProtoHandshake5, nil
+		}
+	}
+
+	if r.Port > 0 {
+		return int(r.Port), dist.ProtoHandshake5, nil
+	}
+	return -1, 0, fmt.Errorf("can't resolve port for %s", name)
+}
@@ -0,0 +1,85 @@
+package ergo
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestStaticResolver(t *testing.T) {
+	fmt.Printf("\n=== Test Static Resolver (EPMD-less)\n")
+	resolver := &StaticResolver{
+		Ports: map[string]uint16{
+			"nodeStaticA@localhost": 25201,
+			"nodeStaticB":           25202,
+		},
+		PortBase: 25300,
+		Port:     25400,
+	}
+
+	fmt.Printf("    resolve ports: ")
+	cases := map[string]int{
+		"nodeStaticA@localhost": 25201,
+		"nodeStaticB@localhost": 25202,
+		"nodeStatic7@localhost": 25307,
+		"nodeStatic@localhost":  25400,
+	}
+	for name, expected := range cases {
+		port, _, err := resolver.Resolve(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != expected {
+			t.Fatal(name, "expected", expected, "got", port)
+		}
+	}
+	if _, _, err := (&StaticResolver{}).Resolve("nodeStatic@localhost"); err == nil {
+		t.Fatal("expected error")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("Starting nodes: nodeStaticA@localhost, nodeStatic1@localhost: ")
+	opts := NodeOptions{
+		Resolver: resolver,
+	}
+	node1, _ := CreateNode("nodeStaticA@localhost", "cookies", opts)
+	node2, _ := CreateNode("nodeStatic1@localhost", "cookies", opts)
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    nodes listen on the resolved ports and are not registered in EPMD: ")
+	if port := node2.listener.Addr().(*net.TCPAddr).Port; port != 25301 {
+		t.Fatal("expected 25301, got", port)
+	}
+	if _, _, err := node1.epmd.Resolve(node2.FullName); err == nil {
+		t.Fatal("node is registered in EPMD")
+	}
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	fmt.Printf("    process.Send (by Name) local (gs1) -> remote (gs2): ")
+	to := etf.Tuple{etf.Atom("gs2"), etf.Atom(node2.FullName)}
+	if err := node1gs1.Send(to, etf.Atom("hi")); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gs2.v, etf.Atom("hi"))
+}
@@ -3,7 +3,7 @@ package ergo
 // https://github.com/erlang/otp/blob/master/lib/kernel/src/rpc.erl
 
 import (
-	"fmt"
+	"runtime/debug"
 
 	"github.com/halturin/ergo/etf"
 	"github.com/halturin/ergo/lib"
@@ -134,16 +134,21 @@ func (r *rex) Terminate(reason string, state interface{}) {
 func (r *rex) handleRPC(module, function etf.Atom, args etf.List, state interface{}) (reply, state1 interface{}) {
 	defer func() {
 		if x := recover(); x != nil {
-			err := fmt.Sprintf("panic reason: %s", x)
-			// recovered
+			// recovered. rex keeps running, the caller gets
+			// {badrpc, {'EXIT', {{panic, Value, Stack}, [{M, F, A, []}]}}}
+			crash := ProcessPanic{
+				Value: x,
+				Stack: string(debug.Stack()),
+			}
+			lib.Log("REX: recovered %s:%s: %v\n%s", module, function, crash.Value, crash.Stack)
 			reply = etf.Tuple{
 				etf.Atom("badrpc"),
 				etf.Tuple{
 					etf.Atom("EXIT"),
 					etf.Tuple{
-						etf.Atom("panic"),
+						crash.Term(),
 						etf.List{
-							etf.Tuple{module, function, args, etf.List{err}},
+							etf.Tuple{module, function, args, etf.List{}},
 						},
 					},
 				},
@@ -41,7 +41,7 @@ func (trpc *testRPCGenServer) Terminate(reason string, state interface{}) {
 func TestRPC(t *testing.T) {
 	fmt.Printf("\n=== Test RPC\n")
 
-	node1 := CreateNode("nodeRPC@localhost", "cookies", NodeOptions{})
+	node1, _ := CreateNode("nodeRPC@localhost", "cookies", NodeOptions{})
 	gs1 := &testRPCGenServer{}
 	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
 
@@ -67,7 +67,7 @@ const (
 	supervisorChildStateRunning  = 1
 	supervisorChildStateDisabled = -1
 
-	// shutdown defines how a child process must be terminated. (TODO: not implemented yet)
+	// shutdown defines how a child process must be terminated.
 
 	// SupervisorChildShutdownBrutal means that the child process is
 	// unconditionally terminated using process' Kill method
@@ -135,7 +135,7 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 	}
 
 	svp.SetTrapExit(true)
-	svp.currentFunction = "Supervisor:loop"
+	svp.setCurrentFunction("Supervisor:loop")
 	waitTerminatingProcesses := []etf.Pid{}
 
 	for {
@@ -143,7 +143,8 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 		var fromPid etf.Pid
 		select {
 		case ex := <-svp.gracefulExit:
-			for i := range spec.Children {
+			// children are terminated in reverse start order
+			for i := len(spec.Children) - 1; i >= 0; i-- {
 				p := spec.Children[i].process
 				if p == nil {
 					continue
@@ -152,7 +153,7 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 					// in order to get rid of race condition when Node goes down
 					// via node.Stop() cancaling the node's context which
 					// triggering all the processes to kill themselves
-					p.Exit(svp.Self(), ex.reason)
+					shutdownChild(svp, p, spec.Children[i].Shutdown, ex.reason)
 				}
 			}
 			return ex.reason
@@ -180,7 +181,7 @@ func (sv *Supervisor) Loop(svp *Process, args ...interface{}) string {
 
 			case etf.Atom("EXIT"):
 				terminated := m.Element(2).(etf.Pid)
-				reason := m.Element(3).(etf.Atom)
+				reason := etf.Atom(exitReason(m.Element(3)))
 				itWasChild := false
 				// We should make sure if it was real call for exit.
 				// 'EXIT' message shouldn't be sent by the child of this supervisor
@@ -503,6 +504,48 @@ func startChild(parent *Process, name string, child interface{}, args ...interfa
 	return process
 }
 
+// shutdownChild terminates the child process according to the shutdown spec
+// and waits until it's stopped. It's used on the termination of the supervisor
+// only, so the mailbox of the supervisor is drained while waiting: the 'EXIT'
+// messages of the children are dropped as well as the rest of the messages.
+// Otherwise, the child could be blocked on sending 'EXIT' to the full mailbox
+func shutdownChild(parent *Process, child *Process, shutdown SupervisorChildShutdown, reason string) {
+	var timeout <-chan time.Time
+
+	switch {
+	case shutdown == SupervisorChildShutdownBrutal:
+		child.Kill()
+	case shutdown > 0:
+		timer := time.NewTimer(time.Duration(shutdown) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+		fallthrough
+	default:
+		child.Exit(parent.Self(), reason)
+	}
+
+	for {
+		select {
+		case <-child.stopped:
+			return
+		case <-timeout:
+			lib.Log("[%s] child %v is not stopped within %d seconds. killing",
+				parent.Node.FullName, child.Self(), shutdown)
+			child.Kill()
+			timeout = nil
+		case <-parent.Context.Done():
+			return
+		case m := <-parent.mailBox:
+			// 'EXIT' messages from the terminated children are expected
+			if t, ok := m.Element(2).(etf.Tuple); ok && len(t) == 3 && t.Element(1) == etf.Atom("EXIT") {
+				continue
+			}
+			lib.Log("[%s] supervisor is terminating. message %#v is dropped",
+				parent.Node.FullName, m.Element(2))
+		}
+	}
+}
+
 func haveToDisableChild(restart SupervisorChildRestart, reason etf.Atom) bool {
 	switch restart {
 	case SupervisorChildRestartTransient:
@@ -58,7 +58,7 @@ func TestSupervisorRestForOne(t *testing.T) {
 	var err error
 	fmt.Printf("\n=== Test Supervisor - rest for one\n")
 	fmt.Printf("Starting node nodeSvRestForOne@localhost: ")
-	node := CreateNode("nodeSvRestForOne@localhost", "cookies", NodeOptions{})
+	node, _ := CreateNode("nodeSvRestForOne@localhost", "cookies", NodeOptions{})
 	if node == nil {
 		t.Fatal("can't start node")
 	} else {
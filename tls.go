@@ -0,0 +1,231 @@
+package ergo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halturin/ergo/lib"
+)
+
+// nodeTLS keeps the certificates of the node. In TLSmodeStrict they are
+// loaded from the files and can be reloaded at runtime (Node.ReloadTLS)
+// without restarting the node. The configs are made for every connection
+// so the reloaded certificates are used for the new connections.
+type nodeTLS struct {
+	opts NodeOptions
+
+	mutex    sync.RWMutex
+	server   *tls.Certificate
+	client   *tls.Certificate
+	ca       *x509.CertPool
+	modified time.Time
+}
+
+func createNodeTLS(opts NodeOptions) (*nodeTLS, error) {
+	t := &nodeTLS{
+		opts: opts,
+	}
+
+	switch opts.TLSmode {
+	case TLSmodeAuto:
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			return nil, fmt.Errorf("Can't generate certificate: %s", err)
+		}
+		t.server = &cert
+		t.client = &cert
+
+	case TLSmodeStrict:
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// load reads the certificates and the CA bundle from the files. Client
+// certificate is the same as the server one if TLScrtClient is not specified.
+func (t *nodeTLS) load() error {
+	var server, client *tls.Certificate
+	var ca *x509.CertPool
+
+	if t.opts.TLSmode != TLSmodeStrict {
+		return nil
+	}
+
+	modified := t.lastModified()
+
+	if t.opts.TLScrtServer != "" {
+		cert, err := tls.LoadX509KeyPair(t.opts.TLScrtServer, t.opts.TLSkeyServer)
+		if err != nil {
+			return fmt.Errorf("Can't load server certificate: %s", err)
+		}
+		server = &cert
+	}
+
+	client = server
+	if t.opts.TLScrtClient != "" {
+		cert, err := tls.LoadX509KeyPair(t.opts.TLScrtClient, t.opts.TLSkeyClient)
+		if err != nil {
+			return fmt.Errorf("Can't load client certificate: %s", err)
+		}
+		client = &cert
+	}
+
+	if t.opts.TLScaFile != "" {
+		bundle, err := ioutil.ReadFile(t.opts.TLScaFile)
+		if err != nil {
+			return fmt.Errorf("Can't load CA bundle: %s", err)
+		}
+		ca = x509.NewCertPool()
+		if !ca.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("Can't load CA bundle: no certificates found in %s", t.opts.TLScaFile)
+		}
+	}
+
+	if server == nil && (t.opts.TLSconfig == nil || len(t.opts.TLSconfig.Certificates) == 0) {
+		return fmt.Errorf("Server certificate is not specified")
+	}
+
+	t.mutex.Lock()
+	t.server = server
+	t.client = client
+	t.ca = ca
+	t.modified = modified
+	t.mutex.Unlock()
+	return nil
+}
+
+// lastModified returns the latest modification time of the files
+func (t *nodeTLS) lastModified() time.Time {
+	var modified time.Time
+	files := []string{t.opts.TLScrtServer, t.opts.TLSkeyServer,
+		t.opts.TLScrtClient, t.opts.TLSkeyClient, t.opts.TLScaFile}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified
+}
+
+// watch reloads the certificates if the files have been modified
+func (t *nodeTLS) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		t.mutex.RLock()
+		modified := t.modified
+		t.mutex.RUnlock()
+
+		if !t.lastModified().After(modified) {
+			continue
+		}
+		if err := t.load(); err != nil {
+			lib.Log("Can't reload TLS certificates: %s", err)
+		}
+	}
+}
+
+func (t *nodeTLS) listener(l net.Listener) net.Listener {
+	config := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.config(true, ""), nil
+		},
+	}
+	return tls.NewListener(l, config)
+}
+
+func (t *nodeTLS) dial(c net.Conn, name string) net.Conn {
+	return tls.Client(c, t.config(false, nodeHost(name)))
+}
+
+// config makes the config for the server (incoming connection) or client side
+func (t *nodeTLS) config(server bool, host string) *tls.Config {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	config := &tls.Config{}
+	if t.opts.TLSconfig != nil {
+		config = t.opts.TLSconfig.Clone()
+	}
+
+	if t.opts.TLSmode == TLSmodeAuto {
+		config.Certificates = []tls.Certificate{*t.server}
+		config.InsecureSkipVerify = true
+		return config
+	}
+
+	if server {
+		if t.server != nil {
+			config.Certificates = []tls.Certificate{*t.server}
+		}
+		if t.ca != nil {
+			config.ClientCAs = t.ca
+		}
+		if t.opts.TLSmutual {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return config
+	}
+
+	if t.client != nil {
+		config.Certificates = []tls.Certificate{*t.client}
+	}
+	if t.ca != nil {
+		config.RootCAs = t.ca
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// verifyNodeName checks whether the certificate of the peer is issued for
+// the node with the given name. The full node name is checked against the URI
+// names of SAN (scheme://name@host). If there are no URI names the host part
+// of the node name must match the DNS or IP names of SAN.
+func verifyNodeName(c net.Conn, name string) error {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return fmt.Errorf("connection with %s is not TLS", name)
+	}
+
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("peer %s has no certificate", name)
+	}
+	if len(certs[0].URIs) == 0 {
+		if certs[0].VerifyHostname(nodeHost(name)) == nil {
+			return nil
+		}
+		return fmt.Errorf("certificate is not valid for %s", name)
+	}
+	for _, uri := range certs[0].URIs {
+		if uri.User == nil {
+			continue
+		}
+		if strings.EqualFold(uri.User.Username()+"@"+uri.Host, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate is not valid for %s", name)
+}
@@ -0,0 +1,223 @@
+package ergo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestTLSStrict(t *testing.T) {
+	fmt.Printf("\n=== Test TLS (strict mode, mutual authentication)\n")
+	dir := t.TempDir()
+
+	fmt.Printf("Generating CA and certificates: ")
+	ca, caKey := testTLSCA(t, filepath.Join(dir, "ca.pem"))
+	otherCA, otherCAKey := testTLSCA(t, filepath.Join(dir, "other_ca.pem"))
+	testTLSCert(t, dir, "nodeTLS1@localhost", ca, caKey, true)
+	testTLSCert(t, dir, "nodeTLS2@localhost", ca, caKey, true)
+	testTLSCert(t, dir, "nodeTLS3@localhost", otherCA, otherCAKey, true)
+	fmt.Println("OK")
+
+	opts := func(name string) NodeOptions {
+		return NodeOptions{
+			TLSmode:             TLSmodeStrict,
+			TLScrtServer:        filepath.Join(dir, name+".crt"),
+			TLSkeyServer:        filepath.Join(dir, name+".key"),
+			TLScaFile:           filepath.Join(dir, "ca.pem"),
+			TLSmutual:           true,
+			TLSverifyNodeName:   true,
+			ReconnectBackoffMin: 10 * time.Millisecond,
+			ReconnectBackoffMax: 10 * time.Millisecond,
+		}
+	}
+
+	fmt.Printf("Starting nodes: nodeTLS1@localhost, nodeTLS2@localhost, nodeTLS3@localhost: ")
+	node1, _ := CreateNode("nodeTLS1@localhost", "cookies", opts("nodeTLS1@localhost"))
+	node2, _ := CreateNode("nodeTLS2@localhost", "cookies", opts("nodeTLS2@localhost"))
+	node3, _ := CreateNode("nodeTLS3@localhost", "cookies", opts("nodeTLS3@localhost"))
+	if node1 == nil || node2 == nil || node3 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	defer node3.Stop()
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	fmt.Printf("    process.Send (by Pid) nodeTLS1 (gs1) -> nodeTLS2 (gs2): ")
+	if err := node1gs1.Send(node2gs2.Self(), etf.Atom("hi")); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gs2.v, etf.Atom("hi"))
+
+	fmt.Printf("    certificate is signed by unknown CA: ")
+	if err := node3.connect(etf.Atom(node1.FullName)); err == nil {
+		t.Fatal("connection must be rejected")
+	}
+	if node1.registrar.GetPeer(node3.FullName) != nil {
+		t.Fatal("nodeTLS3 must not be connected")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    certificate is not valid for the node name: ")
+	// nodeTLS3 uses the certificate of nodeTLS2
+	for _, ext := range []string{".crt", ".key"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "nodeTLS2@localhost"+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "nodeTLS3@localhost"+ext), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := node3.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := node3.connect(etf.Atom(node1.FullName)); err == nil {
+		t.Fatal("connection must be rejected")
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    reload certificate signed by the right CA: ")
+	testTLSCert(t, dir, "nodeTLS3@localhost", ca, caKey, true)
+	if err := node3.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := node3.connect(etf.Atom(node1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    certificate without node name is verified by the host: ")
+	testTLSCert(t, dir, "nodeTLS3@localhost", ca, caKey, false)
+	if err := node3.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := node3.Disconnect(node1.FullName); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := node3.connect(etf.Atom(node1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    reload with the broken certificate keeps the current one: ")
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := node3.ReloadTLS(); err == nil {
+		t.Fatal("reload must fail")
+	}
+	if err := node3.Disconnect(node1.FullName); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := node3.connect(etf.Atom(node1.FullName)); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    node name verification requires TLS: ")
+	if _, err := CreateNode("nodeTLS4@localhost", "cookies", NodeOptions{TLSverifyNodeName: true}); err != ErrTLSDisabled {
+		t.Fatal("expected", ErrTLSDisabled, "got", err)
+	}
+	fmt.Println("OK")
+}
+
+func testTLSCA(t *testing.T, file string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: file},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(file, pemCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return ca, key
+}
+
+// testTLSCert creates <name>.crt and <name>.key in the dir. The certificate
+// is issued for the host of the node and for the node name if uri is true
+func testTLSCert(t *testing.T, dir, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, uri bool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{nodeHost(name)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if uri {
+		u, err := url.Parse("dist://" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), pemCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), pemKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,201 @@
+package ergo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/halturin/ergo/dist"
+)
+
+// Transport defines the network layer of the distribution. TLS (see TLSmode
+// option) works on top of any transport.
+type Transport interface {
+	// Listen creates the listener for the node with the given name. The port
+	// is taken from the listen range (ListenRangeBegin...ListenRangeEnd)
+	Listen(ctx context.Context, name string, port uint16) (net.Listener, error)
+	// Dial connects to the node with the given name. The port is resolved
+	// by EPMD (or by the transport itself if it implements Resolver)
+	Dial(ctx context.Context, name string, port uint16) (net.Conn, error)
+}
+
+// TCPTransport is the default transport
+type TCPTransport struct {
+	// ListenAddress is the address to bind to (like "0.0.0.0" or "::"). The
+	// host of the node name is used if it's empty
+	ListenAddress string
+}
+
+// Listen implements Transport interface
+func (t *TCPTransport) Listen(ctx context.Context, name string, port uint16) (net.Listener, error) {
+	host := t.ListenAddress
+	if host == "" {
+		host = nodeHost(name)
+	}
+	lc := net.ListenConfig{}
+	return lc.Listen(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+// Dial implements Transport interface
+func (t *TCPTransport) Dial(ctx context.Context, name string, port uint16) (net.Conn, error) {
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(nodeHost(name), strconv.Itoa(int(port))))
+}
+
+// UnixTransport uses the Unix domain sockets. The socket of the node is
+// created in the Dir as <node name>.sock. Nodes are resolved without EPMD.
+type UnixTransport struct {
+	Dir string
+}
+
+func (t *UnixTransport) path(name string) string {
+	return filepath.Join(t.Dir, name+".sock")
+}
+
+// Listen implements Transport interface. The port is ignored
+func (t *UnixTransport) Listen(ctx context.Context, name string, port uint16) (net.Listener, error) {
+	path := t.path(name)
+	if _, err := os.Stat(path); err == nil {
+		// remove the socket left by the crashed node. there
+		// must be nobody listening on it
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	lc := net.ListenConfig{}
+	return lc.Listen(ctx, "unix", path)
+}
+
+// Dial implements Transport interface. The port is ignored
+func (t *UnixTransport) Dial(ctx context.Context, name string, port uint16) (net.Conn, error) {
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "unix", t.path(name))
+}
+
+// Resolve implements Resolver interface
+func (t *UnixTransport) Resolve(name string) (int, uint16, error) {
+	if _, err := os.Stat(t.path(name)); err != nil {
+		return -1, 0, err
+	}
+	return 0, dist.ProtoHandshake6, nil
+}
+
+// PipeTransport connects the nodes within the same process using in-memory
+// pipes (net.Pipe). The same PipeTransport must be shared by all the nodes
+// of the cluster. Nodes are resolved without EPMD.
+type PipeTransport struct {
+	mutex     sync.Mutex
+	listeners map[string]*pipeListener
+}
+
+// NewPipeTransport creates the in-memory transport
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		listeners: make(map[string]*pipeListener),
+	}
+}
+
+// Listen implements Transport interface. The port is ignored
+func (t *PipeTransport) Listen(ctx context.Context, name string, port uint16) (net.Listener, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.listeners[name]; ok {
+		return nil, fmt.Errorf("node %s is already listening", name)
+	}
+	l := &pipeListener{
+		transport: t,
+		name:      name,
+		accept:    make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+	t.listeners[name] = l
+	return l, nil
+}
+
+// Dial implements Transport interface. The port is ignored
+func (t *PipeTransport) Dial(ctx context.Context, name string, port uint16) (net.Conn, error) {
+	var err error
+
+	t.mutex.Lock()
+	l, ok := t.listeners[name]
+	t.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown node %s", name)
+	}
+
+	client, server := net.Pipe()
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.closed:
+		err = ErrTransportClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	client.Close()
+	server.Close()
+	return nil, err
+}
+
+// Resolve implements Resolver interface
+func (t *PipeTransport) Resolve(name string) (int, uint16, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.listeners[name]; !ok {
+		return -1, 0, fmt.Errorf("unknown node %s", name)
+	}
+	return 0, dist.ProtoHandshake6, nil
+}
+
+type pipeListener struct {
+	transport *PipeTransport
+	name      string
+	accept    chan net.Conn
+	closed    chan struct{}
+	once      sync.Once
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrTransportClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() {
+		l.transport.mutex.Lock()
+		delete(l.transport.listeners, l.name)
+		l.transport.mutex.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.name)
+}
+
+// nodeHost returns the host part of the node name
+func nodeHost(name string) string {
+	_, host, err := dist.SplitNodeName(name)
+	if err != nil {
+		return name
+	}
+	return host
+}
@@ -0,0 +1,86 @@
+package ergo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/halturin/ergo/etf"
+)
+
+func TestTransportPipe(t *testing.T) {
+	fmt.Printf("\n=== Test Transport (in-memory pipe)\n")
+	transport := NewPipeTransport()
+	opts := NodeOptions{
+		Transport: transport,
+	}
+	testTransport(t, "nodePipe1@localhost", "nodePipe2@localhost", opts)
+}
+
+func TestTransportUnix(t *testing.T) {
+	fmt.Printf("\n=== Test Transport (unix domain socket)\n")
+	opts := NodeOptions{
+		Transport: &UnixTransport{Dir: t.TempDir()},
+	}
+	testTransport(t, "nodeUnix1@localhost", "nodeUnix2@localhost", opts)
+}
+
+func testTransport(t *testing.T, name1, name2 string, opts NodeOptions) {
+	fmt.Printf("Starting nodes: %s, %s: ", name1, name2)
+	node1, _ := CreateNode(name1, "cookies", opts)
+	node2, _ := CreateNode(name2, "cookies", opts)
+	if node1 == nil || node2 == nil {
+		t.Fatal("can't start nodes")
+	}
+	defer node1.Stop()
+	defer node2.Stop()
+	fmt.Println("OK")
+
+	fmt.Printf("    nodes are not registered in EPMD: ")
+	if port, _, err := node1.epmd.Resolve(name2); err == nil {
+		t.Fatal("node is registered in EPMD with port", port)
+	}
+	fmt.Println("OK")
+
+	gs1 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	gs2 := &testMonitorGenServer{
+		v: make(chan interface{}, 2),
+	}
+	fmt.Printf("    wait for start of gs1 on %#v: ", node1.FullName)
+	node1gs1, _ := node1.Spawn("gs1", ProcessOptions{}, gs1, nil)
+	waitForResultWithValue(t, gs1.v, node1gs1.Self())
+
+	fmt.Printf("    wait for start of gs2 on %#v: ", node2.FullName)
+	node2gs2, _ := node2.Spawn("gs2", ProcessOptions{}, gs2, nil)
+	waitForResultWithValue(t, gs2.v, node2gs2.Self())
+
+	fmt.Printf("    process.Send (by Pid) local (gs1) -> remote (gs2): ")
+	if err := node1gs1.Send(node2gs2.Self(), etf.Atom("hi")); err != nil {
+		t.Fatal(err)
+	}
+	waitForResultWithValue(t, gs2.v, etf.Atom("hi"))
+
+	fmt.Printf("    process.Call (by Name) remote (gs2) -> local (gs1): ")
+	if v, err := node2gs2.Call(etf.Tuple{"gs1", name1}, etf.Atom("hi call")); err != nil {
+		t.Fatal(err)
+	} else if v != etf.Atom("hi call") {
+		t.Fatal("expected 'hi call', got", v)
+	}
+	fmt.Println("OK")
+
+	fmt.Printf("    stop %s: ", name2)
+	node2.Stop()
+	node2.Wait()
+	for i := 0; node1.registrar.GetPeer(name2) != nil; i++ {
+		if i > 300 {
+			t.Fatal("connection is still alive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := node1gs1.Send(node2gs2.Self(), etf.Atom("hi")); err != ErrNodeUnreachable {
+		t.Fatal("expected", ErrNodeUnreachable, "got", err)
+	}
+	fmt.Println("OK")
+}
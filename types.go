@@ -1,10 +1,15 @@
 package ergo
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/halturin/ergo/dist"
 	"github.com/halturin/ergo/etf"
+	"github.com/halturin/ergo/lib"
 )
 
 var (
@@ -14,6 +19,19 @@ var (
 	ErrAppIsNotRunning    = fmt.Errorf("Application is not running")
 	ErrProcessBusy        = fmt.Errorf("Process is busy")
 	ErrNameIsTaken        = fmt.Errorf("Name is taken")
+	ErrUnknown            = fmt.Errorf("Unknown")
+	ErrAliasTaken         = fmt.Errorf("Alias is taken")
+	ErrAliasUnknown       = fmt.Errorf("Unknown alias")
+	ErrAliasOwner         = fmt.Errorf("Not an owner")
+	ErrProcessTerminated  = fmt.Errorf("Process terminated")
+	ErrProcessNotLocal    = fmt.Errorf("Not a local process")
+	ErrGroupNotJoined     = fmt.Errorf("Not joined")
+	ErrNodeUnreachable    = fmt.Errorf("Node is unreachable")
+	ErrTransportClosed    = fmt.Errorf("Transport is closed")
+	ErrListen             = fmt.Errorf("Can't listen port")
+	ErrTLSDisabled        = fmt.Errorf("TLS is disabled")
+	ErrConnectionRefused  = fmt.Errorf("Connection is refused")
+	ErrSendQueueFull      = fmt.Errorf("Send queue is full")
 	ErrUnsupportedRequest = fmt.Errorf("Unsupported request")
 	ErrTimeout            = fmt.Errorf("Timed out")
 	ErrFragmented         = fmt.Errorf("Fragmented data")
@@ -44,28 +62,299 @@ const (
 	distProtoPAYLOAD_EXIT2          = 26
 	distProtoPAYLOAD_EXIT2_TT       = 27
 	distProtoPAYLOAD_MONITOR_P_EXIT = 28
+	distProtoSPAWN_REQUEST          = 29
+	distProtoSPAWN_REQUEST_TT       = 30
+	distProtoSPAWN_REPLY            = 31
+	distProtoSPAWN_REPLY_TT         = 32
+	distProtoALIAS_SEND             = 33
+	distProtoALIAS_SEND_TT          = 34
+)
+
+// Flags of the SPAWN_REPLY message
+const (
+	spawnFlagLink    = 1
+	spawnFlagMonitor = 2
 )
 
 type peer struct {
-	name string
-	send []chan []etf.Term
-	i    int
-	n    int
+	name   string
+	hidden bool
+	link   *dist.Link
+	// the sender of the messages is known (SEND_SENDER is supported)
+	sendSender bool
+	send       []chan []etf.Term
+	n          int
+	// senders hold the lock of the queue while they are putting
+	// messages into it, so the messages can be evicted safely
+	sendLocks []sync.Mutex
+	// reason of the node down (connection_closed if it's empty)
+	reason string
 
 	mutex sync.Mutex
+
+	policy  SendQueuePolicyType
+	timeout time.Duration
+
+	// senders hold the read lock while they are putting messages into
+	// the queues, so the queues are closed once they are done
+	sendMutex sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+	done      chan struct{}
+	// writers are done once the queues are closed and drained
+	writers sync.WaitGroup
+
+	busy     uint64
+	dropped  uint64
+	timedOut uint64
 }
 
-func (p *peer) GetChannel() chan []etf.Term {
+func createPeer(link *dist.Link, n int, opts NodeOptions) *peer {
+	p := &peer{
+		name:       link.GetRemoteName(),
+		hidden:     link.IsHidden(),
+		sendSender: link.IsSendSender(),
+		link:       link,
+		send:       make([]chan []etf.Term, n),
+		n:          n,
+		sendLocks:  make([]sync.Mutex, n),
+		policy:     opts.SendQueuePolicy,
+		timeout:    opts.SendQueueTimeout,
+		done:       make(chan struct{}),
+	}
+	for i := range p.send {
+		p.send[i] = make(chan []etf.Term, opts.SendQueueLength)
+	}
+	return p
+}
+
+// close closes the link to this peer. The first given reason is reported
+// to the node monitors
+func (p *peer) close(reason string) {
+	p.mutex.Lock()
+	if p.reason == "" {
+		p.reason = reason
+	}
+	p.mutex.Unlock()
+	p.link.Close()
+}
+
+func (p *peer) downReason() string {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
+	if p.reason == "" {
+		return "connection_closed"
+	}
+	return p.reason
+}
+
+// Send puts the messages into the queue of the writer (see distShard).
+// Messages of the same sender always go through the same queue (and the
+// writer) so their order is preserved. If the queue is full (busy_dist_port
+// in terms of Erlang) the overflow policy is applied unless nosuspend is set.
+func (p *peer) Send(messages []etf.Term, nosuspend bool) error {
+	p.sendMutex.RLock()
+	defer p.sendMutex.RUnlock()
+
+	if p.closed {
+		return ErrNodeUnreachable
+	}
+
+	i := distShard(messages[0], p.sendSender, p.n)
+	p.sendLocks[i].Lock()
+	defer p.sendLocks[i].Unlock()
+
+	send := p.send[i]
+	select {
+	case send <- messages:
+		return nil
+	default:
+	}
+
+	atomic.AddUint64(&p.busy, 1)
+	if nosuspend {
+		return ErrSendQueueFull
+	}
+
+	switch p.policy {
+	case SendQueueDropNewest:
+		atomic.AddUint64(&p.dropped, 1)
+		return ErrSendQueueFull
+
+	case SendQueueDropOldest:
+		if p.evict(i, messages) {
+			return nil
+		}
+		// there are the control signals only. wait for the free space
+
+	case SendQueueDisconnect:
+		lib.Log("send queue of %s is full. disconnecting", p.name)
+		p.close("send_queue_overflow")
+		return ErrSendQueueFull
+	}
+
+	return p.wait(send, messages)
+}
 
-	c := p.send[p.i]
+// sendControl puts the control signal (link, exit, monitor etc.) into the queue.
+// Drop policies are not applied to the control signals since losing them breaks
+// the links and monitors (SendQueueDropOldest drops the oldest message to make
+// room for the signal). The sender is blocked up to the SendQueueTimeout, then
+// the connection is closed (immediately if the policy is SendQueueDisconnect)
+func (p *peer) sendControl(messages []etf.Term) error {
+	p.sendMutex.RLock()
+	defer p.sendMutex.RUnlock()
 
-	p.i++
-	if p.i < p.n {
-		return c
+	if p.closed {
+		return ErrNodeUnreachable
 	}
 
-	p.i = 0
-	return c
+	i := distShard(messages[0], p.sendSender, p.n)
+	p.sendLocks[i].Lock()
+	defer p.sendLocks[i].Unlock()
+
+	send := p.send[i]
+	select {
+	case send <- messages:
+		return nil
+	default:
+	}
+
+	atomic.AddUint64(&p.busy, 1)
+	switch p.policy {
+	case SendQueueDropOldest:
+		if p.evict(i, messages) {
+			return nil
+		}
+	case SendQueueDisconnect:
+		lib.Log("send queue of %s is full. disconnecting", p.name)
+		p.close("send_queue_overflow")
+		return ErrSendQueueFull
+	}
+
+	if err := p.wait(send, messages); err != ErrSendQueueFull {
+		return err
+	}
+	lib.Log("send queue of %s is full. can't send the control signal. disconnecting", p.name)
+	p.close("send_queue_overflow")
+	return ErrSendQueueFull
+}
+
+// evict drops the oldest message (control signals are kept) from the queue
+// and puts the given messages at the end of it. Returns false if there is
+// nothing to drop. The queue must be locked by the caller
+func (p *peer) evict(i int, messages []etf.Term) bool {
+	send := p.send[i]
+	queued := make([][]etf.Term, 0, cap(send))
+	for len(queued) < cap(send) {
+		select {
+		case m := <-send:
+			queued = append(queued, m)
+			continue
+		default:
+		}
+		break
+	}
+
+	evicted := false
+	for _, m := range queued {
+		if !evicted && !distIsControlSignal(m[0]) {
+			evicted = true
+			atomic.AddUint64(&p.dropped, 1)
+			continue
+		}
+		// can't block. the queue has been drained and the other
+		// senders are waiting for the lock
+		send <- m
+	}
+	if !evicted {
+		// the writer could have taken some of them
+		select {
+		case send <- messages:
+			return true
+		default:
+			return false
+		}
+	}
+	send <- messages
+	return true
+}
+
+// wait waits for the free space in the queue up to the SendQueueTimeout
+func (p *peer) wait(send chan []etf.Term, messages []etf.Term) error {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case send <- messages:
+		return nil
+	case <-p.done:
+		return ErrNodeUnreachable
+	case <-timer.C:
+		atomic.AddUint64(&p.timedOut, 1)
+		return ErrSendQueueFull
+	}
+}
+
+// closeSend closes the queues (the writers are stopped). Blocked senders
+// get ErrNodeUnreachable
+func (p *peer) closeSend() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.sendMutex.Lock()
+		defer p.sendMutex.Unlock()
+		p.closed = true
+		for i := range p.send {
+			close(p.send[i])
+		}
+	})
+}
+
+// drain closes the queues and waits until the writers have sent
+// all the queued messages
+func (p *peer) drain(ctx context.Context) error {
+	p.closeSend()
+	drained := make(chan struct{})
+	go func() {
+		p.writers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return p.link.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *peer) stats() PeerStats {
+	stats := PeerStats{
+		Busy:     atomic.LoadUint64(&p.busy),
+		Dropped:  atomic.LoadUint64(&p.dropped),
+		TimedOut: atomic.LoadUint64(&p.timedOut),
+	}
+	for i := range p.send {
+		stats.SendQueueLength += len(p.send[i])
+	}
+	return stats
+}
+
+// distShard returns the index (0..n-1) of the reader/writer for the given
+// control message. Both sides of the link use it, so the messages and signals
+// of the same sender (and the same sender/receiver pair) go through the same
+// writer and the same reader. The sender of the messages is unknown to the
+// reader if the peer doesn't support SEND_SENDER, so a single reader/writer
+// is used for such peers
+func distShard(control etf.Term, sendSender bool, n int) int {
+	if !sendSender {
+		return 0
+	}
+	return shard(distControlSender(control), n)
+}
+
+// shard returns the index (0..n-1) of the reader/writer for the given sender
+func shard(sender etf.Term, n int) int {
+	if pid, ok := sender.(etf.Pid); ok {
+		return int(pid.ID % uint32(n))
+	}
+	return 0
 }